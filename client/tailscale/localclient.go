@@ -368,6 +368,16 @@ func (lc *LocalClient) DebugAction(ctx context.Context, action string) error {
 	return nil
 }
 
+// DebugForcePreferredDERP forces the preferred DERP region to regionID,
+// or restores automatic selection if regionID is zero.
+func (lc *LocalClient) DebugForcePreferredDERP(ctx context.Context, regionID int) error {
+	body, err := lc.send(ctx, "POST", fmt.Sprintf("/localapi/v0/debug?action=force-preferred-derp&region=%d", regionID), 200, nil)
+	if err != nil {
+		return fmt.Errorf("error %w: %s", err, body)
+	}
+	return nil
+}
+
 // DebugPortmap invokes the debug-portmap endpoint, and returns an
 // io.ReadCloser that can be used to read the logs that are printed during this
 // process.
@@ -26,6 +26,11 @@ import (
 // including its on-wire framing overhead)
 const MaxPacketSize = 64 << 10
 
+// MaxJumboPacketSize is the upper bound for the negotiable per-packet
+// size limit; servers configured for jumbo frames advertise their limit
+// (above MaxPacketSize, at most this) in the server info frame.
+const MaxJumboPacketSize = 256 << 10
+
 // magic is the DERP magic number, sent in the frameServerKey frame
 // upon initial connection.
 const magic = "DERP🔑" // 8 bytes: 0x44 45 52 50 f0 9f 94 91
@@ -32,10 +32,14 @@ type Client struct {
 	meshKey     string
 	canAckPings bool
 	isProber    bool
+	linkMTU     int
 
 	wmu  sync.Mutex // hold while writing to bw
 	bw   *bufio.Writer
 	rate *rate.Limiter // if non-nil, rate limiter to use
+	// maxFrameSize, guarded by wmu, is the negotiated per-packet size
+	// limit when the server advertised one above MaxPacketSize.
+	maxFrameSize int
 
 	// Owned by Recv:
 	peeked  int                      // bytes to discard on next Recv
@@ -57,6 +61,7 @@ type clientOpt struct {
 	ServerPub   key.NodePublic
 	CanAckPings bool
 	IsProber    bool
+	LinkMTU     int
 }
 
 // MeshKey returns a ClientOpt to pass to the DERP server during connect to get
@@ -81,6 +86,13 @@ func CanAckPings(v bool) ClientOpt {
 	return clientOptFunc(func(o *clientOpt) { o.CanAckPings = v })
 }
 
+// LinkMTU returns a ClientOpt to advertise the MTU of the client's
+// underlying network link to the server, for metrics. Zero means
+// unknown and is not sent.
+func LinkMTU(mtu int) ClientOpt {
+	return clientOptFunc(func(o *clientOpt) { o.LinkMTU = mtu })
+}
+
 func NewClient(privateKey key.NodePrivate, nc Conn, brw *bufio.ReadWriter, logf logger.Logf, opts ...ClientOpt) (*Client, error) {
 	var opt clientOpt
 	for _, o := range opts {
@@ -103,6 +115,7 @@ func newClient(privateKey key.NodePrivate, nc Conn, brw *bufio.ReadWriter, logf
 		meshKey:     opt.MeshKey,
 		canAckPings: opt.CanAckPings,
 		isProber:    opt.IsProber,
+		linkMTU:     opt.LinkMTU,
 	}
 	if opt.ServerPub.IsZero() {
 		if err := c.recvServerKey(); err != nil {
@@ -171,6 +184,11 @@ type clientInfo struct {
 
 	// IsProber is whether this client is a prober.
 	IsProber bool `json:",omitempty"`
+
+	// LinkMTU is the MTU of the client's underlying network link,
+	// if known. The server aggregates it into metrics to help tune
+	// packetization; zero means unknown.
+	LinkMTU int `json:",omitempty"`
 }
 
 func (c *Client) sendClientKey() error {
@@ -179,6 +197,7 @@ func (c *Client) sendClientKey() error {
 		MeshKey:     c.meshKey,
 		CanAckPings: c.canAckPings,
 		IsProber:    c.isProber,
+		LinkMTU:     c.linkMTU,
 	})
 	if err != nil {
 		return err
@@ -206,12 +225,11 @@ func (c *Client) send(dstKey key.NodePublic, pkt []byte) (ret error) {
 		}
 	}()
 
-	if len(pkt) > MaxPacketSize {
-		return fmt.Errorf("packet too big: %d", len(pkt))
-	}
-
 	c.wmu.Lock()
 	defer c.wmu.Unlock()
+	if len(pkt) > c.maxPacketSizeLocked() {
+		return fmt.Errorf("packet too big: %d", len(pkt))
+	}
 	if c.rate != nil {
 		pktLen := frameHeaderLen + key.NodePublicRawLen + len(pkt)
 		if !c.rate.AllowN(time.Now(), pktLen) {
@@ -237,12 +255,11 @@ func (c *Client) ForwardPacket(srcKey, dstKey key.NodePublic, pkt []byte) (err e
 		}
 	}()
 
-	if len(pkt) > MaxPacketSize {
-		return fmt.Errorf("packet too big: %d", len(pkt))
-	}
-
 	c.wmu.Lock()
 	defer c.wmu.Unlock()
+	if len(pkt) > c.maxPacketSizeLocked() {
+		return fmt.Errorf("packet too big: %d", len(pkt))
+	}
 
 	timer := time.AfterFunc(5*time.Second, c.writeTimeoutFired)
 	defer timer.Stop()
@@ -379,6 +396,11 @@ type ServerInfoMessage struct {
 	// Zero means unspecified. There might be a limit, but the
 	// client need not try to respect it.
 	TokenBucketBytesBurst int
+
+	// MaxFrameSize, if non-zero, is the per-packet payload size limit
+	// the server accepts beyond the standard MaxPacketSize (jumbo
+	// frames).
+	MaxFrameSize int
 }
 
 func (ServerInfoMessage) msg() {}
@@ -515,8 +537,10 @@ func (c *Client) recvTimeout(timeout time.Duration) (m ReceivedMessage, err erro
 			sm := ServerInfoMessage{
 				TokenBucketBytesPerSecond: si.TokenBucketBytesPerSecond,
 				TokenBucketBytesBurst:     si.TokenBucketBytesBurst,
+				MaxFrameSize:              si.MaxFrameSize,
 			}
 			c.setSendRateLimiter(sm)
+			c.setMaxFrameSize(sm)
 			return sm, nil
 		case frameKeepAlive:
 			// A one-way keep-alive message that doesn't require an acknowledgement.
@@ -590,6 +614,26 @@ func (c *Client) recvTimeout(timeout time.Duration) (m ReceivedMessage, err erro
 	}
 }
 
+// maxPacketSizeLocked returns the per-packet payload size limit in
+// effect for sends. c.wmu must be held.
+func (c *Client) maxPacketSizeLocked() int {
+	if c.maxFrameSize > 0 {
+		return c.maxFrameSize
+	}
+	return MaxPacketSize
+}
+
+// setMaxFrameSize records the server-advertised packet size limit, if
+// it's a sane jumbo frame limit.
+func (c *Client) setMaxFrameSize(sm ServerInfoMessage) {
+	if sm.MaxFrameSize <= MaxPacketSize || sm.MaxFrameSize > MaxJumboPacketSize {
+		return
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.maxFrameSize = sm.MaxFrameSize
+}
+
 func (c *Client) setSendRateLimiter(sm ServerInfoMessage) {
 	c.wmu.Lock()
 	defer c.wmu.Unlock()
@@ -3,12 +3,15 @@ package derp
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"expvar"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -267,6 +270,168 @@ func (s *Server) PullNodesList() error {
 	return nil
 }
 
+// SetPresenceSalt sets the salt used to hash client keys in presence
+// reports. When empty (the default), raw keys are reported.
+//
+// It must be called before serving begins.
+func (s *Server) SetPresenceSalt(v string) {
+	s.presenceSalt = v
+}
+
+// PresenceReport is the payload posted to the controller's
+// /navi/presence endpoint.
+type PresenceReport struct {
+	Navi      string     // 本服务器的DERP ID
+	Hashed    bool       // Clients是否为加盐SHA-256哈希
+	Clients   []string   // 当前连接客户端的节点公钥（或其哈希）
+	Timestamp *time.Time `json:"Timestamp"`
+}
+
+// ReportPresence uploads the set of currently connected client public
+// keys to the controller, so the admin panel can show "last seen via
+// relay" presence for devices with no direct connections. Keys are
+// hashed with the configured salt if one was set.
+func (s *Server) ReportPresence() error {
+	if s.nc == nil {
+		return nil // 非受管模式
+	}
+	now := time.Now()
+	report := PresenceReport{
+		Navi:      s.derpID,
+		Hashed:    s.presenceSalt != "",
+		Timestamp: &now,
+	}
+	s.mu.Lock()
+	report.Clients = make([]string, 0, len(s.clients))
+	for k := range s.clients {
+		nk := strings.TrimPrefix(k.String(), "nodekey:")
+		if s.presenceSalt != "" {
+			sum := sha256.Sum256([]byte(s.presenceSalt + nk))
+			nk = hex.EncodeToString(sum[:])
+		}
+		report.Clients = append(report.Clients, nk)
+	}
+	s.mu.Unlock()
+
+	bodyData, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("presence report: %w", err)
+	}
+	url := fmt.Sprintf("%s/navi/presence", s.ctrlURL)
+	url = strings.Replace(url, "http:", "https:", 1)
+	req, err := http.NewRequestWithContext(s.ctx, "POST", url, bytes.NewReader(bodyData))
+	if err != nil {
+		return fmt.Errorf("presence report: %w", err)
+	}
+	res, err := s.nc.Do(req)
+	if err != nil {
+		return fmt.Errorf("presence report: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("presence report: http %d: %.200s",
+			res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+// HealthReport is the payload posted to the controller's /navi/health
+// endpoint.
+type HealthReport struct {
+	Navi      string // 本服务器的DERP ID
+	Component string // 出问题的组件，如dns-credential
+	Healthy   bool
+	Detail    string `json:",omitempty"`
+	Timestamp *time.Time
+}
+
+// ReportHealth informs the controller that a server component became
+// healthy or unhealthy, so operators hear about e.g. an expired DNS
+// API token weeks before the certificate renewal that needs it. It is
+// a no-op in unmanaged mode.
+func (s *Server) ReportHealth(component string, healthy bool, detail string) error {
+	if s.nc == nil {
+		return nil // 非受管模式
+	}
+	now := time.Now()
+	report := HealthReport{
+		Navi:      s.derpID,
+		Component: component,
+		Healthy:   healthy,
+		Detail:    detail,
+		Timestamp: &now,
+	}
+	bodyData, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("health report: %w", err)
+	}
+	url := fmt.Sprintf("%s/navi/health", s.ctrlURL)
+	url = strings.Replace(url, "http:", "https:", 1)
+	req, err := http.NewRequestWithContext(s.ctx, "POST", url, bytes.NewReader(bodyData))
+	if err != nil {
+		return fmt.Errorf("health report: %w", err)
+	}
+	res, err := s.nc.Do(req)
+	if err != nil {
+		return fmt.Errorf("health report: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("health report: http %d: %.200s",
+			res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+type VerifyMeshPeerResponse struct {
+	Trusted bool `json:"Trusted"`
+}
+
+// verifyMeshPeer asks the controller, over the noise channel, whether
+// peerKey belongs to a relay registered with it, so a stolen mesh PSK
+// alone doesn't let a rogue relay join the mesh. Positive answers are
+// cached; a controller outage therefore only affects new mesh peers.
+func (s *Server) verifyMeshPeer(peerKey key.NodePublic) error {
+	if s.nc == nil {
+		return nil // 非受管模式：仅凭mesh PSK
+	}
+	cacheKey := "meshpeer:" + strings.TrimPrefix(peerKey.String(), "nodekey:")
+	if _, ok := s.trustNodesCache.Get(cacheKey); ok {
+		return nil
+	}
+	request := struct {
+		Navi    string
+		PeerKey key.NodePublic
+	}{Navi: s.derpID, PeerKey: peerKey}
+	bodyData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("mesh peer request: %w", err)
+	}
+	url := fmt.Sprintf("%s/navi/peer", s.ctrlURL)
+	url = strings.Replace(url, "http:", "https:", 1)
+	req, err := http.NewRequestWithContext(s.ctx, "POST", url, bytes.NewReader(bodyData))
+	if err != nil {
+		return fmt.Errorf("mesh peer request: %w", err)
+	}
+	res, err := s.nc.Do(req)
+	if err != nil {
+		return fmt.Errorf("mesh peer request: %w", err)
+	}
+	resp := VerifyMeshPeerResponse{}
+	if err := decode(res, &resp); err != nil {
+		return fmt.Errorf("mesh peer request: %v", err)
+	}
+	if !resp.Trusted {
+		return fmt.Errorf("mesh peer %s not registered with controller", peerKey.ShortString())
+	}
+	s.trustNodesCache.Set(cacheKey, struct{}{}, 10*time.Minute)
+	return nil
+}
+
 const (
 	noContentChallengeHeader = "X-Tailscale-Challenge"
 	noContentResponseHeader  = "X-Tailscale-Response"
@@ -290,6 +455,69 @@ func serveNoContent(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// NaviInfoReport is the payload of the /ctrl/info management RPC:
+// build and runtime details the controller uses to render its relay
+// inventory without scraping the debug HTTP endpoints.
+type NaviInfoReport struct {
+	Navi       string // 本服务器的DERP ID
+	Version    string // 构建版本
+	GoVersion  string
+	StartTime  time.Time
+	Uptime     string            // 便于人读的运行时长
+	Flags      map[string]string `json:",omitempty"` // 生效的启动参数（敏感值已脱敏）
+	CertMode   string            `json:",omitempty"`
+	CertExpiry *time.Time        `json:",omitempty"`
+	CertFP     string            `json:",omitempty"` // 自签名证书的SHA-256指纹，供受管客户端校验
+
+	Listeners []string `json:",omitempty"` // 各监听地址，如https/stun/noise
+}
+
+// MeshPeers returns the labels of the mesh packet forwarders
+// currently registered, i.e. the other relays clients are reachable
+// through.
+func (s *Server) MeshPeers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := map[string]bool{}
+	var out []string
+	for _, fwd := range s.clientsMesh {
+		if fwd == nil {
+			continue
+		}
+		lbl := fwd.String()
+		if !seen[lbl] {
+			seen[lbl] = true
+			out = append(out, lbl)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// CurrentClients returns the number of connected DERP clients.
+func (s *Server) CurrentClients() int64 {
+	return s.curClients.Value()
+}
+
+// SetInfoReportFunc sets the function that builds the /ctrl/info
+// management RPC response. The frontend supplies it because flag and
+// certificate state live there, not in this package.
+//
+// It must be called before serving begins.
+func (s *Server) SetInfoReportFunc(f func() NaviInfoReport) {
+	s.infoReportFunc = f
+}
+
+// NoiseInfoHandler serves the /ctrl/info management RPC.
+func (t *ts2021App) NoiseInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if t.navi.infoReportFunc == nil {
+		http.Error(w, "info report not configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.navi.infoReportFunc())
+}
+
 type ts2021App struct {
 	navi *Server
 	conn *controlbase.Conn
@@ -329,6 +557,8 @@ func (s *Server) NoiseUpgradeHandler(
 	router.HandleFunc("/ctrl/nodes", ts2021App.NoiseNodeChangeHandler).
 		Methods(http.MethodPost)
 	router.Handle("/ctrl/vars", expvar.Handler())
+	router.HandleFunc("/ctrl/info", ts2021App.NoiseInfoHandler).
+		Methods(http.MethodGet)
 	router.Handle("/generate_204", http.HandlerFunc(serveNoContent))
 
 	server := http.Server{
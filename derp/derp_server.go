@@ -12,6 +12,7 @@ import (
 	crand "crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"expvar"
@@ -26,6 +27,8 @@ import (
 	"net/netip"
 	"os/exec"
 	"runtime"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -104,6 +107,7 @@ type Server struct {
 
 	trustNodesCache *cache.Cache // 用于存储受信客户端信息
 	Cronjob         *cron.Cron   // 用于定时从控制器拉取受信客户端信息
+	presenceSalt    string       // 非空时在线列表上报采用加盐哈希
 
 	// WriteTimeout, if non-zero, specifies how long to wait
 	// before failing when writing to a client.
@@ -135,8 +139,14 @@ type Server struct {
 	_                            align64
 	packetsForwardedOut          expvar.Int
 	packetsForwardedIn           expvar.Int
+	meshForwardedIn              metrics.LabelMap // packets received via mesh, per peer relay
+	meshForwardedInBytes         metrics.LabelMap
+	meshForwardedOut             metrics.LabelMap // packets sent via mesh, per peer relay
+	meshForwardedOutBytes        metrics.LabelMap
 	peerGoneDisconnectedFrames   expvar.Int // number of peer disconnected frames sent
 	peerGoneNotHereFrames        expvar.Int // number of peer not here frames sent
+	meshPeerPresentSent          expvar.Int // peer-present state changes written to mesh watchers
+	meshPeerGoneSent             expvar.Int // peer-gone state changes written to mesh watchers
 	gotPing                      expvar.Int // number of ping frames from client
 	sentPong                     expvar.Int // number of pong frames enqueued to client
 	accepts                      expvar.Int
@@ -146,6 +156,13 @@ type Server struct {
 	dupClientConns               expvar.Int // current number of connections sharing a public key
 	dupClientConnTotal           expvar.Int // total number of accepted connections when a dup key existed
 	unknownFrames                expvar.Int
+	strictDisconnects            expvar.Int // clients disconnected by strict conformance mode
+	oversizeFrames               expvar.Int // packets rejected for exceeding the size limit
+	connectionsRecycled          expvar.Int // clients asked to reconnect for exceeding the max age
+	prioritizedPackets           expvar.Int // packets routed via the priority queue for control-plane keys
+	sentPing                     expvar.Int // number of ping frames sent to clients
+	gotPong                      expvar.Int // number of pong frames received from clients
+	panicsRecovered              expvar.Int // connection handler panics recovered instead of crashing the process
 	homeMovesIn                  expvar.Int // established clients announce home server moves in
 	homeMovesOut                 expvar.Int // established clients announce home server moves out
 	multiForwarderCreated        expvar.Int
@@ -153,11 +170,55 @@ type Server struct {
 	removePktForwardOther        expvar.Int
 	avgQueueDuration             *uint64          // In milliseconds; accessed atomically
 	tcpRtt                       metrics.LabelMap // histogram
+	packetSize                   metrics.LabelMap // histogram of relayed packet payload sizes
+	clientLinkMTU                metrics.LabelMap // client advertised link MTUs at connect
+	sourceAddrChanges            metrics.LabelMap // reconnects from a changed source address, by kind (port/ip)
+	rebindIntervals              metrics.LabelMap // histogram of time between such reconnects
 
 	// verifyClients only accepts client connections to the DERP server if the clientKey is a
 	// known peer in the network, as specified by a running tailscaled's client's LocalAPI.
 	verifyClients bool
 
+	// strictConformance makes the server disconnect clients that send
+	// malformed or out-of-spec frames instead of tolerating them.
+	strictConformance bool
+
+	// maxFrameSize, if non-zero, is the per-packet payload size limit
+	// in effect, possibly above MaxPacketSize for jumbo frames.
+	maxFrameSize int
+
+	// maxConnAge, if non-zero, is how long a client connection may
+	// live before the server asks it to reconnect.
+	maxConnAge time.Duration
+
+	// controlPlaneKeys are node keys whose traffic is control-plane
+	// coordination (e.g. the controller itself on a managed relay)
+	// and rides the priority queue ahead of bulk data.
+	controlPlaneKeys map[key.NodePublic]bool
+
+	// pingInterval, if non-zero, is how often the server pings
+	// connected clients that can ack pings to measure RTT.
+	pingInterval time.Duration
+
+	// crashReportFunc, if non-nil, receives a report for each panic
+	// recovered from a connection handler.
+	crashReportFunc func(CrashReport)
+
+	// infoReportFunc, if non-nil, builds the /ctrl/info management
+	// RPC response; see SetInfoReportFunc.
+	infoReportFunc func() NaviInfoReport
+
+	// drainCh is closed by Drain to ask every connected client to
+	// reconnect elsewhere, for graceful shutdown.
+	drainCh   chan struct{}
+	drainOnce sync.Once
+
+	// rttMu guards rttSamples/rttNext, the ring of recent
+	// client RTT measurements used for the percentile gauges.
+	rttMu      sync.Mutex
+	rttSamples []time.Duration
+	rttNext    int
+
 	mu       sync.Mutex
 	closed   bool
 	netConns map[Conn]chan struct{} // chan is closed when conn closes
@@ -169,6 +230,9 @@ type Server struct {
 	// remote). If the value is non-nil, it's remote (+ maybe also
 	// local).
 	clientsMesh map[key.NodePublic]PacketForwarder
+	// fwdLabels caches the metrics label for each mesh packet forwarder,
+	// so the hot forwarding path doesn't rebuild it per packet.
+	fwdLabels map[PacketForwarder]string
 	// sentTo tracks which peers have sent to which other peers,
 	// and at which connection number. This isn't on sclient
 	// because it includes intra-region forwarded packets as the
@@ -177,6 +241,10 @@ type Server struct {
 
 	// maps from netip.AddrPort to a client's public key
 	keyOfAddr map[netip.AddrPort]key.NodePublic
+
+	// lastAddrOfKey remembers the last source address each node key
+	// connected from, for the NAT rebind analytics in rebind.go.
+	lastAddrOfKey map[key.NodePublic]addrSeen
 }
 
 // clientSet represents 1 or more *sclients.
@@ -314,23 +382,34 @@ func NewServer(privateKey key.NodePrivate, logf logger.Logf) *Server {
 	runtime.ReadMemStats(&ms)
 
 	s := &Server{
-		debug:                envknob.Bool("DERP_DEBUG_LOGS"),
-		privateKey:           privateKey,
-		publicKey:            privateKey.Public(),
-		logf:                 logf,
-		limitedLogf:          logger.RateLimitedFn(logf, 30*time.Second, 5, 100),
-		packetsRecvByKind:    metrics.LabelMap{Label: "kind"},
-		packetsDroppedReason: metrics.LabelMap{Label: "reason"},
-		packetsDroppedType:   metrics.LabelMap{Label: "type"},
-		clients:              map[key.NodePublic]clientSet{},
-		clientsMesh:          map[key.NodePublic]PacketForwarder{},
-		netConns:             map[Conn]chan struct{}{},
-		memSys0:              ms.Sys,
-		watchers:             map[*sclient]bool{},
-		sentTo:               map[key.NodePublic]map[key.NodePublic]int64{},
-		avgQueueDuration:     new(uint64),
-		tcpRtt:               metrics.LabelMap{Label: "le"},
-		keyOfAddr:            map[netip.AddrPort]key.NodePublic{},
+		debug:                 envknob.Bool("DERP_DEBUG_LOGS"),
+		privateKey:            privateKey,
+		publicKey:             privateKey.Public(),
+		logf:                  logf,
+		limitedLogf:           logger.RateLimitedFn(logf, 30*time.Second, 5, 100),
+		packetsRecvByKind:     metrics.LabelMap{Label: "kind"},
+		packetsDroppedReason:  metrics.LabelMap{Label: "reason"},
+		packetsDroppedType:    metrics.LabelMap{Label: "type"},
+		packetSize:            metrics.LabelMap{Label: "bytes"},
+		clientLinkMTU:         metrics.LabelMap{Label: "mtu"},
+		clients:               map[key.NodePublic]clientSet{},
+		clientsMesh:           map[key.NodePublic]PacketForwarder{},
+		netConns:              map[Conn]chan struct{}{},
+		drainCh:               make(chan struct{}),
+		memSys0:               ms.Sys,
+		watchers:              map[*sclient]bool{},
+		sentTo:                map[key.NodePublic]map[key.NodePublic]int64{},
+		avgQueueDuration:      new(uint64),
+		tcpRtt:                metrics.LabelMap{Label: "le"},
+		keyOfAddr:             map[netip.AddrPort]key.NodePublic{},
+		lastAddrOfKey:         map[key.NodePublic]addrSeen{},
+		sourceAddrChanges:     metrics.LabelMap{Label: "kind"},
+		rebindIntervals:       metrics.LabelMap{Label: "interval"},
+		meshForwardedIn:       metrics.LabelMap{Label: "relay"},
+		meshForwardedInBytes:  metrics.LabelMap{Label: "relay"},
+		meshForwardedOut:      metrics.LabelMap{Label: "relay"},
+		meshForwardedOutBytes: metrics.LabelMap{Label: "relay"},
+		fwdLabels:             map[PacketForwarder]string{},
 	}
 
 	s.initMetacert()
@@ -365,6 +444,84 @@ func (s *Server) SetVerifyClient(v bool) {
 	s.verifyClients = v
 }
 
+// SetMaxFrameSize sets the maximum packet payload size the server
+// accepts, clamped between MaxPacketSize and MaxJumboPacketSize. A
+// limit above MaxPacketSize is advertised to clients in the server
+// info frame so capable clients can send jumbo frames.
+//
+// It must be called before serving begins.
+func (s *Server) SetMaxFrameSize(n int) {
+	if n < MaxPacketSize {
+		n = MaxPacketSize
+	}
+	if n > MaxJumboPacketSize {
+		n = MaxJumboPacketSize
+	}
+	s.maxFrameSize = n
+}
+
+// maxPacketSize returns the per-packet payload size limit in effect.
+func (s *Server) maxPacketSize() uint32 {
+	if s.maxFrameSize > 0 {
+		return uint32(s.maxFrameSize)
+	}
+	return MaxPacketSize
+}
+
+// SetMaxConnectionAge sets an optional maximum client connection
+// lifetime. Connections older than d (plus per-connection jitter, to
+// smear out the resulting reconnects) are sent a graceful reconnect
+// hint; without it, long-lived connections never rebalance onto relays
+// added after they connected.
+//
+// It must be called before serving begins.
+func (s *Server) SetMaxConnectionAge(d time.Duration) {
+	s.maxConnAge = d
+}
+
+// SetControlPlaneKeys marks the given node keys as carrying
+// control-plane coordination traffic. Packets to or from those keys
+// are queued ahead of bulk data (on the same queue disco frames use),
+// keeping coordination latency low when the relay is congested. It
+// only affects connections accepted after the call.
+//
+// It must be called before serving begins.
+func (s *Server) SetControlPlaneKeys(keys []key.NodePublic) {
+	s.controlPlaneKeys = make(map[key.NodePublic]bool, len(keys))
+	for _, k := range keys {
+		s.controlPlaneKeys[k] = true
+	}
+}
+
+// SetPingInterval enables periodic server-to-client pings at the
+// given interval (with per-connection jitter) to measure
+// application-level RTT. Only clients that declared CanAckPings are
+// pinged. Zero disables the measurement.
+//
+// It must be called before serving begins.
+func (s *Server) SetPingInterval(d time.Duration) {
+	s.pingInterval = d
+}
+
+// SetCrashReportFunc sets an optional hook that receives a report for
+// each panic recovered from a connection handler, e.g. to upload it to
+// the controller. The hook runs in its own goroutine; the panic is
+// logged and counted regardless of whether a hook is set.
+//
+// It must be called before serving begins.
+func (s *Server) SetCrashReportFunc(f func(CrashReport)) {
+	s.crashReportFunc = f
+}
+
+// SetStrictConformance sets whether clients sending malformed or
+// out-of-spec DERP frames are disconnected rather than tolerated. The
+// offending frame type is logged either way.
+//
+// It must be called before serving begins.
+func (s *Server) SetStrictConformance(v bool) {
+	s.strictConformance = v
+}
+
 // HasMeshKey reports whether the server is configured with a mesh key.
 func (s *Server) HasMeshKey() bool { return s.meshKey != "" }
 
@@ -446,11 +603,54 @@ func (s *Server) Accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 		s.mu.Unlock()
 	}()
 
-	if err := s.accept(ctx, nc, brw, remoteAddr, connNum); err != nil && !s.isClosed() {
+	err := func() (err error) {
+		defer s.recoverAndReport(&err, remoteAddr, nil)
+		return s.accept(ctx, nc, brw, remoteAddr, connNum)
+	}()
+	if err != nil && !s.isClosed() {
 		s.logf("derp: %s: %v", remoteAddr, err)
 	}
 }
 
+// CrashReport describes a panic recovered from a connection handler.
+type CrashReport struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remoteAddr"`
+	ClientKey  string    `json:"clientKey,omitempty"` // short form; empty if the panic hit before the key exchange
+	LastFrame  string    `json:"lastFrame,omitempty"` // type and length of the most recent frame read from the client
+	Panic      string    `json:"panic"`
+	Stack      string    `json:"stack"`
+}
+
+// recoverAndReport converts a panic in a connection handler into an
+// error on *errp, so one misbehaving connection can't take down the
+// whole relay. c is nil if the panic happened before the client was
+// registered.
+func (s *Server) recoverAndReport(errp *error, remoteAddr string, c *sclient) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	s.panicsRecovered.Add(1)
+	r := CrashReport{
+		Time:       time.Now(),
+		RemoteAddr: remoteAddr,
+		Panic:      fmt.Sprint(v),
+		Stack:      string(debug.Stack()),
+	}
+	if c != nil {
+		r.ClientKey = c.key.ShortString()
+		if meta := c.lastFrameMeta.Load(); meta != 0 {
+			r.LastFrame = fmt.Sprintf("type=%d len=%d", uint32(meta>>32), uint32(meta))
+		}
+	}
+	s.logf("derp: %s: recovered panic in connection handler: %v\n%s", remoteAddr, v, r.Stack)
+	if f := s.crashReportFunc; f != nil {
+		go f(r)
+	}
+	*errp = fmt.Errorf("recovered panic: %v", v)
+}
+
 // initMetacert initialized s.metaCert with a self-signed x509 cert
 // encoding this server's public key and protocol version. cmd/derper
 // then sends this after the Let's Encrypt leaf + intermediate certs
@@ -543,6 +743,7 @@ func (s *Server) registerClient(c *sclient) {
 		s.clientsMesh[c.key] = nil // just for varz of total users in cluster
 	}
 	s.keyOfAddr[c.remoteIPPort] = c.key
+	s.noteSourceAddrLocked(c)
 	s.curClients.Add(1)
 	s.broadcastPeerStateChangeLocked(c.key, true)
 }
@@ -691,6 +892,15 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 	if err := s.verifyClient(clientKey, clientInfo); err != nil {
 		return fmt.Errorf("client %x rejected: %v", clientKey, err)
 	}
+	canMesh := clientInfo != nil && clientInfo.MeshKey != "" && clientInfo.MeshKey == s.meshKey
+	if canMesh {
+		// A correct mesh PSK alone isn't sufficient in managed
+		// mode; the peer relay must also be registered with our
+		// controller.
+		if err := s.verifyMeshPeer(clientKey); err != nil {
+			return fmt.Errorf("mesh peer %x rejected: %v", clientKey, err)
+		}
+	}
 
 	// At this point we trust the client so we don't time out.
 	nc.SetDeadline(time.Time{})
@@ -712,11 +922,12 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 		remoteAddr:     remoteAddr,
 		remoteIPPort:   remoteIPPort,
 		connectedAt:    time.Now(),
+		prioritized:    s.controlPlaneKeys[clientKey],
 		sendQueue:      make(chan pkt, perClientSendQueueDepth),
 		discoSendQueue: make(chan pkt, perClientSendQueueDepth),
 		sendPongCh:     make(chan [8]byte, 1),
 		peerGone:       make(chan peerGoneMsg),
-		canMesh:        clientInfo.MeshKey != "" && clientInfo.MeshKey == s.meshKey,
+		canMesh:        canMesh,
 		peerGoneLim:    rate.NewLimiter(rate.Every(time.Second), 3),
 	}
 
@@ -728,6 +939,9 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 		if envknob.Bool("DERP_PROBER_DEBUG_LOGS") && clientInfo.IsProber {
 			c.debugLogging = true
 		}
+		if clientInfo.LinkMTU > 0 {
+			s.clientLinkMTU.Add(strconv.Itoa(clientInfo.LinkMTU), 1)
+		}
 	}
 
 	s.registerClient(c)
@@ -749,12 +963,20 @@ var (
 
 // run serves the client until there's an error.
 // If the client hangs up or the server is closed, run returns nil, otherwise run returns an error.
-func (c *sclient) run(ctx context.Context) error {
+func (c *sclient) run(ctx context.Context) (err error) {
+	defer c.s.recoverAndReport(&err, c.remoteAddr, c)
+
 	// Launch sender, but don't return from run until sender goroutine is done.
 	var grp errgroup.Group
 	sendCtx, cancelSender := context.WithCancel(ctx)
-	grp.Go(func() error { return c.sendLoop(sendCtx) })
-	grp.Go(func() error { return c.statsLoop(sendCtx) })
+	grp.Go(func() (err error) {
+		defer c.s.recoverAndReport(&err, c.remoteAddr, c)
+		return c.sendLoop(sendCtx)
+	})
+	grp.Go(func() (err error) {
+		defer c.s.recoverAndReport(&err, c.remoteAddr, c)
+		return c.statsLoop(sendCtx)
+	})
 	defer func() {
 		cancelSender()
 		if err := grp.Wait(); err != nil && !c.s.isClosed() {
@@ -776,6 +998,8 @@ func (c *sclient) run(ctx context.Context) error {
 			}
 			return fmt.Errorf("client %s: readFrameHeader: %w", c.key.ShortString(), err)
 		}
+		c.lastRecv.Store(time.Now().UnixNano())
+		c.lastFrameMeta.Store(uint64(ft)<<32 | uint64(fl))
 		c.s.noteClientActivity(c)
 		switch ft {
 		case frameNotePreferred:
@@ -790,6 +1014,8 @@ func (c *sclient) run(ctx context.Context) error {
 			err = c.handleFrameClosePeer(ft, fl)
 		case framePing:
 			err = c.handleFramePing(ft, fl)
+		case framePong:
+			err = c.handleFramePong(ft, fl)
 		default:
 			err = c.handleUnknownFrame(ft, fl)
 		}
@@ -800,6 +1026,10 @@ func (c *sclient) run(ctx context.Context) error {
 }
 
 func (c *sclient) handleUnknownFrame(ft frameType, fl uint32) error {
+	if c.s.strictConformance {
+		c.s.strictDisconnects.Add(1)
+		return fmt.Errorf("client %s: unknown frame type %d len %d in strict mode", c.key.ShortString(), ft, fl)
+	}
 	_, err := io.CopyN(io.Discard, c.br, int64(fl))
 	return err
 }
@@ -827,6 +1057,32 @@ func (c *sclient) handleFrameWatchConns(ft frameType, fl uint32) error {
 	return nil
 }
 
+// handleFramePong handles a pong from the client, matching it against
+// the outstanding server ping (if any) to record an RTT sample.
+func (c *sclient) handleFramePong(ft frameType, fl uint32) error {
+	c.s.gotPong.Add(1)
+	var m PongMessage
+	if fl != uint32(len(m)) {
+		return fmt.Errorf("unexpected pong length: %v", fl)
+	}
+	if _, err := io.ReadFull(c.br, m[:]); err != nil {
+		return err
+	}
+	c.pingMu.Lock()
+	matched := !c.pingSentAt.IsZero() && m == PongMessage(c.pingData)
+	sentAt := c.pingSentAt
+	if matched {
+		c.pingSentAt = time.Time{}
+	}
+	c.pingMu.Unlock()
+	if matched {
+		rtt := time.Since(sentAt)
+		c.rtt.Store(rtt)
+		c.s.noteClientRTT(rtt)
+	}
+	return nil
+}
+
 func (c *sclient) handleFramePing(ft frameType, fl uint32) error {
 	c.s.gotPing.Add(1)
 	var m PingMessage
@@ -899,6 +1155,9 @@ func (c *sclient) handleFrameForwardPacket(ft frameType, fl uint32) error {
 		return fmt.Errorf("client %x: recvForwardPacket: %v", c.key, err)
 	}
 	s.packetsForwardedIn.Add(1)
+	fromRelay := c.key.ShortString()
+	s.meshForwardedIn.Add(fromRelay, 1)
+	s.meshForwardedInBytes.Add(fromRelay, int64(len(contents)))
 
 	var dstLen int
 	var dst *sclient
@@ -921,6 +1180,7 @@ func (c *sclient) handleFrameForwardPacket(ft frameType, fl uint32) error {
 			c.requestPeerGoneWriteLimited(dstKey, contents, PeerGoneReasonNotHere)
 		}
 		s.recordDrop(contents, srcKey, dstKey, reason)
+		putPacketBuf(contents)
 		return nil
 	}
 
@@ -933,6 +1193,17 @@ func (c *sclient) handleFrameForwardPacket(ft frameType, fl uint32) error {
 	})
 }
 
+// labelOfForwarderLocked returns (and caches) the metrics label used for a
+// mesh packet forwarder. s.mu must be held.
+func (s *Server) labelOfForwarderLocked(fwd PacketForwarder) string {
+	lbl, ok := s.fwdLabels[fwd]
+	if !ok {
+		lbl = fwd.String()
+		s.fwdLabels[fwd] = lbl
+	}
+	return lbl
+}
+
 // notePeerSendLocked records that src sent to dst.  We keep track of
 // that so when src disconnects, we can tell dst (if it's still
 // around) that src is gone (a peerGone frame).
@@ -953,8 +1224,10 @@ func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
 	if err != nil {
 		return fmt.Errorf("client %x: recvPacket: %v", c.key, err)
 	}
+	c.bytesRecv.Add(int64(len(contents)))
 
 	var fwd PacketForwarder
+	var fwdLabel string
 	var dstLen int
 	var dst *sclient
 
@@ -967,14 +1240,20 @@ func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
 		s.notePeerSendLocked(c.key, dst)
 	} else if dstLen < 1 {
 		fwd = s.clientsMesh[dstKey]
+		if fwd != nil {
+			fwdLabel = s.labelOfForwarderLocked(fwd)
+		}
 	}
 	s.mu.Unlock()
 
 	if dst == nil {
 		if fwd != nil {
 			s.packetsForwardedOut.Add(1)
+			s.meshForwardedOut.Add(fwdLabel, 1)
+			s.meshForwardedOutBytes.Add(fwdLabel, int64(len(contents)))
 			err := fwd.ForwardPacket(c.key, dstKey, contents)
 			c.debug("SendPacket for %s, forwarding via %s: %v", dstKey.ShortString(), fwd, err)
+			putPacketBuf(contents)
 			if err != nil {
 				// TODO:
 				return nil
@@ -989,6 +1268,7 @@ func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
 		}
 		s.recordDrop(contents, c.key, dstKey, reason)
 		c.debug("SendPacket for %s, dropping with reason=%s", dstKey.ShortString(), reason)
+		putPacketBuf(contents)
 		return nil
 	}
 	c.debug("SendPacket for %s, sending directly", dstKey.ShortString())
@@ -1053,11 +1333,17 @@ func (c *sclient) sendPkt(dst *sclient, p pkt) error {
 	sendQueue := dst.sendQueue
 	if disco.LooksLikeDiscoWrapper(p.bs) {
 		sendQueue = dst.discoSendQueue
+	} else if c.prioritized || dst.prioritized {
+		// Control-plane coordination flows ride the priority queue
+		// so relay congestion doesn't add latency to them.
+		sendQueue = dst.discoSendQueue
+		s.prioritizedPackets.Add(1)
 	}
 	for attempt := 0; attempt < 3; attempt++ {
 		select {
 		case <-dst.done:
 			s.recordDrop(p.bs, c.key, dstKey, dropReasonGoneDisconnected)
+			putPacketBuf(p.bs)
 			dst.debug("sendPkt attempt %d dropped, dst gone", attempt)
 			return nil
 		default:
@@ -1072,6 +1358,7 @@ func (c *sclient) sendPkt(dst *sclient, p pkt) error {
 		select {
 		case pkt := <-sendQueue:
 			s.recordDrop(pkt.bs, c.key, dstKey, dropReasonQueueHead)
+			putPacketBuf(pkt.bs)
 			c.recordQueueTime(pkt.enqueuedAt)
 		default:
 		}
@@ -1080,6 +1367,7 @@ func (c *sclient) sendPkt(dst *sclient, p pkt) error {
 	// contended queue with racing writers. Give up and tail-drop in
 	// this case to keep reader unblocked.
 	s.recordDrop(p.bs, c.key, dstKey, dropReasonQueueTail)
+	putPacketBuf(p.bs)
 	dst.debug("sendPkt attempt %d dropped, queue full")
 
 	return nil
@@ -1199,10 +1487,18 @@ type serverInfo struct {
 
 	TokenBucketBytesPerSecond int `json:",omitempty"`
 	TokenBucketBytesBurst     int `json:",omitempty"`
+
+	// MaxFrameSize, if non-zero, is the per-packet payload size limit
+	// the server accepts when it's above the standard MaxPacketSize.
+	MaxFrameSize int `json:",omitempty"`
 }
 
 func (s *Server) sendServerInfo(bw *lazyBufioWriter, clientKey key.NodePublic) error {
-	msg, err := json.Marshal(serverInfo{Version: ProtocolVersion})
+	info := serverInfo{Version: ProtocolVersion}
+	if s.maxFrameSize > MaxPacketSize {
+		info.MaxFrameSize = s.maxFrameSize
+	}
+	msg, err := json.Marshal(info)
 	if err != nil {
 		return err
 	}
@@ -1261,15 +1557,18 @@ func (s *Server) recvPacket(br *bufio.Reader, frameLen uint32) (dstKey key.NodeP
 		return zpub, nil, err
 	}
 	packetLen := frameLen - keyLen
-	if packetLen > MaxPacketSize {
-		return zpub, nil, fmt.Errorf("data packet longer (%d) than max of %v", packetLen, MaxPacketSize)
+	if max := s.maxPacketSize(); packetLen > max {
+		s.oversizeFrames.Add(1)
+		return zpub, nil, fmt.Errorf("data packet longer (%d) than max of %v", packetLen, max)
 	}
-	contents = make([]byte, packetLen)
+	contents = getPacketBuf(int(packetLen))
 	if _, err := io.ReadFull(br, contents); err != nil {
+		putPacketBuf(contents)
 		return zpub, nil, err
 	}
 	s.packetsRecv.Add(1)
 	s.bytesRecv.Add(int64(len(contents)))
+	s.packetSize.Add(packetSizeBucket(packetLen), 1)
 	if disco.LooksLikeDiscoWrapper(contents) {
 		s.packetsRecvDisco.Add(1)
 	} else {
@@ -1292,13 +1591,16 @@ func (s *Server) recvForwardPacket(br *bufio.Reader, frameLen uint32) (srcKey, d
 		return zpub, zpub, nil, err
 	}
 	packetLen := frameLen - keyLen*2
-	if packetLen > MaxPacketSize {
-		return zpub, zpub, nil, fmt.Errorf("data packet longer (%d) than max of %v", packetLen, MaxPacketSize)
+	if max := s.maxPacketSize(); packetLen > max {
+		s.oversizeFrames.Add(1)
+		return zpub, zpub, nil, fmt.Errorf("data packet longer (%d) than max of %v", packetLen, max)
 	}
-	contents = make([]byte, packetLen)
+	contents = getPacketBuf(int(packetLen))
 	if _, err := io.ReadFull(br, contents); err != nil {
+		putPacketBuf(contents)
 		return zpub, zpub, nil, err
 	}
+	s.packetSize.Add(packetSizeBucket(packetLen), 1)
 	// TODO: was s.packetsRecv.Add(1)
 	// TODO: was s.bytesRecv.Add(int64(len(contents)))
 	return srcKey, dstKey, contents, nil
@@ -1324,8 +1626,20 @@ type sclient struct {
 	peerGone       chan peerGoneMsg // write request that a peer is not at this server (not used by mesh peers)
 	meshUpdate     chan struct{}    // write request to write peerStateChange
 	canMesh        bool             // clientInfo had correct mesh token for inter-region routing
-	isDup          atomic.Bool      // whether more than 1 sclient for key is connected
-	isDisabled     atomic.Bool      // whether sends to this peer are disabled due to active/active dups
+	prioritized    bool             // client's key is a control-plane key; its flows use the priority queue
+
+	// RTT measurement state, for SetPingInterval.
+	pingMu        sync.Mutex
+	pingData      [8]byte                          // payload of the outstanding ping, if any
+	pingSentAt    time.Time                        // zero if no ping outstanding
+	rtt           syncs.AtomicValue[time.Duration] // most recent RTT; zero if unmeasured
+	isDup         atomic.Bool                      // whether more than 1 sclient for key is connected
+	isDisabled    atomic.Bool                      // whether sends to this peer are disabled due to active/active dups
+	lastSend      atomic.Int64                     // unix nanos of most recent frame written to this client
+	lastRecv      atomic.Int64                     // unix nanos of most recent frame read from this client
+	bytesSent     atomic.Int64                     // packet payload bytes written to this client
+	bytesRecv     atomic.Int64                     // packet payload bytes read from this client
+	lastFrameMeta atomic.Uint64                    // type (high 32 bits) and length of the most recent frame read, for crash reports
 
 	debugLogging bool
 
@@ -1433,8 +1747,10 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 			select {
 			case pkt := <-c.sendQueue:
 				c.s.recordDrop(pkt.bs, pkt.src, c.key, dropReasonGoneDisconnected)
+				putPacketBuf(pkt.bs)
 			case pkt := <-c.discoSendQueue:
 				c.s.recordDrop(pkt.bs, pkt.src, c.key, dropReasonGoneDisconnected)
+				putPacketBuf(pkt.bs)
 			default:
 				return
 			}
@@ -1445,6 +1761,30 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 	keepAliveTick := time.NewTicker(keepAlive + jitter)
 	defer keepAliveTick.Stop()
 
+	// If connections have a maximum age, arrange to send a reconnect
+	// hint when this one reaches it. The jitter (up to age/8) spreads
+	// the resulting reconnects out so a fleet restarted or scaled out
+	// at once doesn't thunder back in unison.
+	var recycleTimerC <-chan time.Time
+	if age := c.s.maxConnAge; age > 0 {
+		ageJitter := time.Duration(rand.Int63n(int64(age/8) + 1))
+		recycleTimer := time.NewTimer(age + ageJitter - time.Since(c.connectedAt))
+		defer recycleTimer.Stop()
+		recycleTimerC = recycleTimer.C
+	}
+
+	// Low-frequency RTT pings, for clients that can ack them.
+	var pingTickC <-chan time.Time
+	if iv := c.s.pingInterval; iv > 0 && c.info.CanAckPings {
+		pingTick := time.NewTicker(iv + time.Duration(rand.Int63n(int64(iv/8)+1)))
+		defer pingTick.Stop()
+		pingTickC = pingTick.C
+	}
+
+	// Closed when the server is draining; nilled after the reconnect
+	// hint is sent so a closed channel doesn't spin the loop.
+	drainC := c.s.drainCh
+
 	var werr error // last write error
 	for {
 		if werr != nil {
@@ -1463,10 +1803,12 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 			continue
 		case msg := <-c.sendQueue:
 			werr = c.sendPacket(msg.src, msg.bs)
+			putPacketBuf(msg.bs)
 			c.recordQueueTime(msg.enqueuedAt)
 			continue
 		case msg := <-c.discoSendQueue:
 			werr = c.sendPacket(msg.src, msg.bs)
+			putPacketBuf(msg.bs)
 			c.recordQueueTime(msg.enqueuedAt)
 			continue
 		case msg := <-c.sendPongCh:
@@ -1475,6 +1817,17 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 		case <-keepAliveTick.C:
 			werr = c.sendKeepAlive()
 			continue
+		case <-recycleTimerC:
+			werr = c.sendServerRestarting()
+			recycleTimerC = nil
+			continue
+		case <-drainC:
+			werr = c.sendServerRestarting()
+			drainC = nil
+			continue
+		case <-pingTickC:
+			werr = c.sendPing()
+			continue
 		default:
 			// Flush any writes from the 3 sends above, or from
 			// the blocking loop below.
@@ -1494,20 +1847,33 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 			continue
 		case msg := <-c.sendQueue:
 			werr = c.sendPacket(msg.src, msg.bs)
+			putPacketBuf(msg.bs)
 			c.recordQueueTime(msg.enqueuedAt)
 		case msg := <-c.discoSendQueue:
 			werr = c.sendPacket(msg.src, msg.bs)
+			putPacketBuf(msg.bs)
 			c.recordQueueTime(msg.enqueuedAt)
 		case msg := <-c.sendPongCh:
 			werr = c.sendPong(msg)
 			continue
 		case <-keepAliveTick.C:
 			werr = c.sendKeepAlive()
+		case <-recycleTimerC:
+			werr = c.sendServerRestarting()
+			recycleTimerC = nil
+		case <-drainC:
+			werr = c.sendServerRestarting()
+			drainC = nil
+		case <-pingTickC:
+			werr = c.sendPing()
 		}
 	}
 }
 
 func (c *sclient) setWriteDeadline() {
+	// Every outbound frame goes through here, so this doubles as
+	// the client's last-send activity record.
+	c.lastSend.Store(time.Now().UnixNano())
 	c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
 }
 
@@ -1517,6 +1883,69 @@ func (c *sclient) sendKeepAlive() error {
 	return writeFrameHeader(c.bw.bw(), frameKeepAlive, 0)
 }
 
+// Drain asks every connected client to reconnect elsewhere (via the
+// same graceful reconnect hint that age-based recycling uses) and
+// waits until they have all disconnected or ctx is done. Callers
+// should stop accepting new connections before draining; Drain itself
+// doesn't prevent new ones.
+func (s *Server) Drain(ctx context.Context) error {
+	s.drainOnce.Do(func() { close(s.drainCh) })
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		n := len(s.clients)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			n := len(s.clients)
+			s.mu.Unlock()
+			return fmt.Errorf("drain deadline reached with %d clients still connected", n)
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendServerRestarting sends a restarting frame, without flushing. The
+// client treats it as a hint to tear down and re-establish its DERP
+// connection, which is how age-based connection recycling is delivered.
+func (c *sclient) sendServerRestarting() error {
+	c.s.connectionsRecycled.Add(1)
+	c.setWriteDeadline()
+	if err := writeFrameHeader(c.bw.bw(), frameRestarting, 8); err != nil {
+		return err
+	}
+	var data [8]byte
+	// Milliseconds on the wire; see ServerRestartingMessage.
+	binary.BigEndian.PutUint32(data[0:4], uint32(rand.Intn(5000))) // ReconnectIn
+	binary.BigEndian.PutUint32(data[4:8], uint32(30000))           // TryFor
+	_, err := c.bw.Write(data[:])
+	return err
+}
+
+// sendPing sends a ping frame with fresh random payload, without
+// flushing, and records it as the outstanding ping for RTT matching.
+// A previous unanswered ping is simply forgotten.
+func (c *sclient) sendPing() error {
+	var data [8]byte
+	crand.Read(data[:])
+	c.pingMu.Lock()
+	c.pingData = data
+	c.pingSentAt = time.Now()
+	c.pingMu.Unlock()
+	c.s.sentPing.Add(1)
+	c.setWriteDeadline()
+	if err := writeFrameHeader(c.bw.bw(), framePing, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err := c.bw.Write(data[:])
+	return err
+}
+
 // sendPong sends a pong reply, without flushing.
 func (c *sclient) sendPong(data [8]byte) error {
 	c.s.sentPong.Add(1)
@@ -1574,8 +2003,10 @@ func (c *sclient) sendMeshUpdates() error {
 		var err error
 		if pcs.present {
 			err = c.sendPeerPresent(pcs.peer)
+			c.s.meshPeerPresentSent.Add(1)
 		} else {
 			err = c.sendPeerGone(pcs.peer, PeerGoneReasonDisconnected)
+			c.s.meshPeerGoneSent.Add(1)
 		}
 		if err != nil {
 			// Shouldn't happen, though, as we're writing
@@ -1614,6 +2045,7 @@ func (c *sclient) sendPacket(srcKey key.NodePublic, contents []byte) (err error)
 		} else {
 			c.s.packetsSent.Add(1)
 			c.s.bytesSent.Add(int64(len(contents)))
+			c.bytesSent.Add(int64(len(contents)))
 		}
 		c.debug("sendPacket from %s: %v", srcKey.ShortString(), err)
 	}()
@@ -1780,6 +2212,181 @@ func (f *multiForwarder) String() string {
 	return fmt.Sprintf("<MultiForwarder fwd=%s total=%d>", f.fwd.Load(), len(f.all))
 }
 
+// maxRTTSamples bounds the ring of recent client RTT measurements.
+const maxRTTSamples = 512
+
+// noteClientRTT adds one RTT measurement to the sample ring.
+func (s *Server) noteClientRTT(rtt time.Duration) {
+	s.rttMu.Lock()
+	defer s.rttMu.Unlock()
+	if len(s.rttSamples) < maxRTTSamples {
+		s.rttSamples = append(s.rttSamples, rtt)
+		return
+	}
+	s.rttSamples[s.rttNext] = rtt
+	s.rttNext = (s.rttNext + 1) % maxRTTSamples
+}
+
+// rttPercentileMs returns the given percentile (0-1) of the recent
+// client RTT samples, in milliseconds, or 0 if there are none.
+func (s *Server) rttPercentileMs(p float64) float64 {
+	s.rttMu.Lock()
+	samples := append([]time.Duration(nil), s.rttSamples...)
+	s.rttMu.Unlock()
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(p * float64(len(samples)-1))
+	return float64(samples[idx]) / float64(time.Millisecond)
+}
+
+// ServeDebugClients serves a JSON list of connected clients with
+// their measured RTTs, for support diagnostics.
+func (s *Server) ServeDebugClients(w http.ResponseWriter, r *http.Request) {
+	type clientOut struct {
+		Key         string
+		Addr        string
+		ConnectedAt time.Time
+		Preferred   bool    `json:",omitempty"`
+		RTTMs       float64 `json:",omitempty"`
+	}
+	var out []clientOut
+	s.mu.Lock()
+	for k, set := range s.clients {
+		c := set.ActiveClient()
+		if c == nil {
+			continue
+		}
+		co := clientOut{
+			Key:         k.String(),
+			Addr:        c.remoteAddr,
+			ConnectedAt: c.connectedAt,
+			Preferred:   c.preferred,
+		}
+		if rtt := c.rtt.Load(); rtt > 0 {
+			co.RTTMs = float64(rtt) / float64(time.Millisecond)
+		}
+		out = append(out, co)
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ConnectedAt.Before(out[j].ConnectedAt) })
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "	")
+	e.Encode(out)
+}
+
+// ConnectedClient describes one connected client, as reported by
+// ConnectedClients for the admin API.
+type ConnectedClient struct {
+	Key         key.NodePublic `json:"key"`
+	Addr        string         `json:"addr"`
+	ConnectedAt time.Time      `json:"connectedAt"`
+	Preferred   bool           `json:"preferred,omitempty"`
+	BytesSent   int64          `json:"bytesSent"`
+	BytesRecv   int64          `json:"bytesRecv"`
+	RTTMs       float64        `json:"rttMs,omitempty"`
+}
+
+// ConnectedClients returns a snapshot of the currently connected
+// clients, sorted by connect time. For keys with duplicate
+// connections only the active client is reported.
+func (s *Server) ConnectedClients() []ConnectedClient {
+	var out []ConnectedClient
+	s.mu.Lock()
+	for k, set := range s.clients {
+		c := set.ActiveClient()
+		if c == nil {
+			continue
+		}
+		cc := ConnectedClient{
+			Key:         k,
+			Addr:        c.remoteAddr,
+			ConnectedAt: c.connectedAt,
+			Preferred:   c.preferred,
+			BytesSent:   c.bytesSent.Load(),
+			BytesRecv:   c.bytesRecv.Load(),
+		}
+		if rtt := c.rtt.Load(); rtt > 0 {
+			cc.RTTMs = float64(rtt) / float64(time.Millisecond)
+		}
+		out = append(out, cc)
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ConnectedAt.Before(out[j].ConnectedAt) })
+	return out
+}
+
+// DisconnectClient forcibly closes all connections from the client
+// with the given key, reporting whether any were found. The client is
+// free to reconnect; persistent bans belong in the verify-clients
+// policy, not here.
+func (s *Server) DisconnectClient(k key.NodePublic) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set, ok := s.clients[k]
+	if !ok {
+		return false
+	}
+	s.logf("derp: admin disconnecting %x (%d connections)", k, set.Len())
+	set.ForeachClient(func(c *sclient) {
+		go c.nc.Close()
+	})
+	return true
+}
+
+// ServeDebugPresence answers "does this relay believe key X is
+// present, and how": as a local client, or reachable via a mesh peer.
+// Query each relay in a region to debug stale-path issues. The key is
+// given in the "key" query parameter, with or without the "nodekey:"
+// prefix.
+func (s *Server) ServeDebugPresence(w http.ResponseWriter, r *http.Request) {
+	keyStr := r.FormValue("key")
+	if keyStr == "" {
+		http.Error(w, "missing key query parameter", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(keyStr, "nodekey:") {
+		keyStr = "nodekey:" + keyStr
+	}
+	var k key.NodePublic
+	if err := k.UnmarshalText([]byte(keyStr)); err != nil {
+		http.Error(w, "bad node key: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	type presenceOut struct {
+		Key          string
+		Present      bool
+		LocalClient  bool       `json:",omitempty"`
+		RemoteAddr   string     `json:",omitempty"`
+		ConnectedAt  *time.Time `json:",omitempty"`
+		ForwardedVia string     `json:",omitempty"` // mesh peer relay label, if not local
+		SentToPeers  int        // peers this key has sent to here, who would get peerGone
+	}
+	out := presenceOut{Key: k.String()}
+	s.mu.Lock()
+	if set, ok := s.clients[k]; ok {
+		out.Present = true
+		out.LocalClient = true
+		if c := set.ActiveClient(); c != nil {
+			out.RemoteAddr = c.remoteAddr
+			t := c.connectedAt
+			out.ConnectedAt = &t
+		}
+	}
+	if fwd, ok := s.clientsMesh[k]; ok && fwd != nil {
+		out.Present = true
+		out.ForwardedVia = s.labelOfForwarderLocked(fwd)
+	}
+	out.SentToPeers = len(s.sentTo[k])
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "	")
+	e.Encode(out)
+}
+
 func (s *Server) expVarFunc(f func() any) expvar.Func {
 	return expvar.Func(func() any {
 		s.mu.Lock()
@@ -1812,14 +2419,53 @@ func (s *Server) ExpVar() expvar.Var {
 	m.Set("packets_sent", &s.packetsSent)
 	m.Set("packets_received", &s.packetsRecv)
 	m.Set("unknown_frames", &s.unknownFrames)
+	m.Set("counter_strict_disconnects", &s.strictDisconnects)
+	m.Set("counter_oversize_frames", &s.oversizeFrames)
+	m.Set("counter_packet_size_bytes", &s.packetSize)
+	m.Set("counter_client_link_mtu", &s.clientLinkMTU)
+	m.Set("counter_source_addr_changes", &s.sourceAddrChanges)
+	m.Set("counter_rebind_interval", &s.rebindIntervals)
+	m.Set("gauge_rebind_tracked_keys", s.expVarFunc(func() any { return len(s.lastAddrOfKey) }))
+	m.Set("counter_connections_recycled", &s.connectionsRecycled)
+	m.Set("counter_prioritized_packets", &s.prioritizedPackets)
+	m.Set("counter_panics_recovered", &s.panicsRecovered)
+	m.Set("sent_ping", &s.sentPing)
+	m.Set("got_pong", &s.gotPong)
+	m.Set("gauge_client_rtt_p50_ms", expvar.Func(func() any { return s.rttPercentileMs(0.50) }))
+	m.Set("gauge_client_rtt_p95_ms", expvar.Func(func() any { return s.rttPercentileMs(0.95) }))
 	m.Set("home_moves_in", &s.homeMovesIn)
 	m.Set("home_moves_out", &s.homeMovesOut)
 	m.Set("got_ping", &s.gotPing)
 	m.Set("sent_pong", &s.sentPong)
 	m.Set("peer_gone_disconnected_frames", &s.peerGoneDisconnectedFrames)
 	m.Set("peer_gone_not_here_frames", &s.peerGoneNotHereFrames)
+	m.Set("counter_mesh_peer_present_sent", &s.meshPeerPresentSent)
+	m.Set("counter_mesh_peer_gone_sent", &s.meshPeerGoneSent)
+	m.Set("gauge_mesh_update_backlog", s.expVarFunc(func() any {
+		var n int64
+		for w := range s.watchers {
+			n += int64(len(w.peerStateChange))
+		}
+		return n
+	}))
 	m.Set("packets_forwarded_out", &s.packetsForwardedOut)
 	m.Set("packets_forwarded_in", &s.packetsForwardedIn)
+	m.Set("counter_mesh_forwarded_in_packets", &s.meshForwardedIn)
+	m.Set("counter_mesh_forwarded_in_bytes", &s.meshForwardedInBytes)
+	m.Set("counter_mesh_forwarded_out_packets", &s.meshForwardedOut)
+	m.Set("counter_mesh_forwarded_out_bytes", &s.meshForwardedOutBytes)
+	m.Set("gauge_mesh_clients_by_relay", expvar.Func(func() any {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		counts := map[string]int64{}
+		for _, fwd := range s.clientsMesh {
+			if fwd == nil {
+				continue
+			}
+			counts[s.labelOfForwarderLocked(fwd)]++
+		}
+		return counts
+	}))
 	m.Set("multiforwarder_created", &s.multiForwarderCreated)
 	m.Set("multiforwarder_deleted", &s.multiForwarderDeleted)
 	m.Set("packet_forwarder_delete_other_value", &s.removePktForwardOther)
@@ -1827,6 +2473,8 @@ func (s *Server) ExpVar() expvar.Var {
 		return math.Float64frombits(atomic.LoadUint64(s.avgQueueDuration))
 	}))
 	m.Set("counter_tcp_rtt", &s.tcpRtt)
+	m.Set("counter_pktbuf_gets", &pktBufGets)
+	m.Set("counter_pktbuf_allocs", &pktBufAllocs)
 	var expvarVersion expvar.String
 	expvarVersion.Set(version.Long())
 	m.Set("version", &expvarVersion)
@@ -1918,7 +2566,94 @@ func parseSSOutput(raw string) map[netip.AddrPort]BytesSentRecv {
 	return newState
 }
 
+// debugClientTraffic is the per-client record written by
+// ServeDebugTraffic in JSON snapshot mode.
+type debugClientTraffic struct {
+	Key         key.NodePublic
+	RemoteAddr  string
+	ConnectedAt time.Time
+	LastSend    time.Time `json:",omitempty"`
+	LastRecv    time.Time `json:",omitempty"`
+	IdleSeconds float64   // seconds since the later of LastSend/LastRecv
+	Proto       int       // negotiated DERP protocol version
+	CanMesh     bool      `json:",omitempty"`
+}
+
+// serveDebugTrafficJSON writes a one-shot JSON snapshot of all
+// connected clients with their activity timestamps, for scripting.
+func (s *Server) serveDebugTrafficJSON(w http.ResponseWriter) {
+	now := time.Now()
+	var out []debugClientTraffic
+	s.mu.Lock()
+	for _, set := range s.clients {
+		set.ForeachClient(func(c *sclient) {
+			t := debugClientTraffic{
+				Key:         c.key,
+				RemoteAddr:  c.remoteAddr,
+				ConnectedAt: c.connectedAt,
+				Proto:       c.info.Version,
+				CanMesh:     c.canMesh,
+			}
+			last := c.connectedAt
+			if v := c.lastSend.Load(); v != 0 {
+				t.LastSend = time.Unix(0, v)
+				if t.LastSend.After(last) {
+					last = t.LastSend
+				}
+			}
+			if v := c.lastRecv.Load(); v != 0 {
+				t.LastRecv = time.Unix(0, v)
+				if t.LastRecv.After(last) {
+					last = t.LastRecv
+				}
+			}
+			t.IdleSeconds = now.Sub(last).Seconds()
+			out = append(out, t)
+		})
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(out)
+}
+
+// packetSizeBucket returns the histogram bucket label for a relayed
+// packet payload of n bytes. Bucket edges are chosen around common
+// link MTUs to make packetization problems visible.
+func packetSizeBucket(n uint32) string {
+	switch {
+	case n <= 64:
+		return "<=64"
+	case n <= 256:
+		return "<=256"
+	case n <= 512:
+		return "<=512"
+	case n <= 1024:
+		return "<=1024"
+	case n <= 1280:
+		return "<=1280"
+	case n <= 1400:
+		return "<=1400"
+	case n <= 1500:
+		return "<=1500"
+	default:
+		return ">1500"
+	}
+}
+
 func (s *Server) ServeDebugTraffic(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("format") == "json" {
+		s.serveDebugTrafficJSON(w)
+		return
+	}
+	// Summary first: relayed frame size distribution and the MTUs
+	// clients advertised, then the live per-connection stream.
+	fmt.Fprintf(w, "# frame sizes: %s\n", s.packetSize.String())
+	fmt.Fprintf(w, "# client MTUs: %s\n\n", s.clientLinkMTU.String())
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
 	prevState := map[netip.AddrPort]BytesSentRecv{}
 	enc := json.NewEncoder(w)
 	for r.Context().Err() == nil {
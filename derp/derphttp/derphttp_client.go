@@ -73,6 +73,7 @@ type Client struct {
 	mu           sync.Mutex
 	preferred    bool
 	canAckPings  bool
+	linkMTU      int
 	closed       bool
 	netConn      io.Closer
 	client       *derp.Client
@@ -325,6 +326,7 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 			derp.MeshKey(c.MeshKey),
 			derp.CanAckPings(c.canAckPings),
 			derp.IsProber(c.IsProber),
+			derp.LinkMTU(c.linkMTU),
 		)
 		if err != nil {
 			return nil, 0, err
@@ -457,6 +459,7 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		derp.ServerPublicKey(serverPub),
 		derp.CanAckPings(c.canAckPings),
 		derp.IsProber(c.IsProber),
+		derp.LinkMTU(c.linkMTU),
 	)
 	if err != nil {
 		return nil, 0, err
@@ -912,6 +915,16 @@ func (c *Client) SetCanAckPings(v bool) {
 	c.canAckPings = v
 }
 
+// SetLinkMTU sets the advertised MTU of the client's underlying
+// network link, for server-side metrics. Zero means unknown.
+//
+// This only affects future connections.
+func (c *Client) SetLinkMTU(mtu int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.linkMTU = mtu
+}
+
 // NotePreferred notes whether this Client is the caller's preferred
 // (home) DERP node. It's only used for stats.
 func (c *Client) NotePreferred(v bool) {
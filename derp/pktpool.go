@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derp
+
+import (
+	"expvar"
+	"sync"
+)
+
+// Packet buffer pooling for the server data path. Every data frame used to
+// allocate its contents slice fresh, which at >10k concurrent clients is a
+// large source of GC churn. Buffers come in two classes: small ones cover
+// WireGuard-sized packets (the overwhelming majority of traffic), large
+// ones cover anything up to MaxPacketSize.
+//
+// Ownership: a buffer returned by getPacketBuf travels inside a pkt through
+// a send queue and is released with putPacketBuf at whichever point the
+// packet's life ends (written out, dropped, or forwarded).
+
+// smallPktSize is the size of the small buffer class; a WireGuard
+// packet on a normal MTU link fits comfortably.
+const smallPktSize = 2 << 10
+
+var (
+	pktBufGets   expvar.Int // total buffer requests
+	pktBufAllocs expvar.Int // requests that couldn't be served from a pool
+)
+
+var (
+	smallPktPool = &sync.Pool{New: func() any {
+		pktBufAllocs.Add(1)
+		b := make([]byte, smallPktSize)
+		return &b
+	}}
+	largePktPool = &sync.Pool{New: func() any {
+		pktBufAllocs.Add(1)
+		b := make([]byte, MaxPacketSize)
+		return &b
+	}}
+)
+
+// getPacketBuf returns a buffer of length n, pooled when possible.
+func getPacketBuf(n int) []byte {
+	pktBufGets.Add(1)
+	if n <= smallPktSize {
+		return (*smallPktPool.Get().(*[]byte))[:n]
+	}
+	if n > MaxPacketSize {
+		// Jumbo frames (negotiated above the standard limit) are
+		// rare enough not to pool.
+		pktBufAllocs.Add(1)
+		return make([]byte, n)
+	}
+	return (*largePktPool.Get().(*[]byte))[:n]
+}
+
+// putPacketBuf returns a buffer obtained from getPacketBuf to its pool.
+// Buffers of other origins (or sizes) are ignored.
+func putPacketBuf(b []byte) {
+	switch cap(b) {
+	case smallPktSize:
+		b = b[:cap(b)]
+		smallPktPool.Put(&b)
+	case MaxPacketSize:
+		b = b[:cap(b)]
+		largePktPool.Put(&b)
+	}
+}
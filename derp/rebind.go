@@ -0,0 +1,74 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derp
+
+import (
+	"net/netip"
+	"time"
+)
+
+// NAT rebind analytics. A client whose NAT drops its mapping comes
+// back as a fresh connection for the same node key from a new source
+// port (or a new IP entirely, e.g. after a network switch).
+// Correlating consecutive connections per key measures how long
+// mappings survive in the wild, which informs the STUN keepalive
+// interval the controller recommends to clients.
+
+// addrSeen records the last source address a node key connected
+// from, and when.
+type addrSeen struct {
+	addr netip.AddrPort
+	when time.Time
+}
+
+// maxRebindTrackedKeys bounds the per-key address history. Beyond
+// this, arbitrary entries are evicted; the statistics are aggregates
+// so losing individual histories only costs a few uncounted rebinds.
+const maxRebindTrackedKeys = 1 << 16
+
+// rebindIntervalBucket coarsely buckets the time between a
+// connection and its successor from a different source address.
+func rebindIntervalBucket(d time.Duration) string {
+	switch {
+	case d < 30*time.Second:
+		return "lt30s"
+	case d < 2*time.Minute:
+		return "lt2m"
+	case d < 10*time.Minute:
+		return "lt10m"
+	case d < time.Hour:
+		return "lt1h"
+	}
+	return "ge1h"
+}
+
+// noteSourceAddrLocked updates the rebind statistics with c's source
+// address, comparing it against the previous connection from the
+// same node key.
+//
+// s.mu must be held.
+func (s *Server) noteSourceAddrLocked(c *sclient) {
+	if !c.remoteIPPort.IsValid() {
+		return
+	}
+	now := time.Now()
+	prev, ok := s.lastAddrOfKey[c.key]
+	if ok && prev.addr != c.remoteIPPort {
+		kind := "ip"
+		if prev.addr.Addr() == c.remoteIPPort.Addr() {
+			kind = "port"
+		}
+		s.sourceAddrChanges.Add(kind, 1)
+		s.rebindIntervals.Add(rebindIntervalBucket(now.Sub(prev.when)), 1)
+	}
+	if !ok && len(s.lastAddrOfKey) >= maxRebindTrackedKeys {
+		for k := range s.lastAddrOfKey {
+			delete(s.lastAddrOfKey, k)
+			if len(s.lastAddrOfKey) < maxRebindTrackedKeys {
+				break
+			}
+		}
+	}
+	s.lastAddrOfKey[c.key] = addrSeen{addr: c.remoteIPPort, when: now}
+}
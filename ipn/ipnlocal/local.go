@@ -3075,6 +3075,29 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, prefs ipn.PrefsView, logf logger.
 	for _, peer := range nm.Peers {
 		set(peer.Name, peer.Addresses)
 	}
+
+	// Synthesize reverse (PTR) entries for every self and peer
+	// address, including ones suppressed from forward records above
+	// (e.g. peer IPv6 addresses when IPv4 is present), so tooling
+	// like traceroute and ssh shows MagicDNS names instead of raw
+	// addresses.
+	dcfg.ReverseHosts = map[netip.Addr]dnsname.FQDN{}
+	setReverse := func(name string, addrs []netip.Prefix) {
+		if name == "" {
+			return
+		}
+		fqdn, err := dnsname.ToFQDN(name)
+		if err != nil {
+			return
+		}
+		for _, addr := range addrs {
+			dcfg.ReverseHosts[addr.Addr()] = fqdn
+		}
+	}
+	setReverse(nm.Name, nm.Addresses)
+	for _, peer := range nm.Peers {
+		setReverse(peer.Name, peer.Addresses)
+	}
 	for _, rec := range nm.DNS.ExtraRecords {
 		switch rec.Type {
 		case "", "A", "AAAA":
@@ -4528,6 +4551,17 @@ func (b *LocalBackend) DebugRebind() error {
 	return nil
 }
 
+// DebugForcePreferredDERP forcibly switches the preferred DERP region
+// to regionID, or restores automatic selection if regionID is zero.
+func (b *LocalBackend) DebugForcePreferredDERP(regionID int) error {
+	mc, err := b.magicConn()
+	if err != nil {
+		return err
+	}
+	mc.SetForcedPreferredDERP(regionID)
+	return nil
+}
+
 func (b *LocalBackend) DebugReSTUN() error {
 	mc, err := b.magicConn()
 	if err != nil {
@@ -538,6 +538,12 @@ func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 		err = h.b.DebugRebind()
 	case "restun":
 		err = h.b.DebugReSTUN()
+	case "force-preferred-derp":
+		var regionID int
+		regionID, err = strconv.Atoi(r.FormValue("region"))
+		if err == nil {
+			err = h.b.DebugForcePreferredDERP(regionID)
+		}
 	case "enginestatus":
 		// serveRequestEngineStatus kicks off a call to RequestEngineStatus (via
 		// LocalBackend => UserspaceEngine => LocalBackend =>
@@ -0,0 +1,93 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"tailscale.com/derp"
+	"tailscale.com/types/key"
+)
+
+// Admin API. Lets operators inspect and manage connected DERP clients
+// over HTTP without restarting the server — notably kicking an abusive
+// node by key. Disabled unless --admin-token is set; every request
+// must carry the token as a bearer token.
+
+var adminToken = flag.String("admin-token", "", "bearer token required for the /admin/v1 API; empty disables the API")
+
+// registerAdminAPI installs the /admin/v1 handlers on mux if
+// --admin-token is set.
+func registerAdminAPI(mux *http.ServeMux, s *derp.Server) {
+	if *adminToken == "" {
+		return
+	}
+	mux.HandleFunc("/admin/v1/clients", adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		serveAdminClients(s, w, r)
+	}))
+	mux.HandleFunc("/admin/v1/clients/", adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		serveAdminClient(s, w, r)
+	}))
+}
+
+// adminAuth wraps h with the bearer-token check.
+func adminAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(tok), []byte(*adminToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// serveAdminClients handles GET /admin/v1/clients: the list of
+// currently connected clients.
+func serveAdminClients(s *derp.Server, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "	")
+	e.Encode(s.ConnectedClients())
+}
+
+// serveAdminClient handles DELETE /admin/v1/clients/<nodekey>:
+// forcibly disconnect that client. The key may be given with or
+// without the "nodekey:" prefix.
+func serveAdminClient(s *derp.Server, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keyStr := strings.TrimPrefix(r.URL.Path, "/admin/v1/clients/")
+	if !strings.HasPrefix(keyStr, "nodekey:") {
+		keyStr = "nodekey:" + keyStr
+	}
+	var k key.NodePublic
+	if err := k.UnmarshalText([]byte(keyStr)); err != nil {
+		http.Error(w, "bad node key: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.DisconnectClient(k) {
+		http.Error(w, "client not connected", http.StatusNotFound)
+		return
+	}
+	log.Printf("admin API disconnected client %s (from %s)", k.ShortString(), r.RemoteAddr)
+	if err := writeAuditRecord("admin-disconnect", map[string]string{
+		"key":    k.String(),
+		"remote": r.RemoteAddr,
+	}); err != nil {
+		log.Printf("admin disconnect audit record: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// TLS-ALPN-01 challenges when the HTTPS listener is not on port 443.
+// The ACME CA always dials port 443 for the challenge regardless of
+// AltTLSALPNPort, so derper used to shell out to `sudo iptables` and
+// install a temporary REDIRECT rule — which required root, sudo and
+// iptables. Instead, bind an auxiliary listener on 443 and proxy the
+// acme-tls/1 handshakes to the port the solver (and later the main
+// listener) actually answers on. Anything on 443 that isn't an
+// acme-tls/1 handshake is dropped, so the proxy doesn't turn into an
+// unintended second DERP entry point.
+
+// alpnProxyHelloTimeout is how long a connection on 443 gets to
+// present its ClientHello before being dropped.
+const alpnProxyHelloTimeout = 10 * time.Second
+
+// alpnProxyDialTimeout is the timeout for reaching the real
+// challenge port from the proxy.
+const alpnProxyDialTimeout = 5 * time.Second
+
+// startALPNChallengeProxy binds :443 and forwards acme-tls/1
+// handshakes to targetPort. The listener stays up for the lifetime of
+// the process: in-place renewals answer the challenge on the main
+// listener, which also sits on targetPort.
+func startALPNChallengeProxy(targetPort int) error {
+	ln, err := listenTCPHandoff("alpn::443", ":443")
+	if err != nil {
+		return err
+	}
+	log.Printf("derper: proxying acme-tls/1 challenges from :443 to :%d", targetPort)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				log.Printf("derper: alpn proxy: accept: %v", err)
+				return
+			}
+			go proxyALPNConn(c, targetPort)
+		}
+	}()
+	return nil
+}
+
+// proxyALPNConn peeks at c's ClientHello and, if it is an acme-tls/1
+// handshake, splices the connection through to the challenge port.
+func proxyALPNConn(c net.Conn, targetPort int) {
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(alpnProxyHelloTimeout))
+	hello, prefix, err := peekClientHello(c)
+	if err != nil {
+		return
+	}
+	isChallenge := false
+	for _, proto := range hello.SupportedProtos {
+		if proto == "acme-tls/1" {
+			isChallenge = true
+			break
+		}
+	}
+	if !isChallenge {
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+	backend, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", targetPort), alpnProxyDialTimeout)
+	if err != nil {
+		log.Printf("derper: alpn proxy: dial :%d: %v", targetPort, err)
+		return
+	}
+	defer backend.Close()
+	// Replay the bytes consumed by the peek, then splice.
+	if _, err := backend.Write(prefix); err != nil {
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(backend, c)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(c, backend)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// peekClientHello parses the ClientHello from c without completing a
+// handshake, returning the hello and the raw bytes consumed so they
+// can be replayed to the real server.
+func peekClientHello(c net.Conn) (*tls.ClientHelloInfo, []byte, error) {
+	var buf bytes.Buffer
+	var hello *tls.ClientHelloInfo
+	// The handshake always fails: the conn is read-only so no
+	// ServerHello can go out, and GetConfigForClient stops it right
+	// after the ClientHello anyway. All we want is the parsed hello
+	// and the bytes it came from.
+	err := tls.Server(readOnlyConn{r: io.TeeReader(c, &buf)}, &tls.Config{
+		GetConfigForClient: func(hi *tls.ClientHelloInfo) (*tls.Config, error) {
+			h := *hi
+			hello = &h
+			return nil, nil
+		},
+	}).Handshake()
+	if hello == nil {
+		return nil, nil, err
+	}
+	return hello, buf.Bytes(), nil
+}
+
+// readOnlyConn adapts a reader into the net.Conn crypto/tls wants,
+// refusing writes so the peeking handshake can't leak anything back
+// to the client.
+type readOnlyConn struct{ r io.Reader }
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, syscall.EPIPE }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
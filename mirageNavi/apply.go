@@ -0,0 +1,175 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"tailscale.com/derp"
+)
+
+// naviConfig is the set of flag-backed settings the controller can push
+// at runtime. Collecting them in one value lets a pushed change be
+// validated and applied as a unit, and rolled back as a unit if the
+// relay degrades right after the change took effect.
+type naviConfig struct {
+	hostname    string
+	addr        string
+	ipv4        string
+	ipv6        string
+	dnsProvider string
+	dnsID       string
+	dnsKey      string
+	stunPorts   string
+	runDERP     bool
+	runSTUN     bool
+}
+
+// captureNaviConfig snapshots the current flag values.
+func captureNaviConfig() naviConfig {
+	return naviConfig{
+		hostname:    *hostname,
+		addr:        *addr,
+		ipv4:        *setIPv4,
+		ipv6:        *setIPv6,
+		dnsProvider: *dnsProvider,
+		dnsID:       *dnsID,
+		dnsKey:      *dnsKey,
+		stunPorts:   *stunPorts,
+		runDERP:     *runDERP,
+		runSTUN:     *runSTUN,
+	}
+}
+
+func (c naviConfig) validate() error {
+	if _, _, err := net.SplitHostPort(c.addr); err != nil {
+		return fmt.Errorf("invalid server address %q: %v", c.addr, err)
+	}
+	if c.runSTUN {
+		if _, err := parseSTUNPorts(c.stunPorts); err != nil {
+			return err
+		}
+	}
+	if c.runDERP && c.hostname == "" {
+		return fmt.Errorf("empty hostname")
+	}
+	return nil
+}
+
+// apply writes the config back into the flag variables the rest of the
+// server reads.
+func (c naviConfig) apply() {
+	*hostname = c.hostname
+	*addr = c.addr
+	*setIPv4 = c.ipv4
+	*setIPv6 = c.ipv6
+	*dnsProvider = c.dnsProvider
+	*dnsID = c.dnsID
+	*dnsKey = c.dnsKey
+	*stunPorts = c.stunPorts
+	*runDERP = c.runDERP
+	*runSTUN = c.runSTUN
+}
+
+var configRollbacks expvar.Int // counter of probation failures that rolled back config
+
+func init() {
+	expvar.Publish("derper_config_rollbacks", &configRollbacks)
+}
+
+// applyManagedConfig validates the controller-pushed naviInfo and, only
+// if it validates, applies it to the flag-backed settings in one step.
+// The previous config is returned so the caller can arm a probation
+// watch around the change.
+func applyManagedConfig(s *derp.Server, naviInfo derp.NaviNode) (prev naviConfig, err error) {
+	prev = captureNaviConfig()
+	next := prev
+	// UpdateNaviInfo still deals in a single STUN port. Feed it the
+	// first locally configured port and fold the result back into the
+	// list, keeping any extra local ports (e.g. 443) as they are.
+	ports, _ := parseSTUNPorts(next.stunPorts)
+	firstPort := 3478
+	if len(ports) > 0 {
+		firstPort = ports[0]
+	}
+	if err := s.UpdateNaviInfo(naviInfo,
+		&next.hostname, &next.addr, &next.ipv4, &next.ipv6,
+		&next.dnsProvider, &next.dnsID, &next.dnsKey,
+		&firstPort,
+		&next.runDERP, &next.runSTUN,
+	); err != nil {
+		return prev, err
+	}
+	if len(ports) > 0 {
+		ports[0] = firstPort
+	} else {
+		ports = []int{firstPort}
+	}
+	parts := make([]string, len(ports))
+	for i, p := range ports {
+		parts[i] = strconv.Itoa(p)
+	}
+	next.stunPorts = strings.Join(parts, ",")
+	if err := next.validate(); err != nil {
+		return prev, err
+	}
+	next.apply()
+	return prev, nil
+}
+
+const (
+	// probationWindow is how long a freshly applied config change is
+	// watched before it is considered good.
+	probationWindow = 2 * time.Minute
+	// probationInterval is how often error counters are sampled
+	// during the probation window.
+	probationInterval = 10 * time.Second
+	// probationErrLimit is the number of new accept/STUN errors per
+	// sample interval above which the new config is considered
+	// degraded.
+	probationErrLimit = 20
+)
+
+// watchConfigProbation watches the accept and STUN error counters for
+// probationWindow after a config change. If errors spike, it restores
+// prev and asks the main loop to restart with the old settings.
+func watchConfigProbation(prev naviConfig, sigc chan<- os.Signal) {
+	base := probationErrCount()
+	deadline := time.Now().Add(probationWindow)
+	for time.Now().Before(deadline) {
+		time.Sleep(probationInterval)
+		now := probationErrCount()
+		if now-base > probationErrLimit {
+			configRollbacks.Add(1)
+			log.Printf("derper: config probation failed (%d new errors in %v); rolling back to previous config", now-base, probationInterval)
+			prev.apply()
+			sigc <- syscall.SIGUSR2
+			return
+		}
+		base = now
+	}
+}
+
+// activeListener is the most recently created rate limited listener,
+// read by the probation watcher for its reject counter.
+var activeListener atomic.Pointer[rateLimitedListener]
+
+// probationErrCount sums the error counters the probation watch cares
+// about: connections rejected at accept, and STUN read/write errors.
+func probationErrCount() int64 {
+	n := stunReadError.Value() + stunWriteError.Value()
+	if l := activeListener.Load(); l != nil {
+		n += l.numRejects.Value()
+	}
+	return n
+}
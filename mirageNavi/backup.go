@@ -0,0 +1,143 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	backupDir      = flag.String("backup-dir", "", "if non-empty, directory to write periodic state backups to; each backup snapshots the node state file, cert cache and traffic stats (point this at a mounted or synced bucket for off-host copies)")
+	backupInterval = flag.Duration("backup-interval", 24*time.Hour, "how often to take a state backup when --backup-dir is set")
+	backupKeep     = flag.Int("backup-keep", 7, "number of state backups to retain; older ones are deleted")
+)
+
+var backupLoopStarted bool
+
+// startBackupLoop arranges for periodic state backups if --backup-dir is
+// set. Backups exist so a relay's identity (node key) and certs survive
+// the host dying; losing them means clients see a brand new relay.
+func startBackupLoop() {
+	if *backupDir == "" || backupLoopStarted {
+		return
+	}
+	backupLoopStarted = true
+	go func() {
+		// Take one backup right away so a freshly provisioned node is
+		// covered before the first interval elapses.
+		if err := backupState(); err != nil {
+			log.Printf("derper: backup: %v", err)
+		}
+		ticker := time.NewTicker(*backupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := backupState(); err != nil {
+				log.Printf("derper: backup: %v", err)
+			}
+		}
+	}()
+}
+
+// backupState writes one timestamped snapshot under *backupDir and
+// prunes old ones down to *backupKeep.
+func backupState() error {
+	snapDir := filepath.Join(*backupDir, "navi-backup-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(snapDir, 0700); err != nil {
+		return err
+	}
+	if err := backupFile(*configPath, filepath.Join(snapDir, filepath.Base(*configPath))); err != nil {
+		return fmt.Errorf("state file: %w", err)
+	}
+	for _, dir := range []string{certCachePath(), certCachePath() + "-rsa"} {
+		if _, err := os.Stat(dir); err != nil {
+			continue // no cert cache (yet); nothing to back up
+		}
+		if err := backupTree(dir, filepath.Join(snapDir, filepath.Base(dir))); err != nil {
+			return fmt.Errorf("cert cache: %w", err)
+		}
+	}
+	if v := expvar.Get("derp"); v != nil {
+		if err := os.WriteFile(filepath.Join(snapDir, "stats.json"), []byte(v.String()), 0600); err != nil {
+			return fmt.Errorf("stats: %w", err)
+		}
+	}
+	if err := pruneBackups(); err != nil {
+		return fmt.Errorf("prune: %w", err)
+	}
+	log.Printf("derper: backup: wrote %s", snapDir)
+	return nil
+}
+
+func backupFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func backupTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		return backupFile(path, target)
+	})
+}
+
+// pruneBackups removes the oldest snapshots beyond *backupKeep. The
+// timestamped names sort chronologically, so lexical order suffices.
+func pruneBackups() error {
+	if *backupKeep <= 0 {
+		return nil
+	}
+	ents, err := os.ReadDir(*backupDir)
+	if err != nil {
+		return err
+	}
+	var snaps []string
+	for _, e := range ents {
+		if e.IsDir() && strings.HasPrefix(e.Name(), "navi-backup-") {
+			snaps = append(snaps, e.Name())
+		}
+	}
+	sort.Strings(snaps)
+	for len(snaps) > *backupKeep {
+		if err := os.RemoveAll(filepath.Join(*backupDir, snaps[0])); err != nil {
+			return err
+		}
+		snaps = snaps[1:]
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/types/key"
+)
+
+// runBench relays packets between two loopback clients through an
+// in-process server for the given duration and reports throughput.
+// It's a smoke test for sizing, not a substitute for load testing over
+// a real network.
+func runBench(ctx context.Context, duration time.Duration, size int) error {
+	if size <= 0 || size > derp.MaxPacketSize {
+		return fmt.Errorf("--size must be between 1 and %v", derp.MaxPacketSize)
+	}
+
+	s := derp.NewServer(key.NewNode(), log.Printf)
+	defer s.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	srv := &http.Server{Handler: derphttp.Handler(s)}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	serverURL := "http://" + ln.Addr().String() + "/derp"
+	newClient := func() (*derphttp.Client, key.NodePrivate, error) {
+		priv := key.NewNode()
+		c, err := derphttp.NewClient(priv, serverURL, log.Printf)
+		if err != nil {
+			return nil, priv, err
+		}
+		if err := c.Connect(ctx); err != nil {
+			c.Close()
+			return nil, priv, err
+		}
+		return c, priv, nil
+	}
+	sender, _, err := newClient()
+	if err != nil {
+		return fmt.Errorf("connecting sender: %w", err)
+	}
+	defer sender.Close()
+	receiver, receiverPriv, err := newClient()
+	if err != nil {
+		return fmt.Errorf("connecting receiver: %w", err)
+	}
+	defer receiver.Close()
+
+	payload := make([]byte, size)
+	rand.Read(payload)
+
+	recvDone := make(chan int64)
+	go func() {
+		var n int64
+		for {
+			m, err := receiver.Recv()
+			if err != nil {
+				recvDone <- n
+				return
+			}
+			if _, ok := m.(derp.ReceivedPacket); ok {
+				n++
+			}
+		}
+	}()
+
+	// The client handshake doesn't wait for server-side registration,
+	// so the first sends can be dropped; they just count as drops.
+	start := time.Now()
+	deadline := start.Add(duration)
+	var sent int64
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		if err := sender.Send(receiverPriv.Public(), payload); err != nil {
+			return fmt.Errorf("send after %d packets: %w", sent, err)
+		}
+		sent++
+	}
+	elapsed := time.Since(start)
+
+	// Give in-flight packets a moment to drain, then stop the receiver.
+	time.Sleep(500 * time.Millisecond)
+	receiver.Close()
+	received := <-recvDone
+
+	secs := elapsed.Seconds()
+	fmt.Printf("sent:     %d packets (%.0f/sec)\n", sent, float64(sent)/secs)
+	fmt.Printf("received: %d packets (%.0f/sec, %.1f MiB/sec)\n",
+		received, float64(received)/secs, float64(received)*float64(size)/secs/(1<<20))
+	if sent > 0 {
+		fmt.Printf("dropped:  %d packets (%.2f%%)\n", sent-received, 100*float64(sent-received)/float64(sent))
+	}
+	return nil
+}
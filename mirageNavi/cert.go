@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"path/filepath"
 	"regexp"
 
@@ -29,7 +30,7 @@ type certProvider interface {
 	HTTPHandler(fallback http.Handler) http.Handler
 }
 
-func certProviderByCertMode(mode, dir, hostname string) (certProvider, error) {
+func certProviderByCertMode(mode, dir string, hostnames []string) (certProvider, error) {
 	if dir == "" {
 		return nil, errors.New("missing required --certdir flag")
 	}
@@ -37,7 +38,7 @@ func certProviderByCertMode(mode, dir, hostname string) (certProvider, error) {
 	case "letsencrypt":
 		certManager := &autocert.Manager{
 			Prompt:     autocert.AcceptTOS,
-			HostPolicy: autocert.HostWhitelist(hostname),
+			HostPolicy: autocert.HostWhitelist(hostnames...),
 			Cache:      autocert.DirCache(dir),
 			Email:      "gps949@outlook.com",
 		}
@@ -49,35 +50,66 @@ func certProviderByCertMode(mode, dir, hostname string) (certProvider, error) {
 		*/
 		return certManager, nil
 	case "manual":
-		return NewManualCertManager(dir, hostname)
+		return NewManualCertManager(dir, hostnames)
+	case "selfsigned":
+		return NewSelfSignedCertManager(dir, hostnames)
 	default:
 		return nil, fmt.Errorf("unsupport cert mode: %q", mode)
 	}
 }
 
 type manualCertManager struct {
-	cert     *tls.Certificate
-	hostname string
+	certs    map[string]*tls.Certificate // hostname -> cert
+	rsaCerts map[string]*tls.Certificate // optional RSA fallbacks for clients without ECDSA support
 }
 
-// NewManualCertManager returns a cert provider which read certificate by given hostname on create.
-func NewManualCertManager(certdir, hostname string) (certProvider, error) {
-	keyname := unsafeHostnameCharacters.ReplaceAllString(hostname, "")
-	crtPath := filepath.Join(certdir, keyname+".crt")
-	keyPath := filepath.Join(certdir, keyname+".key")
-	cert, err := tls.LoadX509KeyPair(crtPath, keyPath)
-	if err != nil {
-		return nil, fmt.Errorf("can not load x509 key pair for hostname %q: %w", keyname, err)
+// NewManualCertManager returns a cert provider which reads a certificate for
+// each given hostname on create, serving them by SNI.
+//
+// Besides the primary <hostname>.crt/.key pair, an optional
+// <hostname>.rsa.crt/.rsa.key pair may be placed in certdir. When present it
+// is served to clients whose handshake can't use the primary (typically
+// legacy embedded clients without ECDSA support).
+func NewManualCertManager(certdir string, hostnames []string) (certProvider, error) {
+	cm := &manualCertManager{
+		certs:    map[string]*tls.Certificate{},
+		rsaCerts: map[string]*tls.Certificate{},
 	}
-	// ensure hostname matches with the certificate
-	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
-	if err != nil {
-		return nil, fmt.Errorf("can not load cert: %w", err)
-	}
-	if err := x509Cert.VerifyHostname(hostname); err != nil {
-		return nil, fmt.Errorf("cert invalid for hostname %q: %w", hostname, err)
+	for _, hostname := range hostnames {
+		keyname := unsafeHostnameCharacters.ReplaceAllString(hostname, "")
+		crtPath := filepath.Join(certdir, keyname+".crt")
+		keyPath := filepath.Join(certdir, keyname+".key")
+		cert, err := tls.LoadX509KeyPair(crtPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("can not load x509 key pair for hostname %q: %w", keyname, err)
+		}
+		// ensure hostname matches with the certificate
+		x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("can not load cert: %w", err)
+		}
+		if err := x509Cert.VerifyHostname(hostname); err != nil {
+			return nil, fmt.Errorf("cert invalid for hostname %q: %w", hostname, err)
+		}
+		cm.certs[hostname] = &cert
+		rsaCrtPath := filepath.Join(certdir, keyname+".rsa.crt")
+		rsaKeyPath := filepath.Join(certdir, keyname+".rsa.key")
+		if _, err := os.Stat(rsaCrtPath); err == nil {
+			rsaCert, err := tls.LoadX509KeyPair(rsaCrtPath, rsaKeyPath)
+			if err != nil {
+				return nil, fmt.Errorf("can not load RSA x509 key pair for hostname %q: %w", keyname, err)
+			}
+			x509RSACert, err := x509.ParseCertificate(rsaCert.Certificate[0])
+			if err != nil {
+				return nil, fmt.Errorf("can not load RSA cert: %w", err)
+			}
+			if err := x509RSACert.VerifyHostname(hostname); err != nil {
+				return nil, fmt.Errorf("RSA cert invalid for hostname %q: %w", hostname, err)
+			}
+			cm.rsaCerts[hostname] = &rsaCert
+		}
 	}
-	return &manualCertManager{cert: &cert, hostname: hostname}, nil
+	return cm, nil
 }
 
 func (m *manualCertManager) TLSConfig() *tls.Config {
@@ -91,14 +123,32 @@ func (m *manualCertManager) TLSConfig() *tls.Config {
 }
 
 func (m *manualCertManager) getCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	if hi.ServerName != m.hostname {
+	name := hi.ServerName
+	cert, ok := m.certs[name]
+	if !ok {
+		// No exact entry; try wildcard entries covering the SNI.
+		for pattern := range m.certs {
+			if hostnameMatches(pattern, hi.ServerName) {
+				name, cert, ok = pattern, m.certs[pattern], true
+				break
+			}
+		}
+	}
+	if !ok {
 		return nil, fmt.Errorf("cert mismatch with hostname: %q", hi.ServerName)
 	}
 
+	// Prefer the primary cert, falling back to the RSA one (if any) for
+	// clients that can't use the primary.
+	src := cert
+	if rsaCert, ok := m.rsaCerts[name]; ok && hi.SupportsCertificate(cert) != nil {
+		src = rsaCert
+	}
+
 	// Return a shallow copy of the cert so the caller can append to its
 	// Certificate field.
 	certCopy := new(tls.Certificate)
-	*certCopy = *m.cert
+	*certCopy = *src
 	certCopy.Certificate = certCopy.Certificate[:len(certCopy.Certificate):len(certCopy.Certificate)]
 	return certCopy, nil
 }
@@ -0,0 +1,810 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/caddyserver/certmagic"
+)
+
+// Shared certificate storage. With a single replica the certmagic
+// state lives in the local file cache, but several Navi replicas
+// behind one hostname must share certificates and ACME account
+// state, or each of them races to issue its own cert and burns
+// through CA rate limits. --cert-store points them all at one
+// backend:
+//
+//	s3://bucket[/prefix][?region=r&endpoint=https://minio:9000]
+//	redis://[:password@]host:port[/db]
+//	etcd://host:port[/prefix][?tls=1]
+//
+// The redis and etcd backends speak the wire protocols directly
+// (RESP and the v3 JSON gateway respectively), so no extra daemons
+// or sidecars are needed.
+
+var certStoreSpec = flag.String("cert-store", "", "shared storage for letsencrypt certs and ACME state (s3://, redis:// or etcd:// URL); empty keeps the local file cache. Use when several replicas serve the same hostname")
+
+// certStoreLockTTL is how long a distributed lock survives without
+// its holder releasing it, so a crashed replica doesn't wedge
+// issuance forever.
+const certStoreLockTTL = 2 * time.Minute
+
+// certStoreLockPoll is how often a blocked Lock retries.
+const certStoreLockPoll = time.Second
+
+// openCertStorage returns the certmagic storage selected by
+// --cert-store: the main one and a second namespace for the parallel
+// RSA certificate config.
+func openCertStorage() (main, rsa certmagic.Storage, err error) {
+	if *certStoreSpec == "" {
+		return &certmagic.FileStorage{Path: certCachePath()},
+			&certmagic.FileStorage{Path: certCachePath() + "-rsa"}, nil
+	}
+	u, err := url.Parse(*certStoreSpec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid --cert-store: %w", err)
+	}
+	var st certmagic.Storage
+	switch u.Scheme {
+	case "s3":
+		st, err = newS3Storage(u)
+	case "redis":
+		st, err = newRedisStorage(u)
+	case "etcd":
+		st, err = newEtcdStorage(u)
+	default:
+		err = fmt.Errorf("unsupported --cert-store scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return st, &prefixStorage{inner: st, prefix: "rsa/"}, nil
+}
+
+// listChildren post-processes a recursive key listing into
+// certmagic's non-recursive List semantics: only the immediate
+// children of prefix, with "directory" keys listed once.
+func listChildren(keys []string, prefix string) []string {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, k := range keys {
+		rest := strings.TrimPrefix(k, prefix)
+		if rest == k && prefix != "" {
+			continue
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			rest = rest[:i]
+		}
+		child := prefix + rest
+		if !seen[child] {
+			seen[child] = true
+			out = append(out, child)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// prefixStorage namespaces an underlying storage under a key prefix.
+type prefixStorage struct {
+	inner  certmagic.Storage
+	prefix string
+}
+
+func (p *prefixStorage) Store(ctx context.Context, key string, value []byte) error {
+	return p.inner.Store(ctx, p.prefix+key, value)
+}
+func (p *prefixStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	return p.inner.Load(ctx, p.prefix+key)
+}
+func (p *prefixStorage) Delete(ctx context.Context, key string) error {
+	return p.inner.Delete(ctx, p.prefix+key)
+}
+func (p *prefixStorage) Exists(ctx context.Context, key string) bool {
+	return p.inner.Exists(ctx, p.prefix+key)
+}
+func (p *prefixStorage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	keys, err := p.inner.List(ctx, p.prefix+prefix, recursive)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, strings.TrimPrefix(k, p.prefix))
+	}
+	return out, nil
+}
+func (p *prefixStorage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	ki, err := p.inner.Stat(ctx, p.prefix+key)
+	ki.Key = strings.TrimPrefix(ki.Key, p.prefix)
+	return ki, err
+}
+func (p *prefixStorage) Lock(ctx context.Context, name string) error {
+	return p.inner.Lock(ctx, p.prefix+name)
+}
+func (p *prefixStorage) Unlock(ctx context.Context, name string) error {
+	return p.inner.Unlock(ctx, p.prefix+name)
+}
+
+// storedValue is the envelope the redis and etcd backends keep per
+// key, carrying the modification time Stat needs.
+type storedValue struct {
+	Modified int64  `json:"m"` // unix seconds
+	Value    []byte `json:"v"`
+}
+
+//
+// S3 (and compatible: MinIO, Ceph RGW) backend.
+//
+
+type s3Storage struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func newS3Storage(u *url.URL) (certmagic.Storage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var opts []func(*awsconfig.LoadOptions) error
+	if region := u.Query().Get("region"); region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	if endpoint := u.Query().Get("endpoint"); endpoint != "" {
+		opts = append(opts, awsconfig.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: endpoint, HostnameImmutable: true}, nil
+			})))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("s3 cert store: %w", err)
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		// Path-style requests work with MinIO and friends, which
+		// rarely have per-bucket DNS.
+		o.UsePathStyle = u.Query().Get("endpoint") != ""
+	})
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3Storage{client: client, bucket: u.Host, prefix: prefix}, nil
+}
+
+// s3NotFound reports whether err is the S3 "no such key" family.
+func s3NotFound(err error) bool {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "NoSuchKey", "NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+func (st *s3Storage) Store(ctx context.Context, key string, value []byte) error {
+	_, err := st.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(st.prefix + key),
+		Body:   bytes.NewReader(value),
+	})
+	return err
+}
+
+func (st *s3Storage) Load(ctx context.Context, key string) ([]byte, error) {
+	out, err := st.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(st.prefix + key),
+	})
+	if err != nil {
+		if s3NotFound(err) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (st *s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := st.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(st.prefix + key),
+	})
+	return err
+}
+
+func (st *s3Storage) Exists(ctx context.Context, key string) bool {
+	_, err := st.stat(ctx, key)
+	return err == nil
+}
+
+func (st *s3Storage) stat(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	out, err := st.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(st.prefix + key),
+	})
+	if err != nil && s3NotFound(err) {
+		return nil, fs.ErrNotExist
+	}
+	return out, err
+}
+
+func (st *s3Storage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	var keys []string
+	p := s3.NewListObjectsV2Paginator(st.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(st.bucket),
+		Prefix: aws.String(st.prefix + prefix),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(obj.Key), st.prefix))
+		}
+	}
+	if !recursive {
+		keys = listChildren(keys, prefix)
+	}
+	return keys, nil
+}
+
+func (st *s3Storage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	out, err := st.stat(ctx, key)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	return certmagic.KeyInfo{
+		Key:        key,
+		Modified:   aws.ToTime(out.LastModified),
+		Size:       out.ContentLength,
+		IsTerminal: true,
+	}, nil
+}
+
+// Lock is best-effort: S3 has no atomic create, so the lock object
+// is polled and (re)written with a timestamp; certStoreLockTTL
+// bounds how long a crashed holder blocks others. The window between
+// observing no lock and writing ours is small compared to ACME
+// issuance times, and certmagic retries idempotently anyway.
+func (st *s3Storage) Lock(ctx context.Context, name string) error {
+	lockKey := "locks/" + name
+	for {
+		body, err := st.Load(ctx, lockKey)
+		if errors.Is(err, fs.ErrNotExist) {
+			return st.Store(ctx, lockKey, []byte(fmt.Sprint(time.Now().Unix())))
+		}
+		if err == nil {
+			if ts, err := strconv.ParseInt(string(body), 10, 64); err != nil ||
+				time.Since(time.Unix(ts, 0)) > certStoreLockTTL {
+				// Stale or garbled: take it over.
+				return st.Store(ctx, lockKey, []byte(fmt.Sprint(time.Now().Unix())))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(certStoreLockPoll):
+		}
+	}
+}
+
+func (st *s3Storage) Unlock(ctx context.Context, name string) error {
+	return st.Delete(ctx, "locks/"+name)
+}
+
+//
+// Redis backend, speaking RESP directly.
+//
+
+type redisStorage struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+
+	mu   sync.Mutex
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func newRedisStorage(u *url.URL) (certmagic.Storage, error) {
+	st := &redisStorage{addr: u.Host, prefix: "certmagic/"}
+	if pw, ok := u.User.Password(); ok {
+		st.password = pw
+	}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		db, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("redis cert store: bad db number %q", p)
+		}
+		st.db = db
+	}
+	// Fail fast on unreachable/misconfigured servers.
+	if _, err := st.do(context.Background(), "PING"); err != nil {
+		return nil, fmt.Errorf("redis cert store: %w", err)
+	}
+	return st, nil
+}
+
+// do sends one command and reads its reply, dialing (and
+// authenticating) on demand and redialing once on a broken
+// connection.
+func (st *redisStorage) do(ctx context.Context, args ...string) (redisReply, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	reply, err := st.doLocked(ctx, args...)
+	if err != nil && st.conn != nil {
+		st.conn.Close()
+		st.conn = nil
+		reply, err = st.doLocked(ctx, args...)
+	}
+	return reply, err
+}
+
+func (st *redisStorage) doLocked(ctx context.Context, args ...string) (redisReply, error) {
+	if st.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", st.addr)
+		if err != nil {
+			return redisReply{}, err
+		}
+		st.conn = conn
+		st.br = bufio.NewReader(conn)
+		if st.password != "" {
+			if _, err := st.roundTrip(ctx, "AUTH", st.password); err != nil {
+				return redisReply{}, err
+			}
+		}
+		if st.db != 0 {
+			if _, err := st.roundTrip(ctx, "SELECT", strconv.Itoa(st.db)); err != nil {
+				return redisReply{}, err
+			}
+		}
+	}
+	return st.roundTrip(ctx, args...)
+}
+
+func (st *redisStorage) roundTrip(ctx context.Context, args ...string) (redisReply, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		st.conn.SetDeadline(deadline)
+	} else {
+		st.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := st.conn.Write(buf.Bytes()); err != nil {
+		return redisReply{}, err
+	}
+	return readRedisReply(st.br)
+}
+
+// redisReply is one parsed RESP reply; only the shapes the storage
+// needs are represented.
+type redisReply struct {
+	null bool
+	str  []byte
+	n    int64
+	arr  [][]byte
+}
+
+func readRedisReply(br *bufio.Reader) (redisReply, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return redisReply{}, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return redisReply{}, errors.New("redis: empty reply")
+	}
+	body := line[1:]
+	switch line[0] {
+	case '+':
+		return redisReply{str: []byte(body)}, nil
+	case '-':
+		return redisReply{}, errors.New("redis: " + body)
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		return redisReply{n: n}, err
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return redisReply{}, err
+		}
+		if n < 0 {
+			return redisReply{null: true}, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return redisReply{}, err
+		}
+		return redisReply{str: b[:n]}, nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return redisReply{}, err
+		}
+		if n < 0 {
+			return redisReply{null: true}, nil
+		}
+		r := redisReply{}
+		for i := 0; i < n; i++ {
+			el, err := readRedisReply(br)
+			if err != nil {
+				return redisReply{}, err
+			}
+			r.arr = append(r.arr, el.str)
+		}
+		return r, nil
+	}
+	return redisReply{}, fmt.Errorf("redis: unexpected reply type %q", line[0])
+}
+
+func (st *redisStorage) Store(ctx context.Context, key string, value []byte) error {
+	env, err := json.Marshal(storedValue{Modified: time.Now().Unix(), Value: value})
+	if err != nil {
+		return err
+	}
+	_, err = st.do(ctx, "SET", st.prefix+key, string(env))
+	return err
+}
+
+func (st *redisStorage) load(ctx context.Context, key string) (storedValue, error) {
+	reply, err := st.do(ctx, "GET", st.prefix+key)
+	if err != nil {
+		return storedValue{}, err
+	}
+	if reply.null {
+		return storedValue{}, fs.ErrNotExist
+	}
+	var env storedValue
+	if err := json.Unmarshal(reply.str, &env); err != nil {
+		return storedValue{}, err
+	}
+	return env, nil
+}
+
+func (st *redisStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	env, err := st.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return env.Value, nil
+}
+
+func (st *redisStorage) Delete(ctx context.Context, key string) error {
+	_, err := st.do(ctx, "DEL", st.prefix+key)
+	return err
+}
+
+func (st *redisStorage) Exists(ctx context.Context, key string) bool {
+	reply, err := st.do(ctx, "EXISTS", st.prefix+key)
+	return err == nil && reply.n == 1
+}
+
+func (st *redisStorage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	reply, err := st.do(ctx, "KEYS", st.prefix+prefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, k := range reply.arr {
+		keys = append(keys, strings.TrimPrefix(string(k), st.prefix))
+	}
+	if !recursive {
+		keys = listChildren(keys, prefix)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (st *redisStorage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	env, err := st.load(ctx, key)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	return certmagic.KeyInfo{
+		Key:        key,
+		Modified:   time.Unix(env.Modified, 0),
+		Size:       int64(len(env.Value)),
+		IsTerminal: true,
+	}, nil
+}
+
+// Lock uses SET NX PX, which is atomic server-side; the TTL releases
+// locks whose holder died.
+func (st *redisStorage) Lock(ctx context.Context, name string) error {
+	key := st.prefix + "locks/" + name
+	ttl := strconv.FormatInt(certStoreLockTTL.Milliseconds(), 10)
+	for {
+		reply, err := st.do(ctx, "SET", key, "1", "NX", "PX", ttl)
+		if err != nil {
+			return err
+		}
+		if !reply.null { // "OK"
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(certStoreLockPoll):
+		}
+	}
+}
+
+func (st *redisStorage) Unlock(ctx context.Context, name string) error {
+	_, err := st.do(ctx, "DEL", st.prefix+"locks/"+name)
+	return err
+}
+
+//
+// etcd backend, via the v3 JSON gateway.
+//
+
+type etcdStorage struct {
+	base   string // e.g. http://127.0.0.1:2379
+	prefix string
+	hc     *http.Client
+
+	mu     sync.Mutex
+	leases map[string]string // lock name -> lease ID
+}
+
+func newEtcdStorage(u *url.URL) (certmagic.Storage, error) {
+	scheme := "http"
+	if u.Query().Get("tls") != "" {
+		scheme = "https"
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix == "" {
+		prefix = "certmagic"
+	}
+	st := &etcdStorage{
+		base:   scheme + "://" + u.Host,
+		prefix: prefix + "/",
+		hc:     &http.Client{Timeout: 30 * time.Second},
+		leases: map[string]string{},
+	}
+	// Fail fast on unreachable servers.
+	var out struct{}
+	if err := st.call(context.Background(), "/v3/kv/range", map[string]any{
+		"key": b64(st.prefix),
+	}, &out); err != nil {
+		return nil, fmt.Errorf("etcd cert store: %w", err)
+	}
+	return st, nil
+}
+
+func b64(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+// prefixRangeEnd returns the etcd range_end covering every key with
+// the given prefix.
+func prefixRangeEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return base64.StdEncoding.EncodeToString(end[:i+1])
+		}
+	}
+	return base64.StdEncoding.EncodeToString([]byte{0})
+}
+
+func (st *etcdStorage) call(ctx context.Context, path string, reqBody, out any) error {
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", st.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := st.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("etcd: %s: %s", resp.Status, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type etcdKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKV `json:"kvs"`
+}
+
+func (st *etcdStorage) Store(ctx context.Context, key string, value []byte) error {
+	env, err := json.Marshal(storedValue{Modified: time.Now().Unix(), Value: value})
+	if err != nil {
+		return err
+	}
+	var out struct{}
+	return st.call(ctx, "/v3/kv/put", map[string]any{
+		"key":   b64(st.prefix + key),
+		"value": base64.StdEncoding.EncodeToString(env),
+	}, &out)
+}
+
+func (st *etcdStorage) load(ctx context.Context, key string) (storedValue, error) {
+	var out etcdRangeResponse
+	if err := st.call(ctx, "/v3/kv/range", map[string]any{
+		"key": b64(st.prefix + key),
+	}, &out); err != nil {
+		return storedValue{}, err
+	}
+	if len(out.Kvs) == 0 {
+		return storedValue{}, fs.ErrNotExist
+	}
+	raw, err := base64.StdEncoding.DecodeString(out.Kvs[0].Value)
+	if err != nil {
+		return storedValue{}, err
+	}
+	var env storedValue
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return storedValue{}, err
+	}
+	return env, nil
+}
+
+func (st *etcdStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	env, err := st.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return env.Value, nil
+}
+
+func (st *etcdStorage) Delete(ctx context.Context, key string) error {
+	var out struct{}
+	return st.call(ctx, "/v3/kv/deleterange", map[string]any{
+		"key": b64(st.prefix + key),
+	}, &out)
+}
+
+func (st *etcdStorage) Exists(ctx context.Context, key string) bool {
+	_, err := st.load(ctx, key)
+	return err == nil
+}
+
+func (st *etcdStorage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	full := st.prefix + prefix
+	var out etcdRangeResponse
+	if err := st.call(ctx, "/v3/kv/range", map[string]any{
+		"key":       b64(full),
+		"range_end": prefixRangeEnd(full),
+		"keys_only": true,
+	}, &out); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, kv := range out.Kvs {
+		raw, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, strings.TrimPrefix(string(raw), st.prefix))
+	}
+	if !recursive {
+		keys = listChildren(keys, prefix)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (st *etcdStorage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	env, err := st.load(ctx, key)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	return certmagic.KeyInfo{
+		Key:        key,
+		Modified:   time.Unix(env.Modified, 0),
+		Size:       int64(len(env.Value)),
+		IsTerminal: true,
+	}, nil
+}
+
+// Lock takes an etcd lease (so a dead holder's lock expires) and
+// claims the lock key with a create-revision-zero transaction, which
+// is atomic server-side.
+func (st *etcdStorage) Lock(ctx context.Context, name string) error {
+	key := st.prefix + "locks/" + name
+	for {
+		var lease struct {
+			ID string `json:"ID"`
+		}
+		if err := st.call(ctx, "/v3/lease/grant", map[string]any{
+			"TTL": int64(certStoreLockTTL.Seconds()),
+		}, &lease); err != nil {
+			return err
+		}
+		var txn struct {
+			Succeeded bool `json:"succeeded"`
+		}
+		if err := st.call(ctx, "/v3/kv/txn", map[string]any{
+			"compare": []map[string]any{{
+				"key":             b64(key),
+				"target":          "CREATE",
+				"create_revision": "0",
+			}},
+			"success": []map[string]any{{
+				"request_put": map[string]any{
+					"key":   b64(key),
+					"value": b64("1"),
+					"lease": lease.ID,
+				},
+			}},
+		}, &txn); err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			st.mu.Lock()
+			st.leases[name] = lease.ID
+			st.mu.Unlock()
+			return nil
+		}
+		var out struct{}
+		st.call(ctx, "/v3/lease/revoke", map[string]any{"ID": lease.ID}, &out)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(certStoreLockPoll):
+		}
+	}
+}
+
+func (st *etcdStorage) Unlock(ctx context.Context, name string) error {
+	st.mu.Lock()
+	lease := st.leases[name]
+	delete(st.leases, name)
+	st.mu.Unlock()
+	if err := st.Delete(ctx, "locks/"+name); err != nil {
+		return err
+	}
+	if lease != "" {
+		var out struct{}
+		st.call(ctx, "/v3/lease/revoke", map[string]any{"ID": lease}, &out)
+	}
+	return nil
+}
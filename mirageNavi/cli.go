@@ -0,0 +1,164 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/version"
+)
+
+// Subcommand dispatch. The global flag set predates subcommands, so the
+// root command keeps it and behaves exactly like "serve": running the
+// binary with flags alone, as every existing deployment does, still
+// works.
+
+func newRootCmd() *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "mirageNavi",
+		ShortUsage: "mirageNavi [flags]\nmirageNavi <subcommand> [flags]",
+		ShortHelp:  "Run the Mirage DERP relay (司南).",
+		FlagSet:    flag.CommandLine,
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "serve",
+				ShortUsage: "mirageNavi serve [flags]",
+				ShortHelp:  "Run the relay server (the default when no subcommand is given)",
+				FlagSet:    flag.CommandLine,
+				Exec: func(ctx context.Context, args []string) error {
+					runServe()
+					return nil
+				},
+			},
+			{
+				Name:       "register",
+				ShortUsage: "mirageNavi register --ctrl-url=<url> --derpid=<id> [flags]",
+				ShortHelp:  "Create a managed-node config for a control server without serving",
+				FlagSet:    flag.CommandLine,
+				Exec:       runRegisterCmd,
+			},
+			{
+				Name:       "validate",
+				ShortUsage: "mirageNavi validate [flags]",
+				ShortHelp:  "Check the config file and print a summary",
+				FlagSet:    flag.CommandLine,
+				Exec:       runValidateCmd,
+			},
+			{
+				Name:       "export-identity",
+				ShortUsage: "mirageNavi export-identity <file> [flags]",
+				ShortHelp:  "Write an encrypted copy of this node's identity to a file",
+				FlagSet:    flag.CommandLine,
+				Exec: func(ctx context.Context, args []string) error {
+					if len(args) != 1 {
+						return errors.New("usage: mirageNavi export-identity <file>")
+					}
+					os.Exit(runExportIdentity(args[0]))
+					return nil
+				},
+			},
+			newBenchCmd(),
+			newConformCmd(),
+			{
+				Name:       "version",
+				ShortUsage: "mirageNavi version",
+				ShortHelp:  "Print version and exit",
+				Exec: func(ctx context.Context, args []string) error {
+					fmt.Println(version.Long())
+					return nil
+				},
+			},
+		},
+		Exec: func(ctx context.Context, args []string) error {
+			runServe()
+			return nil
+		},
+	}
+}
+
+func runRegisterCmd(ctx context.Context, args []string) error {
+	if *ctrlURL == "" || *derpID == "" {
+		return errors.New("register requires --ctrl-url and --derpid")
+	}
+	resolveConfigPath()
+	if _, err := os.Stat(*configPath); err == nil {
+		return fmt.Errorf("config %s already exists; refusing to overwrite it", *configPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	cfg := writeNewConfig(*ctrlURL, *derpID)
+	fmt.Printf("已注册司南节点 %s (控制器 %s)\n", cfg.DERPID, cfg.CtrlURL)
+	fmt.Printf("config: %s\n", *configPath)
+	fmt.Printf("navi key: %s\n", cfg.NaviKey.Public().String())
+	return nil
+}
+
+func runValidateCmd(ctx context.Context, args []string) error {
+	resolveConfigPath()
+	b, err := os.ReadFile(*configPath)
+	if err != nil {
+		return err
+	}
+	var cfg config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return fmt.Errorf("config %s: %v", *configPath, err)
+	}
+	if cfg.PrivateKey.IsZero() {
+		return fmt.Errorf("config %s: missing private key", *configPath)
+	}
+	if (cfg.CtrlURL == "") != (cfg.DERPID == "") {
+		return fmt.Errorf("config %s: CtrlURL and DERPID must be set together", *configPath)
+	}
+	fmt.Printf("config: %s: OK\n", *configPath)
+	if cfg.CtrlURL != "" {
+		fmt.Printf("managed node %s, controlled by %s\n", cfg.DERPID, cfg.CtrlURL)
+	} else {
+		fmt.Println("standalone node")
+	}
+	fmt.Printf("derp key: %s\n", cfg.PrivateKey.Public().String())
+	return nil
+}
+
+func newConformCmd() *ffcli.Command {
+	fs := flag.NewFlagSet("conform", flag.ExitOnError)
+	stunAddr := fs.String("stun", "", "optional host:port of the relay's STUN listener to check")
+	skipDERP := fs.Bool("skip-derp", false, "skip the DERP relaying check (e.g. when the relay requires client verification)")
+	timeout := fs.Duration("timeout", 30*time.Second, "overall deadline for all checks")
+	return &ffcli.Command{
+		Name:       "conform",
+		ShortUsage: "mirageNavi conform [--stun=host:3478] <server-url>",
+		ShortHelp:  "Run conformance checks against a running relay",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return errors.New("usage: mirageNavi conform [flags] <server-url>")
+			}
+			ctx, cancel := context.WithTimeout(ctx, *timeout)
+			defer cancel()
+			return runConform(ctx, args[0], *stunAddr, *skipDERP)
+		},
+	}
+}
+
+func newBenchCmd() *ffcli.Command {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	duration := fs.Duration("duration", 5*time.Second, "how long to run the benchmark")
+	size := fs.Int("size", 1200, "packet payload size in bytes")
+	return &ffcli.Command{
+		Name:       "bench",
+		ShortUsage: "mirageNavi bench [--duration=5s] [--size=1200]",
+		ShortHelp:  "Benchmark relay throughput with loopback clients",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			return runBench(ctx, *duration, *size)
+		},
+	}
+}
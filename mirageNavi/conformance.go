@@ -0,0 +1,215 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/net/stun"
+	"tailscale.com/types/key"
+)
+
+// runConform exercises a running relay from the outside and reports
+// whether its HTTP, STUN and DERP surfaces behave as clients expect.
+// Unlike --self-test it targets a real deployment, so it's usable as a
+// post-deploy gate and as a conformance check when the surface grows.
+func runConform(ctx context.Context, baseURL, stunAddr string, skipDERP bool) error {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", name, err)
+		} else {
+			fmt.Printf("ok   %s\n", name)
+		}
+	}
+
+	hc := &http.Client{Timeout: 15 * time.Second}
+
+	check("GET /derp/probe", checkProbe(ctx, hc, baseURL, "GET"))
+	check("HEAD /derp/probe", checkProbe(ctx, hc, baseURL, "HEAD"))
+	check("POST /derp/probe rejected", func() error {
+		resp, err := httpDo(ctx, hc, "POST", baseURL+"/derp/probe")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("got %v, want %v", resp.StatusCode, http.StatusMethodNotAllowed)
+		}
+		return nil
+	}())
+	check("GET /generate_204", func() error {
+		resp, err := httpDo(ctx, hc, "GET", baseURL+"/generate_204")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("got %v, want %v", resp.StatusCode, http.StatusNoContent)
+		}
+		return nil
+	}())
+	check("GET /bootstrap-dns", func() error {
+		resp, err := httpDo(ctx, hc, "GET", baseURL+"/bootstrap-dns")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("got %v, want %v", resp.StatusCode, http.StatusOK)
+		}
+		var m map[string][]string
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			return fmt.Errorf("response is not a JSON name→addresses map: %v", err)
+		}
+		return nil
+	}())
+
+	if stunAddr != "" {
+		check("STUN binding "+stunAddr, checkSTUN(ctx, stunAddr))
+	}
+	if !skipDERP {
+		check("DERP relay", checkDERPRelay(ctx, baseURL))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+func httpDo(ctx context.Context, hc *http.Client, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return hc.Do(req)
+}
+
+func checkProbe(ctx context.Context, hc *http.Client, baseURL, method string) error {
+	resp, err := httpDo(ctx, hc, method, baseURL+"/derp/probe")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got %v, want 200", resp.StatusCode)
+	}
+	if ao := resp.Header.Get("Access-Control-Allow-Origin"); ao != "*" {
+		return fmt.Errorf("Access-Control-Allow-Origin = %q, want %q (js/wasm clients need it)", ao, "*")
+	}
+	return nil
+}
+
+// checkSTUN sends a binding request and verifies the response carries
+// the same transaction ID and a usable mapped address.
+func checkSTUN(ctx context.Context, stunAddr string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", stunAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+	}
+
+	tx := stun.NewTxID()
+	if _, err := conn.Write(stun.Request(tx)); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("no response: %v", err)
+	}
+	gotTx, addr, err := stun.ParseResponse(buf[:n])
+	if err != nil {
+		return fmt.Errorf("parsing response: %v", err)
+	}
+	if gotTx != tx {
+		return fmt.Errorf("transaction ID mismatch")
+	}
+	if !addr.IsValid() || addr.Port() == 0 {
+		return fmt.Errorf("bogus mapped address %v", addr)
+	}
+	return nil
+}
+
+// checkDERPRelay connects two clients and verifies a packet relayed
+// between them arrives intact.
+func checkDERPRelay(ctx context.Context, baseURL string) error {
+	serverURL := baseURL + "/derp"
+	newClient := func() (*derphttp.Client, key.NodePrivate, error) {
+		priv := key.NewNode()
+		c, err := derphttp.NewClient(priv, serverURL, log.Printf)
+		if err != nil {
+			return nil, priv, err
+		}
+		if err := c.Connect(ctx); err != nil {
+			c.Close()
+			return nil, priv, err
+		}
+		return c, priv, nil
+	}
+	sender, _, err := newClient()
+	if err != nil {
+		return fmt.Errorf("connecting sender: %w", err)
+	}
+	defer sender.Close()
+	receiver, receiverPriv, err := newClient()
+	if err != nil {
+		return fmt.Errorf("connecting receiver: %w", err)
+	}
+	defer receiver.Close()
+
+	const payload = "mirageNavi conformance probe"
+	recvCh := make(chan error, 1)
+	go func() {
+		for {
+			m, err := receiver.Recv()
+			if err != nil {
+				recvCh <- err
+				return
+			}
+			if pkt, ok := m.(derp.ReceivedPacket); ok {
+				if string(pkt.Data) != payload {
+					recvCh <- fmt.Errorf("payload corrupted in transit")
+				} else {
+					recvCh <- nil
+				}
+				return
+			}
+		}
+	}()
+
+	// Server-side registration of the receiver can lag its handshake,
+	// so retry the send a few times before giving up.
+	for i := 0; i < 10; i++ {
+		if err := sender.Send(receiverPriv.Public(), []byte(payload)); err != nil {
+			return fmt.Errorf("send: %w", err)
+		}
+		select {
+		case err := <-recvCh:
+			return err
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("relayed packet never arrived")
+}
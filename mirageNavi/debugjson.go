@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"tailscale.com/derp"
+)
+
+// /debug/navi.json: the same inventory the controller gets over the
+// noise channel (effective flags, cert info, listeners), extended
+// with live server state (mesh peers, client count), as one
+// machine-readable document for fleet auditing scripts that scrape
+// relays directly instead of going through the controller.
+
+// debugInfoReport is the same report builder handed to
+// derp.Server.SetInfoReportFunc; set once cert setup has decided the
+// expiry. nil until then.
+var debugInfoReport func() derp.NaviInfoReport
+
+// naviDebugJSON serves the combined config/state document.
+func naviDebugJSON(s *derp.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc := struct {
+			derp.NaviInfoReport
+			Managed        bool
+			CtrlURL        string   `json:",omitempty"`
+			MeshPeers      []string `json:",omitempty"`
+			CurrentClients int64
+		}{
+			Managed:        *ctrlURL != "" && *derpID != "",
+			CtrlURL:        *ctrlURL,
+			MeshPeers:      s.MeshPeers(),
+			CurrentClients: s.CurrentClients(),
+		}
+		if f := debugInfoReport; f != nil {
+			doc.NaviInfoReport = f()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		e.Encode(doc)
+	})
+}
@@ -6,7 +6,10 @@ package main // import "tailscale.com/cmd/derper"
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/subtle"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"expvar"
@@ -19,12 +22,12 @@ import (
 	"net/http"
 	"net/netip"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -34,6 +37,7 @@ import (
 	"github.com/libdns/libdns"
 	"github.com/libdns/namesilo"
 	"github.com/libdns/tencentcloud"
+	"github.com/mholt/acmez/acme"
 	"go4.org/mem"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/time/rate"
@@ -43,7 +47,9 @@ import (
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/metrics"
 	"tailscale.com/net/stun"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/tsweb"
+	"tailscale.com/tsweb/promvarz"
 	"tailscale.com/types/key"
 )
 
@@ -56,16 +62,29 @@ var (
 	setIPv4     = flag.String("set-ipv4", "", "set IPv4 address")
 	setIPv6     = flag.String("set-ipv6", "", "set IPv6 address")
 
-	dev        = flag.Bool("dev", false, "run in localhost development mode")
-	addr       = flag.String("a", ":443", "server HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces.")
-	httpPort   = flag.Int("http-port", -1, "The port on which to serve HTTP. Set to -1 to disable. The listener is bound to the same IP (if any) as specified in the -a flag.")
-	stunPort   = flag.Int("stun-port", 3478, "The UDP port on which to serve STUN. The listener is bound to the same IP (if any) as specified in the -a flag.")
-	configPath = flag.String("c", "", "config file path")
-	certMode   = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: letsencrypt, manual")
-	certDir    = flag.String("certdir", tsweb.DefaultCertDir("derper-certs"), "directory to store LetsEncrypt certs, if addr's port is :443")
-	hostname   = flag.String("hostname", "derp.tailscale.com", "LetsEncrypt host name, if addr's port is :443")
-	runSTUN    = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
-	runDERP    = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
+	dev              = flag.Bool("dev", false, "run in localhost development mode")
+	insecureDevHTTP  = flag.Bool("insecure-dev-http", false, "serve plaintext HTTP without any TLS; only allowed together with --dev, for local integration tests")
+	addr             = flag.String("a", ":443", "server HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces.")
+	httpPort         = flag.Int("http-port", -1, "The port on which to serve plain HTTP: ACME HTTP-01 challenges, monitoring probes, and redirects to HTTPS. Set to -1 to disable. The listener is bound to the same IP (if any) as specified in the -a flag.")
+	httpAddr         = flag.String("http-addr", "", "optional host:port to bind the plain HTTP listener to, for binding it to a different interface than -a; when set it enables the listener and overrides --http-port")
+	stunPorts        = flag.String("stun-port", "3478", "comma-separated list of UDP ports on which to serve STUN (e.g. 3478,443 for networks that only allow UDP/443 outbound). The listeners are bound to the same IP (if any) as specified in the -a flag.")
+	stunAddr         = flag.String("stun-addr", "", "optional host:port to bind the STUN listener to, for binding it to a different interface than -a; port 0 keeps the --stun-port list on the given IP")
+	acmeHTTP01       = flag.Bool("acme-http01", false, "in letsencrypt mode, validate via the ACME HTTP-01 challenge answered on the plain HTTP listener instead of TLS-ALPN or DNS-01; requires --http-port or --http-addr and port 80 reachability")
+	acmeCA           = flag.String("acme-ca", "letsencrypt", "ACME CA to issue from in letsencrypt mode: \"letsencrypt\", \"zerossl\", or the directory URL of a private ACME CA such as step-ca")
+	acmeEABKID       = flag.String("acme-eab-kid", "", "External Account Binding key ID, for ACME CAs that require EAB (ZeroSSL, many private CAs)")
+	acmeEABKey       = flag.String("acme-eab-key", "", "External Account Binding HMAC key in base64url, paired with --acme-eab-kid")
+	acmeCARoots      = flag.String("acme-ca-roots", "", "path to a PEM bundle of additional root certificates to trust when talking to a private ACME CA")
+	configPath       = flag.String("c", "", "config file path")
+	certMode         = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: letsencrypt, manual, selfsigned")
+	certDir          = flag.String("certdir", tsweb.DefaultCertDir("derper-certs"), "directory to store LetsEncrypt certs, if addr's port is :443")
+	stateDir         = flag.String("state-dir", "", "base directory for server state (config, certs); defaults to ~/.mirage. Set this in containers whose home directory is read-only")
+	certCacheDir     = flag.String("cert-cache-dir", "", "directory for the certmagic cert cache in letsencrypt mode; defaults to <state-dir>/certs")
+	noiseMgmtAddr    = flag.String("noise-mgmt-addr", "", "optional listen address for the /ts2021 noise management endpoint, as \"ip:port\" or \"unix:/path/to/socket\"; when set, the endpoint is removed from the public mux and served only there, so firewalls can restrict the management plane")
+	probeSNIAllow    = flag.String("probe-sni-allow", "", "comma-separated SNI names additionally accepted during the TLS handshake for monitoring probes (use \"none\" to accept connections without SNI, e.g. probes by IP); such connections may only access probe endpoints")
+	hostname         = flag.String("hostname", "derp.tailscale.com", "LetsEncrypt host name, if addr's port is :443. May be a comma-separated list of names to serve (each gets a cert, selected by SNI); the first is the primary name used for redirects and reports")
+	runSTUN          = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
+	stunAllowPrivate = flag.Bool("stun-allow-private", false, "respond to STUN queries from private (RFC 1918) and other bogon source addresses; normally they are ignored as scanner noise")
+	runDERP          = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
 
 	meshPSKFile    = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It should contain some hex string; whitespace is trimmed.")
 	meshWith       = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list")
@@ -73,6 +92,17 @@ var (
 	unpublishedDNS = flag.String("unpublished-bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns and not publish in the list")
 	verifyClients  = flag.Bool("verify-clients", false, "verify clients to this DERP server through a local tailscaled instance.")
 
+	presenceInterval = flag.Duration("presence-interval", 0, "if non-zero, how often to report the set of connected client keys to the controller for presence display; only used in managed mode")
+	presenceSalt     = flag.String("presence-salt", "", "optional salt for presence reports; when set, client keys are reported as salted SHA-256 hashes instead of raw keys")
+	maxFrameSize     = flag.Int("max-frame-size", 0, "if non-zero, maximum DERP packet payload size in bytes (clamped to 64KB..256KB); a limit above the standard 64KB is advertised to capable clients as jumbo frames")
+	strictFrames     = flag.Bool("strict-frames", false, "disconnect clients that send malformed or out-of-spec DERP frames instead of tolerating them; useful for flushing out buggy client implementations")
+	maxConnAge       = flag.Duration("max-conn-age", 0, "optional maximum client connection lifetime; older connections get a graceful reconnect hint so load rebalances after scale-out (0 means unlimited)")
+	priorityKeys     = flag.String("priority-keys", "", "optional comma-separated node keys (nodekey:hex) whose traffic is control-plane coordination and gets queued ahead of bulk data")
+	clientPingEvery  = flag.Duration("client-ping-interval", 0, "optional interval for pinging connected clients to measure application-level RTT (0 means disabled)")
+	drainTimeout     = flag.Duration("drain-timeout", 30*time.Second, "how long to wait on SIGTERM for connected clients to reconnect elsewhere before exiting (0 exits immediately)")
+	metricsToken     = flag.String("metrics-token", "", "optional bearer token required to fetch /metrics; empty serves the endpoint without authentication")
+	plaintextAddr    = flag.String("plaintext-addr", "", "optional address (e.g. 127.0.0.1:3341) to serve the DERP handler over plain HTTP, for deployments that terminate TLS at a trusted load balancer; empty disables")
+
 	acceptConnLimit = flag.Float64("accept-connection-limit", math.Inf(+1), "rate limit for accepting new connection")
 	acceptConnBurst = flag.Int("accept-connection-burst", math.MaxInt, "burst limit for accepting new connection")
 )
@@ -83,22 +113,89 @@ var (
 	stunAddrFamily    = &metrics.LabelMap{Label: "family"}
 	tlsRequestVersion = &metrics.LabelMap{Label: "version"}
 	tlsActiveVersion  = &metrics.LabelMap{Label: "version"}
+	tlsHandshakeError = &metrics.LabelMap{Label: "cause"}
 
 	stunReadError  = stunDisposition.Get("read_error")
 	stunNotSTUN    = stunDisposition.Get("not_stun")
+	stunBogon      = stunDisposition.Get("dropped_bogon")
 	stunWriteError = stunDisposition.Get("write_error")
 	stunSuccess    = stunDisposition.Get("success")
 
 	stunIPv4 = stunAddrFamily.Get("ipv4")
 	stunIPv6 = stunAddrFamily.Get("ipv6")
+
+	stunPortPackets = &metrics.LabelMap{Label: "port"}
+
+	stunResponseCache = &metrics.LabelMap{Label: "outcome"}
+	stunCacheHit      = stunResponseCache.Get("hit")
+	stunCacheMiss     = stunResponseCache.Get("miss")
 )
 
 func init() {
 	stats.Set("counter_requests", stunDisposition)
 	stats.Set("counter_addrfamily", stunAddrFamily)
+	stats.Set("counter_port_requests", stunPortPackets)
+	stats.Set("counter_response_cache", stunResponseCache)
 	expvar.Publish("stun", stats)
 	expvar.Publish("derper_tls_request_version", tlsRequestVersion)
 	expvar.Publish("gauge_derper_tls_active_version", tlsActiveVersion)
+	expvar.Publish("derper_tls_handshake_errors", tlsHandshakeError)
+}
+
+// statePath returns the base directory for server state: --state-dir if
+// set, otherwise ~/.mirage.
+func statePath() string {
+	if *stateDir != "" {
+		return *stateDir
+	}
+	home := homedir.HomeDir()
+	if home == "" {
+		// Non-login system users have no home directory to default
+		// into; make the operator pick a location instead of
+		// scattering state into "/.mirage".
+		log.Fatalf("derper: no home directory; set --state-dir (e.g. /var/lib/mirage-navi) when running as a system user")
+	}
+	return filepath.Join(home, ".mirage")
+}
+
+// flagWasSet reports whether the named flag was given explicitly on
+// the command line.
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// certCachePath returns the certmagic storage directory:
+// --cert-cache-dir if set, then an explicitly given --certdir (so
+// letsencrypt mode honors it like manual mode does), otherwise
+// <state-dir>/certs.
+func certCachePath() string {
+	if *certCacheDir != "" {
+		return *certCacheDir
+	}
+	if flagWasSet("certdir") {
+		return *certDir
+	}
+	return filepath.Join(statePath(), "certs")
+}
+
+// resolveConfigPath fills in the default *configPath if none was given
+// on the command line.
+func resolveConfigPath() {
+	if *configPath != "" {
+		return
+	}
+	if *derpID == "" {
+		*configPath = filepath.Join(statePath(), "navi.store")
+	} else {
+		*configPath = filepath.Join(statePath(), "navi-"+*derpID+".store")
+	}
+	log.Printf("no config path specified; using %s", *configPath)
 }
 
 type config struct {
@@ -112,15 +209,7 @@ func loadConfig() config {
 	if *dev {
 		return config{PrivateKey: key.NewNode()}
 	}
-	if *configPath == "" {
-		dir := homedir.HomeDir()
-		if *derpID == "" {
-			*configPath = filepath.Join(dir, ".mirage", "navi.store")
-		} else {
-			*configPath = filepath.Join(dir, ".mirage", "navi-"+*derpID+".store")
-		}
-		log.Printf("no config path specified; using %s", *configPath)
-	}
+	resolveConfigPath()
 	b, err := os.ReadFile(*configPath)
 	switch {
 	case errors.Is(err, os.ErrNotExist):
@@ -160,7 +249,40 @@ func writeNewConfig(ctrlURL, derpID string) config {
 }
 
 func main() {
-	flag.Parse()
+	root := newRootCmd()
+	if err := root.ParseAndRun(context.Background(), os.Args[1:]); err != nil {
+		log.Fatalf("derper: %v", err)
+	}
+}
+
+// runServe is the main serve loop, shared by the bare invocation and
+// the explicit "serve" subcommand. Flags are already parsed.
+func runServe() {
+	if *insecureDevHTTP && !*dev {
+		log.Fatalf("derper: --insecure-dev-http requires --dev")
+	}
+	setupLogging()
+	configureFDLimit()
+
+	if *selfTestFlag {
+		os.Exit(runSelfTest())
+	}
+	if *exportIdentity != "" {
+		os.Exit(runExportIdentity(*exportIdentity))
+	}
+	if *importIdentity != "" {
+		os.Exit(runImportIdentity(*importIdentity))
+	}
+
+	if st, err := openStore(*storeSpec); err != nil {
+		log.Fatalf("derper: %v", err)
+	} else {
+		recordStore = st
+		defer recordStore.Close()
+	}
+	if err := writeAuditRecord("derper-start", map[string]string{"derpID": *derpID}); err != nil {
+		log.Printf("derper: store: %v", err)
+	}
 
 	for {
 
@@ -179,8 +301,9 @@ func main() {
 
 		//	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual"
 
-		s := derp.NewServer(cfg.PrivateKey, log.Printf)
+		s := derp.NewServer(cfg.PrivateKey, componentLogf("derp"))
 
+		var probation *naviConfig // non-nil if a pushed config change should be watched
 		if *ctrlURL != "" && *derpID != "" {
 			*verifyClients = true
 			err = s.PrepareManaged(cfg.CtrlURL, cfg.DERPID, cfg.NaviKey)
@@ -191,15 +314,53 @@ func main() {
 			if err != nil {
 				log.Fatal(err) //TODO: cgao6: 遇到获取失败且需要处理的情形
 			}
-			s.UpdateNaviInfo(naviInfo,
-				hostname, addr, setIPv4, setIPv6, dnsProvider, dnsID, dnsKey,
-				stunPort,
-				runDERP, runSTUN,
-			)
+			prev, err := applyManagedConfig(s, naviInfo)
+			if err != nil {
+				log.Printf("derper: controller config rejected: %v; keeping previous config", err)
+			} else {
+				probation = &prev
+			}
+			if *presenceInterval > 0 {
+				s.SetPresenceSalt(*presenceSalt)
+				if _, err := s.Cronjob.AddFunc("@every "+presenceInterval.String(), func() {
+					if err := s.ReportPresence(); err != nil {
+						log.Printf("derper: presence report: %v", err)
+					}
+				}); err != nil {
+					log.Fatalf("derper: presence cron: %v", err)
+				}
+			}
 			s.Cronjob.Start()
 			defer s.Cronjob.Stop()
 		}
 		s.SetVerifyClient(*verifyClients)
+		s.SetStrictConformance(*strictFrames)
+		if *maxConnAge > 0 {
+			s.SetMaxConnectionAge(*maxConnAge)
+		}
+		if *clientPingEvery > 0 {
+			s.SetPingInterval(*clientPingEvery)
+		}
+		s.SetCrashReportFunc(func(r derp.CrashReport) {
+			writeAuditRecord("derp-crash", r)
+		})
+		if err := applyHotSettings(s, false); err != nil {
+			log.Printf("derper: settings file: %v", err)
+		}
+		if *priorityKeys != "" {
+			var keys []key.NodePublic
+			for _, ks := range strings.Split(*priorityKeys, ",") {
+				var k key.NodePublic
+				if err := k.UnmarshalText([]byte(strings.TrimSpace(ks))); err != nil {
+					log.Fatalf("derper: bad --priority-keys entry %q: %v", ks, err)
+				}
+				keys = append(keys, k)
+			}
+			s.SetControlPlaneKeys(keys)
+		}
+		if *maxFrameSize > 0 {
+			s.SetMaxFrameSize(*maxFrameSize)
+		}
 
 		if *meshPSKFile != "" {
 			b, err := os.ReadFile(*meshPSKFile)
@@ -222,20 +383,28 @@ func main() {
 
 		mux := http.NewServeMux()
 
+		var noiseMux *http.ServeMux
 		if *ctrlURL != "" && *derpID != "" { //受管节点开启noise管理端口
-			mux.HandleFunc("/ts2021", s.NoiseUpgradeHandler)
+			if *noiseMgmtAddr != "" {
+				// 管理端口从公网mux剥离，单独监听，方便防火墙只对内网放行
+				noiseMux = http.NewServeMux()
+				noiseMux.HandleFunc("/ts2021", s.NoiseUpgradeHandler)
+			} else {
+				mux.HandleFunc("/ts2021", s.NoiseUpgradeHandler)
+			}
 		}
 
 		if *runDERP {
 			derpHandler := derphttp.Handler(s)
 			derpHandler = addWebSocketSupport(s, derpHandler)
-			mux.Handle("/derp", derpHandler)
+			mux.Handle("/derp", shedOnOverload(derpHandler))
 		} else {
 			mux.Handle("/derp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "derp server disabled", http.StatusNotFound)
 			}))
 		}
 		mux.HandleFunc("/derp/probe", probeHandler)
+		startBackupLoop()
 		go refreshBootstrapDNSLoop()
 		mux.HandleFunc("/bootstrap-dns", handleBootstrapDNS)
 		mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -260,6 +429,14 @@ func main() {
 			io.WriteString(w, "User-agent: *\nDisallow: /\n")
 		}))
 		mux.Handle("/generate_204", http.HandlerFunc(serveNoContent))
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok\n")
+		})
+		// Native Prometheus endpoint, unlike /debug/varz not subject
+		// to the debug-access restrictions, so scrapers outside the
+		// host can reach it (optionally with a bearer token).
+		mux.HandleFunc("/metrics", serveMetrics)
+		registerAdminAPI(mux, s)
 		debug := tsweb.Debugger(mux)
 		debug.KV("TLS hostname", *hostname)
 		debug.KV("Mesh key", s.HasMeshKey())
@@ -272,16 +449,52 @@ func main() {
 			}
 		}))
 		debug.Handle("traffic", "Traffic check", http.HandlerFunc(s.ServeDebugTraffic))
+		debug.Handle("clients", "Connected clients", http.HandlerFunc(s.ServeDebugClients))
+		debug.Handle("presence", "Presence check (?key=nodekey)", http.HandlerFunc(s.ServeDebugPresence))
+		debug.Handle("history", "Gauge history (24h)", http.HandlerFunc(historyHandler))
+		debug.Handle("navi.json", "Combined config and state (JSON)", naviDebugJSON(s))
+		startHistorySampler()
 
 		if *runSTUN {
-			go serveSTUN(listenHost, *stunPort)
+			stunHost := listenHost
+			ports, err := parseSTUNPorts(*stunPorts)
+			if err != nil {
+				log.Fatalf("derper: %v", err)
+			}
+			if *stunAddr != "" {
+				host, portStr, err := net.SplitHostPort(*stunAddr)
+				if err != nil {
+					log.Fatalf("invalid --stun-addr: %v", err)
+				}
+				stunHost = host
+				if portStr != "0" {
+					if ports, err = parseSTUNPorts(portStr); err != nil {
+						log.Fatalf("invalid --stun-addr: %v", err)
+					}
+				}
+			}
+			for _, port := range ports {
+				go serveSTUN(stunHost, port)
+			}
+			servedSTUNHost, servedSTUNPorts = stunHost, ports
 			*runSTUN = false
 		}
 
+		if *httpAddr != "" {
+			servedPlainHTTPAddr = *httpAddr
+			*httpAddr = "" // don't rebind if the serve loop restarts
+			*httpPort = -1
+			go servePlainHTTP(servedPlainHTTPAddr)
+		} else if *httpPort >= 0 {
+			servedPlainHTTPAddr = net.JoinHostPort(listenHost, fmt.Sprint(*httpPort))
+			*httpPort = -1
+			go servePlainHTTP(servedPlainHTTPAddr)
+		}
+
 		quietLogger := log.New(logFilter{}, "", 0)
 		httpsrv := &http.Server{
 			Addr:     *addr,
-			Handler:  mux,
+			Handler:  logRequests(mux),
 			ErrorLog: quietLogger,
 
 			// Set read/write timeout. For derper, this basically
@@ -298,30 +511,128 @@ func main() {
 		//cgao6: 从这里开始，我们按照自己的需要实现只能HTTPS访问（支持TLS挑战、DNS挑战、手动证书）
 		//cgao6: 感谢Caddy
 		var tlsConfig *tls.Config
-		var certExpires time.Time
-		switch *certMode {
-		case "letsencrypt": // ALPN challenge
-			certmagic.Default.Storage = &certmagic.FileStorage{Path: filepath.Join(homedir.HomeDir(), ".mirage", "certs")}
+		// certExpiry reports the earliest live certificate expiry,
+		// re-read on every call so it tracks in-place renewals. Zero
+		// when no ACME certificate is in use.
+		certExpiry := func() time.Time { return time.Time{} }
+		switch {
+		case *insecureDevHTTP: // 开发模式明文HTTP，跳过证书获取
+		case *certMode == "letsencrypt": // ALPN challenge
+			// Wildcard names can only be validated via DNS-01.
+			for _, name := range hostnames() {
+				if strings.HasPrefix(name, "*.") && *dnsProvider == "" {
+					log.Fatalf("wildcard hostname %q requires --dns-provider for the DNS-01 challenge", name)
+				}
+			}
+			certStorage, certStorageRSA, err := openCertStorage()
+			if err != nil {
+				log.Fatalf("derper: %v", err)
+			}
+			certmagic.Default.Storage = certStorage
+			// The cache's background maintenance renews certificates
+			// in place using the config this callback returns, and
+			// the live GetCertificate picks the renewed cert up on
+			// the next handshake — no listener restart involved. It
+			// must hand back the fully configured *Config (issuer,
+			// challenge ports, storage), not a blank one, or renewal
+			// runs with none of our ACME settings.
+			var magic, magicRSA *certmagic.Config
 			cache := certmagic.NewCache(certmagic.CacheOptions{
 				GetConfigForCert: func(cert certmagic.Certificate) (*certmagic.Config, error) {
-					return &certmagic.Config{}, nil
+					if _, ok := cert.Leaf.PublicKey.(*rsa.PublicKey); ok {
+						return magicRSA, nil
+					}
+					return magic, nil
 				},
 			})
-			magic := certmagic.New(cache, certmagic.Config{})
-			myACME := certmagic.NewACMEIssuer(magic, certmagic.ACMEIssuer{
-				CA:                   certmagic.LetsEncryptProductionCA, // certmagic.LetsEncryptStagingCA,
+			magic = certmagic.New(cache, certmagic.Config{})
+			// cgao6: 另外申请一张RSA证书，供不支持ECDSA的老旧嵌入式客户端使用，
+			// 两张证书共用缓存，握手时按客户端能力自动选择
+			magicRSA = certmagic.New(cache, certmagic.Config{
+				KeySource: certmagic.StandardKeyGenerator{KeyType: certmagic.RSA2048},
+				Storage:   certStorageRSA,
+			})
+			if *acmeHTTP01 {
+				if *dnsProvider != "" {
+					log.Fatalf("--acme-http01 and --dns-provider are mutually exclusive; pick one challenge type")
+				}
+				if servedPlainHTTPAddr == "" {
+					log.Fatalf("--acme-http01 requires the plain HTTP listener (--http-port or --http-addr)")
+				}
+			}
+			caURL := certmagic.LetsEncryptProductionCA // certmagic.LetsEncryptStagingCA
+			switch *acmeCA {
+			case "", "letsencrypt":
+			case "zerossl":
+				caURL = certmagic.ZeroSSLProductionCA
+				if *acmeEABKID == "" || *acmeEABKey == "" {
+					log.Fatalf("--acme-ca=zerossl requires --acme-eab-kid and --acme-eab-key (generated in the ZeroSSL dashboard)")
+				}
+			default:
+				// A private ACME CA (step-ca and the like), given as
+				// its directory URL.
+				if !strings.Contains(*acmeCA, "://") {
+					log.Fatalf("--acme-ca must be \"letsencrypt\", \"zerossl\", or an ACME directory URL, not %q", *acmeCA)
+				}
+				caURL = *acmeCA
+			}
+			acmeTemplate := certmagic.ACMEIssuer{
+				CA:                   caURL,
 				Email:                "gps949@outlook.com",
 				Agreed:               true,
-				DisableHTTPChallenge: true,
-			})
-			if *dnsProvider == "" {
+				DisableHTTPChallenge: !*acmeHTTP01,
+			}
+			if *acmeEABKID != "" || *acmeEABKey != "" {
+				if *acmeEABKID == "" || *acmeEABKey == "" {
+					log.Fatalf("--acme-eab-kid and --acme-eab-key must be set together")
+				}
+				acmeTemplate.ExternalAccount = &acme.EAB{
+					KeyID:  *acmeEABKID,
+					MACKey: *acmeEABKey,
+				}
+			}
+			if *acmeCARoots != "" {
+				pem, err := os.ReadFile(*acmeCARoots)
+				if err != nil {
+					log.Fatalf("reading --acme-ca-roots: %v", err)
+				}
+				// Extend the system roots rather than replacing them,
+				// so redirects to public endpoints keep verifying.
+				roots, err := x509.SystemCertPool()
+				if err != nil {
+					roots = x509.NewCertPool()
+				}
+				if !roots.AppendCertsFromPEM(pem) {
+					log.Fatalf("--acme-ca-roots %s: no certificates found", *acmeCARoots)
+				}
+				acmeTemplate.TrustedRoots = roots
+			}
+			myACME := certmagic.NewACMEIssuer(magic, acmeTemplate)
+			acmeHTTPChallengeHandler = myACME.HandleHTTPChallenge
+			myACMERSA := certmagic.NewACMEIssuer(magicRSA, acmeTemplate)
+			if *acmeHTTP01 {
+				// The plain HTTP listener answers the challenges via
+				// HandleHTTPChallenge; pointing the issuer's own
+				// solver at the same port makes its bind attempt a
+				// no-op instead of fighting over port 80.
+				myACME.DisableTLSALPNChallenge = true
+				myACMERSA.DisableTLSALPNChallenge = true
+				if _, portStr, err := net.SplitHostPort(servedPlainHTTPAddr); err == nil {
+					if port, err := strconv.Atoi(portStr); err == nil {
+						myACME.AltHTTPPort = port
+						myACMERSA.AltHTTPPort = port
+					}
+				}
+			} else if *dnsProvider == "" {
 				alpnPort, err := strconv.Atoi(strings.TrimPrefix(*addr, ":"))
 				if err != nil {
 					log.Fatal("Can't convert port to int")
 				}
 				myACME.AltTLSALPNPort = alpnPort
+				myACMERSA.AltTLSALPNPort = alpnPort
 			} else {
 				myACME.DisableTLSALPNChallenge = true
+				myACMERSA.DisableTLSALPNChallenge = true
 				var provider certmagic.ACMEDNSProvider
 				switch *dnsProvider {
 				case "cloudflare":
@@ -343,114 +654,315 @@ func main() {
 						APIToken: *dnsKey,
 					}
 				}
-				zone, err := findZoneByFQDN(*hostname, recursiveNameservers([]string{}))
-				if err != nil {
-					log.Fatal("Can't find zone for hostname")
-				}
-				if *setIPv4 != "" {
-					provider.AppendRecords(context.TODO(), zone, []libdns.Record{{
-						Type:  "A",
-						Name:  strings.TrimSuffix(*hostname, "."+strings.TrimSuffix(zone, ".")),
-						Value: *setIPv4,
-					}})
-				}
-				if *setIPv6 != "" {
-					provider.AppendRecords(context.TODO(), zone, []libdns.Record{{
-						Type:  "AAAA",
-						Name:  strings.TrimSuffix(*hostname, "."+strings.TrimSuffix(zone, ".")),
-						Value: *setIPv6,
-					}})
+				var primaryZone string
+				for _, name := range hostnames() {
+					// Zone discovery works on the base name; for a
+					// wildcard that's also where the DNS-01 TXT
+					// records go. A/AAAA publishing doesn't apply to
+					// wildcard entries.
+					isWildcard := strings.HasPrefix(name, "*.")
+					name = strings.TrimPrefix(name, "*.")
+					zone, err := findZoneByFQDN(name, recursiveNameservers([]string{}))
+					if err != nil {
+						log.Fatalf("Can't find zone for hostname %q", name)
+					}
+					if primaryZone == "" {
+						primaryZone = zone
+					}
+					if isWildcard {
+						continue
+					}
+					if *setIPv4 != "" {
+						provider.AppendRecords(context.TODO(), zone, []libdns.Record{{
+							Type:  "A",
+							Name:  strings.TrimSuffix(name, "."+strings.TrimSuffix(zone, ".")),
+							Value: *setIPv4,
+						}})
+					}
+					if *setIPv6 != "" {
+						provider.AppendRecords(context.TODO(), zone, []libdns.Record{{
+							Type:  "AAAA",
+							Name:  strings.TrimSuffix(name, "."+strings.TrimSuffix(zone, ".")),
+							Value: *setIPv6,
+						}})
+					}
 				}
 				myACME.DNS01Solver = &certmagic.DNS01Solver{
 					DNSProvider: provider,
 				}
+				myACMERSA.DNS01Solver = myACME.DNS01Solver
+				startDNSCredentialCheck(s, provider, primaryZone)
 			}
-			if *dnsProvider == "" && myACME.AltTLSALPNPort != 443 {
-				cmd := exec.Command("sudo", "iptables", "-t", "nat", "-A", "PREROUTING", "-p", "tcp", "--dport", "443", "-j", "REDIRECT", "--to-ports", fmt.Sprint(myACME.AltTLSALPNPort))
-				err = cmd.Run()
-				if err != nil {
-					log.Fatal("Can't add iptables rule")
+			if *dnsProvider == "" && !*acmeHTTP01 && myACME.AltTLSALPNPort != 443 {
+				// The CA dials 443 no matter where the solver
+				// listens; answer there with our own acme-tls/1
+				// proxy instead of a sudo iptables REDIRECT.
+				if err := startALPNChallengeProxy(myACME.AltTLSALPNPort); err != nil {
+					log.Fatalf("derper: can't bind :443 for the TLS-ALPN-01 challenge: %v (run the relay on 443, grant it CAP_NET_BIND_SERVICE, or switch to --acme-http01 or --dns-provider)", err)
 				}
 			}
 			magic.Issuers = []certmagic.Issuer{myACME}
-			err = magic.ManageSync(context.TODO(), []string{*hostname})
-			if *dnsProvider == "" && myACME.AltTLSALPNPort != 443 {
-				cmd := exec.Command("sudo", "iptables", "-t", "nat", "-D", "PREROUTING", "-p", "tcp", "--dport", "443", "-j", "REDIRECT", "--to-ports", fmt.Sprint(myACME.AltTLSALPNPort))
-				err = cmd.Run()
-				if err != nil {
-					log.Fatal("Can't add iptables rule")
-				}
+			magicRSA.Issuers = []certmagic.Issuer{myACMERSA}
+			err = magic.ManageSync(context.TODO(), hostnames())
+			if err == nil {
+				err = magicRSA.ManageSync(context.TODO(), hostnames())
 			}
 			if err != nil {
 				log.Fatal("Can't handle with the cert managesync")
 			}
 			tlsConfig = magic.TLSConfig()
-			certExpires = cache.AllMatchingCertificates(*hostname)[0].Leaf.NotAfter
-		case "manual": // Manual certificate
+			// With several names, report the earliest expiry: that's
+			// the renewal that matters.
+			certExpiry = func() time.Time {
+				var expires time.Time
+				for _, name := range hostnames() {
+					for _, c := range cache.AllMatchingCertificates(name) {
+						if expires.IsZero() || c.Leaf.NotAfter.Before(expires) {
+							expires = c.Leaf.NotAfter
+						}
+					}
+				}
+				return expires
+			}
+		case *certMode == "manual", *certMode == "selfsigned": // Local certificate
 			var certManager certProvider
-			certManager, err = certProviderByCertMode(*certMode, *certDir, *hostname)
+			certManager, err = certProviderByCertMode(*certMode, *certDir, hostnames())
 			if err != nil {
 				log.Fatalf("derper: can not start cert provider: %v", err)
 			}
 			tlsConfig = certManager.TLSConfig()
 		}
-		httpsrv.TLSConfig = tlsConfig
-		getCert := httpsrv.TLSConfig.GetCertificate
-		httpsrv.TLSConfig.GetCertificate = func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
-			cert, err := getCert(hi)
-			if err != nil {
-				return nil, err
-			}
-			cert.Certificate = append(cert.Certificate, s.MetaCert())
-			return cert, nil
-		}
-		httpsrv.TLSConfig.MinVersion = tls.VersionTLS12
-		httpsrv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.TLS != nil {
-				label := "unknown"
-				switch r.TLS.Version {
-				case tls.VersionTLS10:
-					label = "1.0"
-				case tls.VersionTLS11:
-					label = "1.1"
-				case tls.VersionTLS12:
-					label = "1.2"
-				case tls.VersionTLS13:
-					label = "1.3"
+		debugInfoReport = naviInfoReport(certExpiry)
+		s.SetInfoReportFunc(debugInfoReport)
+
+		if *quicAddr != "" {
+			if tlsConfig == nil {
+				log.Printf("derper: --quic-addr ignored: QUIC requires a TLS certificate configuration")
+			} else if *runDERP {
+				go serveDERPQUIC(s, tlsConfig)
+			}
+		}
+
+		if *insecureDevHTTP {
+			log.Printf("derper: --insecure-dev-http: serving plaintext HTTP; do not use outside local development")
+		} else {
+			httpsrv.TLSConfig = tlsConfig
+			getCert := httpsrv.TLSConfig.GetCertificate
+			httpsrv.TLSConfig.GetCertificate = func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				// TLS-ALPN-01 (acme-tls/1) challenge handshakes must
+				// get certmagic's challenge certificate untouched:
+				// appending the DERP meta cert would break challenge
+				// validation. Answering these on the main listener
+				// lets renewals complete without the alternate port
+				// or the iptables redirect used at first issuance.
+				for _, proto := range hi.SupportedProtos {
+					if proto == "acme-tls/1" {
+						return getCert(hi)
+					}
+				}
+				cert, err := getCert(hi)
+				if err != nil && probeSNIAllowed(hi.ServerName) {
+					// Monitoring probes by IP carry no (or a probe)
+					// SNI; serve the default cert and let the HTTP
+					// layer restrict them to probe endpoints.
+					hi2 := *hi
+					hi2.ServerName = primaryHostname()
+					cert, err = getCert(&hi2)
 				}
-				tlsRequestVersion.Add(label, 1)
-				tlsActiveVersion.Add(label, 1)
-				defer tlsActiveVersion.Add(label, -1)
+				if err != nil {
+					return nil, err
+				}
+				cert.Certificate = append(cert.Certificate, s.MetaCert())
+				return cert, nil
 			}
-			w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
-			w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'; form-action 'none'; base-uri 'self'; block-all-mixed-content; plugin-types 'none'")
-			mux.ServeHTTP(w, r)
-		})
+			if err := applyTLSPolicy(httpsrv.TLSConfig); err != nil {
+				log.Fatalf("derper: %v", err)
+			}
+			httpsrv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.TLS != nil && !hostnameAllowed(r.TLS.ServerName) && !probePathOK(r.URL.Path) {
+					// Connections admitted via --probe-sni-allow are
+					// restricted to probe endpoints; /derp stays strict.
+					http.Error(w, "misdirected request", http.StatusMisdirectedRequest)
+					return
+				}
+				if r.TLS != nil {
+					label := "unknown"
+					switch r.TLS.Version {
+					case tls.VersionTLS10:
+						label = "1.0"
+					case tls.VersionTLS11:
+						label = "1.1"
+					case tls.VersionTLS12:
+						label = "1.2"
+					case tls.VersionTLS13:
+						label = "1.3"
+					}
+					tlsRequestVersion.Add(label, 1)
+					tlsActiveVersion.Add(label, 1)
+					defer tlsActiveVersion.Add(label, -1)
+					noteTLSPolicyImpact(r.TLS)
+				}
+				w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+				w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'; form-action 'none'; base-uri 'self'; block-all-mixed-content; plugin-types 'none'")
+				mux.ServeHTTP(w, r)
+			})
+		}
 
 		errorGroup := new(errgroup.Group)
 
-		errorGroup.Go(func() error { return rateLimitedListenAndServeTLS(httpsrv) })
+		errorGroup.Go(func() error {
+			if *insecureDevHTTP {
+				return rateLimitedListenAndServe(httpsrv)
+			}
+			return rateLimitedListenAndServeTLS(httpsrv)
+		})
+
+		var noiseSrv *http.Server
+		if noiseMux != nil {
+			noiseLn, err := listenNoiseMgmt(*noiseMgmtAddr)
+			if err != nil {
+				log.Fatalf("derper: noise management listener: %v", err)
+			}
+			noiseSrv = &http.Server{
+				Handler:      noiseMux,
+				ErrorLog:     quietLogger,
+				ReadTimeout:  30 * time.Second,
+				WriteTimeout: 30 * time.Second,
+			}
+			log.Printf("derper: serving noise management endpoint on %v", noiseLn.Addr())
+			errorGroup.Go(func() error {
+				err := noiseSrv.Serve(noiseLn)
+				if err == http.ErrServerClosed {
+					return nil
+				}
+				return err
+			})
+		}
+
+		var plainSrv *http.Server
+		if *plaintextAddr != "" {
+			// TLS terminates at the load balancer, so this listener
+			// serves mux directly: no TLS version metrics and no
+			// HSTS/CSP injection (the LB owns the browser-facing
+			// headers). The DERP handler's hijacked connections work
+			// as usual over HTTP/1.
+			plainSrv = &http.Server{
+				Addr:         *plaintextAddr,
+				Handler:      logRequests(mux),
+				ErrorLog:     quietLogger,
+				ReadTimeout:  30 * time.Second,
+				WriteTimeout: 30 * time.Second,
+			}
+			plainLn, err := listenTCPHandoff("plaintext:"+*plaintextAddr, *plaintextAddr)
+			if err != nil {
+				log.Fatalf("derper: plaintext listener: %v", err)
+			}
+			log.Printf("derper: serving plaintext DERP on %v (TLS terminated upstream)", *plaintextAddr)
+			errorGroup.Go(func() error {
+				err := plainSrv.Serve(maybeProxyListener(plainLn))
+				if err == http.ErrServerClosed {
+					return nil
+				}
+				return err
+			})
+		}
 
 		shutdownChan := make(chan struct{})
 		sigc := make(chan os.Signal, 1)
 		signal.Notify(sigc,
 			syscall.SIGINT,
 			syscall.SIGTERM,
-			syscall.SIGQUIT)
+			syscall.SIGQUIT,
+			syscall.SIGHUP,
+			syscall.SIGUSR1)
 		sigFunc := func(c chan os.Signal) {
 			// Wait for a SIGINT or SIGKILL:
 			for {
 				sig := <-c
 				switch sig {
+				case syscall.SIGHUP:
+					// Hot reload: re-apply the settings file in
+					// place without dropping live DERP sessions.
+					if err := applyHotSettings(s, true); err != nil {
+						log.Printf("derper: settings reload: %v", err)
+					}
+				case syscall.SIGUSR1:
+					// Hitless binary restart: hand the listening
+					// sockets to a fresh copy of the binary, then
+					// drain established DERP sessions toward it.
+					if err := spawnSuccessor(); err != nil {
+						log.Printf("derper: handoff failed, still serving: %v", err)
+						continue
+					}
+					close(shutdownChan)
+					if *drainTimeout > 0 {
+						drainCtx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+						go httpsrv.Shutdown(drainCtx)
+						if noiseSrv != nil {
+							go noiseSrv.Shutdown(drainCtx)
+						}
+						if plainSrv != nil {
+							go plainSrv.Shutdown(drainCtx)
+						}
+						log.Printf("derper: handoff: draining clients for up to %v", *drainTimeout)
+						if err := s.Drain(drainCtx); err != nil {
+							log.Printf("derper: %v", err)
+						} else {
+							log.Printf("derper: all clients drained")
+						}
+						cancel()
+					}
+					httpsrv.Close()
+					if noiseSrv != nil {
+						noiseSrv.Close()
+					}
+					if plainSrv != nil {
+						plainSrv.Close()
+					}
+					os.Exit(0)
 				case syscall.SIGUSR2:
 					log.Printf("derper: got signal %v; go restart", sig)
 					close(shutdownChan)
 					httpsrv.Close()
+					if noiseSrv != nil {
+						noiseSrv.Close()
+					}
+					if plainSrv != nil {
+						plainSrv.Close()
+					}
 					return
 				default:
 					log.Printf("derper: got signal %v; shutting down", sig)
 					close(shutdownChan)
+					if *drainTimeout > 0 {
+						// Stop accepting new connections (Shutdown
+						// closes the listeners right away; hijacked
+						// DERP conns aren't tracked by it), then ask
+						// connected clients to reconnect elsewhere
+						// and give them until the deadline to go.
+						drainCtx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+						go httpsrv.Shutdown(drainCtx)
+						if noiseSrv != nil {
+							go noiseSrv.Shutdown(drainCtx)
+						}
+						if plainSrv != nil {
+							go plainSrv.Shutdown(drainCtx)
+						}
+						log.Printf("derper: draining clients for up to %v", *drainTimeout)
+						if err := s.Drain(drainCtx); err != nil {
+							log.Printf("derper: %v", err)
+						} else {
+							log.Printf("derper: all clients drained")
+						}
+						cancel()
+					}
 					httpsrv.Close()
+					if noiseSrv != nil {
+						noiseSrv.Close()
+					}
+					if plainSrv != nil {
+						plainSrv.Close()
+					}
 					os.Exit(0)
 				}
 			}
@@ -460,19 +972,33 @@ func main() {
 			return nil
 		})
 
-		if *certMode == "letsencrypt" {
-			ticker := time.NewTicker(time.Hour * 6)
-			defer ticker.Stop()
+		if probation != nil {
+			go watchConfigProbation(*probation, sigc)
+		}
+
+		go runWatchdog(shutdownChan)
+		go runLoadShedMonitor(shutdownChan)
+
+		if *certMode == "letsencrypt" && !*insecureDevHTTP {
+			// Renewal is handled in place: the certmagic cache's
+			// maintenance goroutine renews ahead of expiry and the
+			// live GetCertificate serves the new cert on the next
+			// handshake, without restarting anything or dropping
+			// DERP sessions. This watch only alerts if that
+			// machinery is stuck.
 			errorGroup.Go(func() error {
+				ticker := time.NewTicker(time.Hour * 6)
 				defer ticker.Stop()
-				for range ticker.C {
-					if certExpires.Sub(time.Now()) < time.Hour*24*7 {
-						log.Printf("derper: renewing certificate")
-						sigc <- syscall.SIGUSR2
+				for {
+					select {
+					case <-shutdownChan:
 						return nil
+					case <-ticker.C:
+						if e := certExpiry(); !e.IsZero() && time.Until(e) < time.Hour*24*7 {
+							log.Printf("derper: certificate expires %v and automatic renewal has not replaced it; check ACME reachability", e.Round(time.Second))
+						}
 					}
 				}
-				return nil
 			})
 		}
 
@@ -508,19 +1034,178 @@ func isChallengeChar(c rune) bool {
 		c == '.' || c == '-' || c == '_'
 }
 
+// listenNoiseMgmt listens on the --noise-mgmt-addr address, either a TCP
+// "ip:port" or "unix:/path" for a Unix socket.
+func listenNoiseMgmt(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// 清理上次退出遗留的socket文件，否则bind会失败
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// probeSNIAllowed reports whether a TLS ClientHello with the given SNI
+// (empty if absent) is acceptable per --probe-sni-allow even though it
+// doesn't match --hostname.
+func probeSNIAllowed(sni string) bool {
+	if *probeSNIAllow == "" {
+		return false
+	}
+	for _, name := range strings.Split(*probeSNIAllow, ",") {
+		name = strings.TrimSpace(name)
+		if name == "none" && sni == "" {
+			return true
+		}
+		if name != "" && name == sni {
+			return true
+		}
+	}
+	return false
+}
+
+// hostnames returns the comma-separated --hostname list, trimmed of
+// whitespace and empty entries.
+func hostnames() []string {
+	var names []string
+	for _, h := range strings.Split(*hostname, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			names = append(names, h)
+		}
+	}
+	return names
+}
+
+// primaryHostname is the first --hostname entry, used where a single
+// name is needed: HTTPS redirects, probe SNI fallback and reports.
+func primaryHostname() string {
+	if names := hostnames(); len(names) > 0 {
+		return names[0]
+	}
+	return *hostname
+}
+
+// hostnameAllowed reports whether sni is covered by one of the
+// --hostname names, including wildcard entries like *.derp.example.com.
+func hostnameAllowed(sni string) bool {
+	for _, h := range hostnames() {
+		if hostnameMatches(h, sni) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostnameMatches reports whether sni matches pattern: either exactly,
+// or with pattern's leading "*." label standing for exactly one label,
+// per the usual certificate wildcard rules.
+func hostnameMatches(pattern, sni string) bool {
+	if pattern == sni {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(pattern, "*."); ok {
+		if label, domain, ok := strings.Cut(sni, "."); ok && label != "" && domain == rest {
+			return true
+		}
+	}
+	return false
+}
+
+// serveMetrics serves every registered expvar metric — STUN
+// dispositions, TLS versions, listener accept/reject counters and the
+// derp.Server counters — in Prometheus exposition format. With
+// --metrics-token set, requests must carry it as a bearer token.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if *metricsToken != "" {
+		tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(tok), []byte(*metricsToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	promvarz.Handler(w, r)
+}
+
+// probePathOK reports whether path is one of the endpoints monitoring
+// probes are allowed to reach without a matching SNI.
+func probePathOK(path string) bool {
+	switch path {
+	case "/derp/probe", "/healthz", "/generate_204", "/robots.txt":
+		return true
+	}
+	return false
+}
+
 // probeHandler is the endpoint that js/wasm clients hit to measure
-// DERP latency, since they can't do UDP STUN queries.
+// DERP latency, since they can't do UDP STUN queries. With ?addr=1 it
+// additionally reports the observed client source address as JSON, so
+// clients without STUN access can still learn their public address and
+// IP family.
 func probeHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "HEAD", "GET":
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 	default:
 		http.Error(w, "bogus probe method", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method == "GET" && r.FormValue("addr") != "" {
+		ap, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil {
+			http.Error(w, "unparseable remote address", http.StatusInternalServerError)
+			return
+		}
+		family := "ipv4"
+		if ap.Addr().Is6() && !ap.Addr().Is4In6() {
+			family = "ipv6"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Addr   string `json:"addr"`
+			Port   uint16 `json:"port"`
+			Family string `json:"family"`
+		}{
+			Addr:   ap.Addr().Unmap().String(),
+			Port:   ap.Port(),
+			Family: family,
+		})
+	}
+}
+
+// servedSTUNHost and servedSTUNPorts are the bind host and UDP ports
+// STUN listeners were actually started on, for the /ctrl/info
+// inventory report.
+var (
+	servedSTUNHost  string
+	servedSTUNPorts []int
+)
+
+// parseSTUNPorts parses the --stun-port value: a comma-separated list
+// of UDP port numbers.
+func parseSTUNPorts(v string) ([]int, error) {
+	var ports []int
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		port, err := strconv.Atoi(f)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid STUN port %q", f)
+		}
+		ports = append(ports, port)
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no STUN ports in %q", v)
 	}
+	return ports, nil
 }
 
 func serveSTUN(host string, port int) {
-	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, fmt.Sprint(port)))
+	addr := net.JoinHostPort(host, fmt.Sprint(port))
+	pc, err := listenUDPHandoff("stun:"+addr, addr)
 	if err != nil {
 		log.Fatalf("failed to open STUN listener: %v", err)
 	}
@@ -529,15 +1214,20 @@ func serveSTUN(host string, port int) {
 }
 
 func serverSTUNListener(ctx context.Context, pc *net.UDPConn) {
-	var buf [64 << 10]byte
 	var (
 		n   int
 		ua  *net.UDPAddr
 		err error
 	)
+	cache := newSTUNCache()
+	w := newSTUNWriter(pc)
+	defer w.close()
+	portPackets := stunPortPackets.Get(fmt.Sprint(pc.LocalAddr().(*net.UDPAddr).Port))
 	for {
-		n, ua, err = pc.ReadFromUDP(buf[:])
+		bufp := stunBufPool.Get().(*[]byte)
+		n, ua, err = pc.ReadFromUDP(*bufp)
 		if err != nil {
+			stunBufPool.Put(bufp)
 			if ctx.Err() != nil {
 				return
 			}
@@ -546,30 +1236,60 @@ func serverSTUNListener(ctx context.Context, pc *net.UDPConn) {
 			stunReadError.Add(1)
 			continue
 		}
-		pkt := buf[:n]
-		if !stun.Is(pkt) {
-			stunNotSTUN.Add(1)
-			continue
-		}
-		txid, err := stun.ParseBindingRequest(pkt)
-		if err != nil {
-			stunNotSTUN.Add(1)
-			continue
-		}
-		if ua.IP.To4() != nil {
-			stunIPv4.Add(1)
-		} else {
-			stunIPv6.Add(1)
-		}
-		addr, _ := netip.AddrFromSlice(ua.IP)
-		res := stun.Response(txid, netip.AddrPortFrom(addr, uint16(ua.Port)))
-		_, err = pc.WriteTo(res, ua)
-		if err != nil {
-			stunWriteError.Add(1)
-		} else {
-			stunSuccess.Add(1)
-		}
+		portPackets.Add(1)
+		handleSTUNPacket(cache, w, (*bufp)[:n], ua)
+		stunBufPool.Put(bufp)
+	}
+}
+
+// handleSTUNPacket processes one datagram from the STUN listener. pkt is
+// only valid during the call; anything retained (the cached response) is
+// freshly allocated.
+func handleSTUNPacket(cache *stunCache, w *stunWriter, pkt []byte, ua *net.UDPAddr) {
+	if !stun.Is(pkt) {
+		stunNotSTUN.Add(1)
+		return
 	}
+	txid, err := stun.ParseBindingRequest(pkt)
+	if err != nil {
+		stunNotSTUN.Add(1)
+		return
+	}
+	if ua.IP.To4() != nil {
+		stunIPv4.Add(1)
+	} else {
+		stunIPv6.Add(1)
+	}
+	addr, _ := netip.AddrFromSlice(ua.IP)
+	if !*stunAllowPrivate && isBogonSource(addr.Unmap()) {
+		stunBogon.Add(1)
+		return
+	}
+	key := stunCacheKey{txid: txid, ap: netip.AddrPortFrom(addr, uint16(ua.Port))}
+	res := cache.get(key)
+	if res == nil {
+		stunCacheMiss.Add(1)
+		res = stun.Response(txid, key.ap)
+		cache.put(key, res)
+	} else {
+		stunCacheHit.Add(1)
+	}
+	w.send(res, ua)
+}
+
+// isBogonSource reports whether ip is a source address a STUN query could
+// never legitimately arrive from over the public internet: RFC 1918 private
+// space, CGNAT (which is also the tailnet range), loopback, link-local,
+// multicast or unspecified. Broken internal scanners on the relay's local
+// network are the usual senders; see --stun-allow-private for lab setups.
+func isBogonSource(ip netip.Addr) bool {
+	return ip.IsPrivate() ||
+		ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified() ||
+		tsaddr.CGNATRange().Contains(ip)
 }
 
 var validProdHostname = regexp.MustCompile(`^derp([^.]*)\.tailscale\.com\.?$`)
@@ -599,11 +1319,12 @@ func rateLimitedListenAndServeTLS(srv *http.Server) error {
 	if addr == "" {
 		addr = ":https"
 	}
-	ln, err := net.Listen("tcp", addr)
+	ln, err := listenTCPHandoff("https:"+addr, addr)
 	if err != nil {
 		return err
 	}
-	rln := newRateLimitedListener(ln, rate.Limit(*acceptConnLimit), *acceptConnBurst)
+	rln := newRateLimitedListener(maybeProxyListener(ln), rate.Limit(*acceptConnLimit), *acceptConnBurst)
+	activeListener.Store(rln)
 	if expvar.Get("tls_listener") == nil {
 		expvar.Publish("tls_listener", rln.ExpVar())
 	}
@@ -611,6 +1332,26 @@ func rateLimitedListenAndServeTLS(srv *http.Server) error {
 	return srv.ServeTLS(rln, "", "")
 }
 
+// rateLimitedListenAndServe is the plaintext variant of
+// rateLimitedListenAndServeTLS, used only with --insecure-dev-http.
+func rateLimitedListenAndServe(srv *http.Server) error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := listenTCPHandoff("devhttp:"+addr, addr)
+	if err != nil {
+		return err
+	}
+	rln := newRateLimitedListener(maybeProxyListener(ln), rate.Limit(*acceptConnLimit), *acceptConnBurst)
+	activeListener.Store(rln)
+	if expvar.Get("http_listener") == nil {
+		expvar.Publish("http_listener", rln.ExpVar())
+	}
+	defer rln.Close()
+	return srv.Serve(rln)
+}
+
 type rateLimitedListener struct {
 	// These are at the start of the struct to ensure 64-bit alignment
 	// on 32-bit architecture regardless of what other fields may exist
@@ -621,6 +1362,11 @@ type rateLimitedListener struct {
 	net.Listener
 
 	lim *rate.Limiter
+
+	// Watchdog instrumentation: whether the serve loop is currently
+	// parked inside Accept, and when Accept last returned.
+	acceptActive     atomic.Bool
+	lastAcceptReturn atomic.Int64 // unix nanos
 }
 
 func newRateLimitedListener(ln net.Listener, limit rate.Limit, burst int) *rateLimitedListener {
@@ -637,37 +1383,77 @@ func (l *rateLimitedListener) ExpVar() expvar.Var {
 var errLimitedConn = errors.New("cannot accept connection; rate limited")
 
 func (l *rateLimitedListener) Accept() (net.Conn, error) {
+	l.acceptActive.Store(true)
+	defer func() {
+		l.lastAcceptReturn.Store(time.Now().UnixNano())
+		l.acceptActive.Store(false)
+	}()
 	// Even under a rate limited situation, we accept the connection immediately
 	// and close it, rather than being slow at accepting new connections.
 	// This provides two benefits: 1) it signals to the client that something
 	// is going on on the server, and 2) it prevents new connections from
 	// piling up and occupying resources in the OS kernel.
 	// The client will retry as needing (with backoffs in place).
-	cn, err := l.Listener.Accept()
-	if err != nil {
-		return nil, err
-	}
-	if !l.lim.Allow() {
-		l.numRejects.Add(1)
-		cn.Close()
-		return nil, errLimitedConn
+	for {
+		cn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !l.lim.Allow() {
+			l.numRejects.Add(1)
+			cn.Close()
+			return nil, errLimitedConn
+		}
+		release, ok := connQuota.acquire(cn.RemoteAddr())
+		if !ok {
+			// Per-IP quota exceeded. Unlike the rate limiter this is
+			// the fault of one source, so close its connection and
+			// keep accepting from everyone else.
+			cn.Close()
+			continue
+		}
+		l.numAccepts.Add(1)
+		return &quotaConn{Conn: cn, release: release}, nil
 	}
-	l.numAccepts.Add(1)
-	return cn, nil
 }
 
-// logFilter is used to filter out useless error logs that are logged to
-// the net/http.Server.ErrorLog logger.
+// handshakeErrorCauses maps the tail of a net/http TLS handshake error log
+// line to a short cause label for the derper_tls_handshake_errors counter.
+// These lines are noise in the logs (mostly scanners and broken middleboxes),
+// but their distribution is useful to operators, so they are counted per
+// cause instead of being logged.
+var handshakeErrorCauses = []struct {
+	suffix string
+	cause  string
+}{
+	{": EOF\n", "eof"},
+	{": i/o timeout\n", "timeout"},
+	{": read: connection reset by peer\n", "conn_reset"},
+	{": remote error: tls: bad certificate\n", "bad_certificate"},
+	{": remote error: tls: unknown certificate\n", "unknown_certificate"},
+	{": tls: first record does not look like a TLS handshake\n", "not_tls"},
+	{": tls: client offered only unsupported versions\n", "protocol_too_old"},
+	{": tls: no cipher suite supported by both client and server\n", "no_common_cipher"},
+	{"cert mismatch with hostname: \"\"\n", "missing_sni"},
+}
+
+// logFilter is used to classify and count useless error logs that are logged
+// to the net/http.Server.ErrorLog logger instead of printing them.
 type logFilter struct{}
 
 func (logFilter) Write(p []byte) (int, error) {
 	b := mem.B(p)
-	if mem.HasSuffix(b, mem.S(": EOF\n")) ||
-		mem.HasSuffix(b, mem.S(": i/o timeout\n")) ||
-		mem.HasSuffix(b, mem.S(": read: connection reset by peer\n")) ||
-		mem.HasSuffix(b, mem.S(": remote error: tls: bad certificate\n")) ||
-		mem.HasSuffix(b, mem.S(": tls: first record does not look like a TLS handshake\n")) {
-		// Skip this log message, but say that we processed it
+	for _, hc := range handshakeErrorCauses {
+		if mem.HasSuffix(b, mem.S(hc.suffix)) {
+			// Count this log message instead of printing it,
+			// but say that we processed it.
+			tlsHandshakeError.Add(hc.cause, 1)
+			return len(p), nil
+		}
+	}
+	if mem.Contains(b, mem.S("cert mismatch with hostname")) {
+		// SNI for some name this server doesn't have a cert for.
+		tlsHandshakeError.Add("bad_sni", 1)
 		return len(p), nil
 	}
 
@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"expvar"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/libdns"
+	"tailscale.com/derp"
+)
+
+// DNS provider credential health monitor. Certificate renewal via the
+// DNS-01 challenge only exercises the provider credentials every
+// couple of months, so an expired or revoked API token otherwise goes
+// unnoticed until the renewal that needs it fails. This periodically
+// makes a lightweight read call against the zone and surfaces the
+// result as an expvar gauge, an audit record, and (in managed mode) a
+// health report to the controller.
+
+var dnsCheckInterval = flag.Duration("dns-credential-check-interval", 6*time.Hour, "how often to verify the DNS provider credentials with a read call; 0 disables the check")
+
+// dnsCredentialOK is 1 while the credentials work, 0 while they don't,
+// and -1 before the first check.
+var dnsCredentialOK = func() *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(-1)
+	expvar.Publish("derper_dns_credential_ok", v)
+	return v
+}()
+
+// recordGetter is the read side of a libdns provider, used for the
+// credential check. All the supported providers implement it.
+type recordGetter interface {
+	GetRecords(ctx context.Context, zone string) ([]libdns.Record, error)
+}
+
+// startDNSCredentialCheck begins periodically verifying that the
+// configured DNS provider credentials still work by listing the
+// zone's records. It does nothing if the check is disabled or the
+// provider can't list records.
+func startDNSCredentialCheck(s *derp.Server, provider certmagic.ACMEDNSProvider, zone string) {
+	if *dnsCheckInterval <= 0 {
+		return
+	}
+	rg, ok := provider.(recordGetter)
+	if !ok {
+		log.Printf("derper: dns provider %q can not list records; credential check disabled", *dnsProvider)
+		return
+	}
+	go func() {
+		t := time.NewTicker(*dnsCheckInterval)
+		defer t.Stop()
+		for {
+			checkDNSCredentials(s, rg, zone)
+			<-t.C
+		}
+	}()
+}
+
+// checkDNSCredentials runs one credential check and reports
+// transitions between healthy and unhealthy.
+func checkDNSCredentials(s *derp.Server, rg recordGetter, zone string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := rg.GetRecords(ctx, zone)
+	healthy := err == nil
+	prev := dnsCredentialOK.Value()
+	if healthy {
+		dnsCredentialOK.Set(1)
+	} else {
+		dnsCredentialOK.Set(0)
+		log.Printf("derper: dns credential check for zone %s failed: %v", zone, err)
+	}
+	if prev != -1 && (prev == 1) == healthy {
+		return // no transition, nothing to report
+	}
+	detail := ""
+	if err != nil {
+		detail = err.Error()
+	}
+	writeAuditRecord("dns-credential", map[string]any{
+		"zone":    zone,
+		"healthy": healthy,
+		"error":   detail,
+	})
+	if rerr := s.ReportHealth("dns-credential", healthy, detail); rerr != nil {
+		log.Printf("derper: dns credential health report: %v", rerr)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Hitless binary restart. SIGUSR1 spawns a fresh copy of the (possibly
+// upgraded) binary that inherits every listening socket — TLS, plain
+// HTTP, plaintext DERP and STUN — so new connections never see a
+// closed port. The old process stops accepting, asks its established
+// DERP sessions to reconnect (which now land on the successor) and
+// drains for --drain-timeout before exiting, the same way a SIGTERM
+// drain works. The inherited fds are described to the child in an
+// environment variable as name=fd pairs; names are derived from the
+// listener's role and address so a successor started with changed
+// flags simply falls back to a fresh bind for listeners it doesn't
+// recognize.
+
+// inheritFDsEnv names the environment variable carrying the
+// "name=fd,name=fd" mapping of inherited listener descriptors.
+const inheritFDsEnv = "DERPER_INHERIT_FDS"
+
+// filer is implemented by listeners whose descriptor can be dup'ed
+// for handoff (*net.TCPListener, *net.UDPConn).
+type filer interface {
+	File() (*os.File, error)
+}
+
+var handoff struct {
+	mu        sync.Mutex
+	listeners map[string]filer    // live listeners, for handing to a successor
+	inherited map[string]*os.File // fds taken over from the predecessor
+	parsed    bool
+}
+
+// parseInheritedFDs consumes inheritFDsEnv once, turning it into
+// os.Files. The variable is cleared so an eventual successor of this
+// process only sees the mapping we build for it.
+func parseInheritedFDs() {
+	handoff.mu.Lock()
+	defer handoff.mu.Unlock()
+	if handoff.parsed {
+		return
+	}
+	handoff.parsed = true
+	handoff.inherited = map[string]*os.File{}
+	spec := os.Getenv(inheritFDsEnv)
+	os.Unsetenv(inheritFDsEnv)
+	if spec == "" {
+		return
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		name, fdStr, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		fd, err := strconv.Atoi(fdStr)
+		if err != nil || fd < 3 {
+			continue
+		}
+		handoff.inherited[name] = os.NewFile(uintptr(fd), name)
+	}
+	if len(handoff.inherited) > 0 {
+		log.Printf("handoff: inherited %d listener(s) from predecessor", len(handoff.inherited))
+	}
+}
+
+// takeInheritedFD claims the named inherited descriptor, if any.
+func takeInheritedFD(name string) (*os.File, bool) {
+	parseInheritedFDs()
+	handoff.mu.Lock()
+	defer handoff.mu.Unlock()
+	f, ok := handoff.inherited[name]
+	if ok {
+		delete(handoff.inherited, name)
+	}
+	return f, ok
+}
+
+// noteInheritable registers a live listener for handoff to a future
+// successor process.
+func noteInheritable(name string, ln any) {
+	f, ok := ln.(filer)
+	if !ok {
+		return
+	}
+	handoff.mu.Lock()
+	defer handoff.mu.Unlock()
+	if handoff.listeners == nil {
+		handoff.listeners = map[string]filer{}
+	}
+	handoff.listeners[name] = f
+}
+
+// listenTCPHandoff returns a TCP listener for addr, reusing one
+// inherited from a predecessor process when available, and registers
+// the result for handoff to a successor.
+func listenTCPHandoff(name, addr string) (net.Listener, error) {
+	if f, ok := takeInheritedFD(name); ok {
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err == nil {
+			log.Printf("handoff: reusing inherited listener %s", name)
+			noteInheritable(name, ln)
+			return ln, nil
+		}
+		log.Printf("handoff: inherited listener %s unusable, binding fresh: %v", name, err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	noteInheritable(name, ln)
+	return ln, nil
+}
+
+// listenUDPHandoff is the packet-conn variant of listenTCPHandoff.
+func listenUDPHandoff(name, addr string) (net.PacketConn, error) {
+	if f, ok := takeInheritedFD(name); ok {
+		pc, err := net.FilePacketConn(f)
+		f.Close()
+		if err == nil {
+			log.Printf("handoff: reusing inherited listener %s", name)
+			noteInheritable(name, pc)
+			return pc, nil
+		}
+		log.Printf("handoff: inherited listener %s unusable, binding fresh: %v", name, err)
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	noteInheritable(name, pc)
+	return pc, nil
+}
+
+// spawnSuccessor starts a new copy of the binary with the current
+// listening sockets attached, returning once the child is running.
+// The caller is expected to stop accepting and drain afterwards.
+func spawnSuccessor() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	handoff.mu.Lock()
+	var files []*os.File
+	var spec []string
+	for name, ln := range handoff.listeners {
+		f, err := ln.File()
+		if err != nil {
+			handoff.mu.Unlock()
+			for _, f := range files {
+				f.Close()
+			}
+			return fmt.Errorf("dup %s: %w", name, err)
+		}
+		// ExtraFiles[i] becomes fd 3+i in the child.
+		spec = append(spec, fmt.Sprintf("%s=%d", name, 3+len(files)))
+		files = append(files, f)
+	}
+	handoff.mu.Unlock()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), inheritFDsEnv+"="+strings.Join(spec, ","))
+	err = cmd.Start()
+	for _, f := range files {
+		f.Close() // child has its own dups now
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("handoff: successor started as pid %d with %d listener(s)", cmd.Process.Pid, len(files))
+	return cmd.Process.Release()
+}
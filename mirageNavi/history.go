@@ -0,0 +1,133 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+
+	"tailscale.com/metrics"
+)
+
+// A ring buffer of key DERP gauges, sampled every 10 seconds and kept
+// for 24 hours, served at /debug/history. It's enough for the status
+// dashboard and quick incident review without external monitoring.
+
+const (
+	historySampleInterval = 10 * time.Second
+	historyKeep           = 24 * time.Hour
+	historyMaxSamples     = int(historyKeep / historySampleInterval)
+)
+
+// historySample is one point in time. Rates are per-second averages
+// over the preceding sample interval.
+type historySample struct {
+	Time          time.Time `json:"time"`
+	Connections   int64     `json:"connections"`
+	BytesSentSec  float64   `json:"bytesSentPerSec"`
+	BytesRecvSec  float64   `json:"bytesRecvPerSec"`
+	AcceptsPerSec float64   `json:"acceptsPerSec"`
+}
+
+type historyBuffer struct {
+	mu      sync.Mutex
+	samples []historySample // ring, oldest at next when full
+	next    int
+
+	// previous counter readings, for rate computation
+	prevTime      time.Time
+	prevBytesSent int64
+	prevBytesRecv int64
+	prevAccepts   int64
+}
+
+var (
+	history               historyBuffer
+	historySamplerStarted bool
+)
+
+// startHistorySampler begins sampling the published "derp" expvar. It
+// must be called after the expvar is published; extra calls (e.g. on
+// config reload) are no-ops.
+func startHistorySampler() {
+	if historySamplerStarted {
+		return
+	}
+	historySamplerStarted = true
+	go func() {
+		for {
+			history.sample(time.Now())
+			time.Sleep(historySampleInterval)
+		}
+	}()
+}
+
+// derpExpInt returns the named *expvar.Int from the published derp
+// metrics set, or nil.
+func derpExpInt(name string) *expvar.Int {
+	m, ok := expvar.Get("derp").(*metrics.Set)
+	if !ok {
+		return nil
+	}
+	v, _ := m.Get(name).(*expvar.Int)
+	return v
+}
+
+func (h *historyBuffer) sample(now time.Time) {
+	var conns, bytesSent, bytesRecv, accepts int64
+	if v := derpExpInt("gauge_current_connections"); v != nil {
+		conns = v.Value()
+	}
+	if v := derpExpInt("bytes_sent"); v != nil {
+		bytesSent = v.Value()
+	}
+	if v := derpExpInt("bytes_received"); v != nil {
+		bytesRecv = v.Value()
+	}
+	if v := derpExpInt("accepts"); v != nil {
+		accepts = v.Value()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := historySample{Time: now, Connections: conns}
+	if !h.prevTime.IsZero() {
+		if dt := now.Sub(h.prevTime).Seconds(); dt > 0 {
+			s.BytesSentSec = float64(bytesSent-h.prevBytesSent) / dt
+			s.BytesRecvSec = float64(bytesRecv-h.prevBytesRecv) / dt
+			s.AcceptsPerSec = float64(accepts-h.prevAccepts) / dt
+		}
+	}
+	h.prevTime, h.prevBytesSent, h.prevBytesRecv, h.prevAccepts = now, bytesSent, bytesRecv, accepts
+
+	if len(h.samples) < historyMaxSamples {
+		h.samples = append(h.samples, s)
+		return
+	}
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % historyMaxSamples
+}
+
+// snapshot returns the buffered samples in chronological order.
+func (h *historyBuffer) snapshot() []historySample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]historySample, 0, len(h.samples))
+	out = append(out, h.samples[h.next:]...)
+	out = append(out, h.samples[:h.next]...)
+	return out
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(struct {
+		SampleIntervalSeconds float64         `json:"sampleIntervalSeconds"`
+		Samples               []historySample `json:"samples"`
+	}{historySampleInterval.Seconds(), history.snapshot()})
+}
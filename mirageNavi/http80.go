@@ -0,0 +1,71 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The --http-port listener. Serves the endpoints that must work over
+// plain HTTP — ACME HTTP-01 challenges, the captive-portal probe and
+// the health check — and 301-redirects everything else to the HTTPS
+// listener, so port 80 needs no separate infrastructure in front of
+// the relay.
+
+// acmeHTTPChallengeHandler, if non-nil, is given a chance to answer
+// /.well-known/acme-challenge/ requests. Set in letsencrypt mode.
+var acmeHTTPChallengeHandler func(w http.ResponseWriter, r *http.Request) bool
+
+// servedPlainHTTPAddr is the address the plain HTTP listener is
+// serving on. Empty if not serving.
+var servedPlainHTTPAddr string
+
+// servePlainHTTP runs the plain HTTP helper listener on addr.
+func servePlainHTTP(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/derp/probe", probeHandler)
+	mux.Handle("/generate_204", http.HandlerFunc(serveNoContent))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
+			if h := acmeHTTPChallengeHandler; h != nil && h(w, r) {
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, httpsBaseURL()+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      logRequests(mux),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	log.Printf("running HTTP server on %v", srv.Addr)
+	ln, err := listenTCPHandoff("http:"+addr, addr)
+	if err != nil {
+		log.Fatalf("http: %v", err)
+	}
+	if err := srv.Serve(ln); err != nil {
+		log.Fatalf("http: %v", err)
+	}
+}
+
+// httpsBaseURL returns the https:// URL of the main listener, with
+// the port included when it isn't 443.
+func httpsBaseURL() string {
+	base := "https://" + primaryHostname()
+	if _, port, err := net.SplitHostPort(*addr); err == nil && port != "443" && port != "https" {
+		base += ":" + port
+	}
+	return base
+}
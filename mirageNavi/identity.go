@@ -0,0 +1,162 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/term"
+	"tailscale.com/atomicfile"
+)
+
+var (
+	exportIdentity = flag.String("export-identity", "", "write this relay's identity (node key, machine key, DERP ID and controller URL) as an encrypted bundle to the given file and exit; the passphrase is read from $MIRAGE_IDENTITY_PASSPHRASE or prompted for")
+	importIdentity = flag.String("import-identity", "", "restore a relay identity from an encrypted bundle previously written by --export-identity, then exit; refuses to overwrite existing state")
+)
+
+// identityMagic identifies (and versions) the bundle format.
+const identityMagic = "mirage-navi-identity-1\n"
+
+const (
+	identitySaltLen  = 16
+	identityNonceLen = 24
+)
+
+// identityKey derives the secretbox key from a passphrase and salt.
+func identityKey(passphrase string, salt []byte) *[32]byte {
+	var key [32]byte
+	copy(key[:], argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, 32))
+	return &key
+}
+
+func identityPassphrase(confirm bool) (string, error) {
+	if v := os.Getenv("MIRAGE_IDENTITY_PASSPHRASE"); v != "" {
+		return v, nil
+	}
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		b2, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(b) != string(b2) {
+			return "", errors.New("passphrases don't match")
+		}
+	}
+	return string(b), nil
+}
+
+// runExportIdentity implements --export-identity and returns the process
+// exit code.
+func runExportIdentity(dst string) int {
+	resolveConfigPath()
+	b, err := os.ReadFile(*configPath)
+	if err != nil {
+		log.Printf("export-identity: reading %s: %v", *configPath, err)
+		return 1
+	}
+	var cfg config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Printf("export-identity: %s: %v", *configPath, err)
+		return 1
+	}
+	passphrase, err := identityPassphrase(true)
+	if err != nil {
+		log.Printf("export-identity: %v", err)
+		return 1
+	}
+
+	var salt [identitySaltLen]byte
+	var nonce [identityNonceLen]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		log.Printf("export-identity: %v", err)
+		return 1
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		log.Printf("export-identity: %v", err)
+		return 1
+	}
+	out := []byte(identityMagic)
+	out = append(out, salt[:]...)
+	out = append(out, nonce[:]...)
+	out = secretbox.Seal(out, b, &nonce, identityKey(passphrase, salt[:]))
+	if err := atomicfile.WriteFile(dst, out, 0600); err != nil {
+		log.Printf("export-identity: %v", err)
+		return 1
+	}
+	log.Printf("export-identity: wrote %s (DERP ID %q, controller %q)", dst, cfg.DERPID, cfg.CtrlURL)
+	return 0
+}
+
+// runImportIdentity implements --import-identity and returns the process
+// exit code. It refuses to clobber existing state: migrating to new
+// hardware should never silently destroy another relay's identity.
+func runImportIdentity(src string) int {
+	resolveConfigPath()
+	if _, err := os.Stat(*configPath); err == nil {
+		log.Printf("import-identity: %s already exists; move it aside first", *configPath)
+		return 1
+	}
+	raw, err := os.ReadFile(src)
+	if err != nil {
+		log.Printf("import-identity: %v", err)
+		return 1
+	}
+	if len(raw) < len(identityMagic)+identitySaltLen+identityNonceLen+secretbox.Overhead ||
+		string(raw[:len(identityMagic)]) != identityMagic {
+		log.Printf("import-identity: %s is not an identity bundle", src)
+		return 1
+	}
+	raw = raw[len(identityMagic):]
+	var salt [identitySaltLen]byte
+	var nonce [identityNonceLen]byte
+	copy(salt[:], raw)
+	copy(nonce[:], raw[identitySaltLen:])
+	box := raw[identitySaltLen+identityNonceLen:]
+
+	passphrase, err := identityPassphrase(false)
+	if err != nil {
+		log.Printf("import-identity: %v", err)
+		return 1
+	}
+	b, ok := secretbox.Open(nil, box, &nonce, identityKey(passphrase, salt[:]))
+	if !ok {
+		log.Printf("import-identity: decryption failed (wrong passphrase or corrupt bundle)")
+		return 1
+	}
+	var cfg config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Printf("import-identity: bundle contents: %v", err)
+		return 1
+	}
+	if err := os.MkdirAll(filepath.Dir(*configPath), 0777); err != nil {
+		log.Printf("import-identity: %v", err)
+		return 1
+	}
+	if err := atomicfile.WriteFile(*configPath, b, 0600); err != nil {
+		log.Printf("import-identity: %v", err)
+		return 1
+	}
+	log.Printf("import-identity: restored %s (DERP ID %q, controller %q)", *configPath, cfg.DERPID, cfg.CtrlURL)
+	return 0
+}
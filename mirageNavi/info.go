@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/version"
+)
+
+// Build and runtime inventory for the controller, served over the
+// noise management channel as the /ctrl/info RPC, so the controller
+// can render a complete relay inventory without scraping the debug
+// HTTP endpoints.
+
+// processStart is when this process started, for uptime reporting.
+var processStart = time.Now()
+
+// secretFlag reports whether a flag value is a credential that must
+// not leave the process.
+func secretFlag(name string) bool {
+	switch name {
+	case "dns-key", "admin-token", "metrics-token", "presence-salt", "acme-eab-key":
+		return true
+	}
+	return false
+}
+
+// flagsInEffect returns the flags explicitly set on the command line
+// and their values, with credentials redacted.
+func flagsInEffect() map[string]string {
+	m := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		if secretFlag(f.Name) {
+			m[f.Name] = "(redacted)"
+			return
+		}
+		m[f.Name] = f.Value.String()
+	})
+	return m
+}
+
+// naviInfoReport returns the builder for the /ctrl/info management
+// RPC response. certExpiry reports the current certificate expiry
+// (consulted per report, since renewal happens in place); it returns
+// zero when no ACME certificate is in use (manual or plaintext dev
+// mode).
+func naviInfoReport(certExpiry func() time.Time) func() derp.NaviInfoReport {
+	return func() derp.NaviInfoReport {
+		r := derp.NaviInfoReport{
+			Navi:      *derpID,
+			Version:   version.Long(),
+			GoVersion: runtime.Version(),
+			StartTime: processStart,
+			Uptime:    time.Since(processStart).Round(time.Second).String(),
+			Flags:     flagsInEffect(),
+			CertMode:  *certMode,
+		}
+		if *insecureDevHTTP {
+			r.CertMode = "none (insecure dev HTTP)"
+		}
+		if fp := currentSelfSignedFP(); fp != "" {
+			r.CertFP = fp
+		}
+		if e := certExpiry(); !e.IsZero() {
+			r.CertExpiry = &e
+		}
+		r.Listeners = append(r.Listeners, fmt.Sprintf("https %s", *addr))
+		if servedPlainHTTPAddr != "" {
+			r.Listeners = append(r.Listeners, "http "+servedPlainHTTPAddr)
+		}
+		for _, p := range servedSTUNPorts {
+			r.Listeners = append(r.Listeners, fmt.Sprintf("stun udp %s:%d", servedSTUNHost, p))
+		}
+		if servedQUICAddr != "" {
+			r.Listeners = append(r.Listeners, "quic udp "+servedQUICAddr)
+		}
+		if *noiseMgmtAddr != "" {
+			r.Listeners = append(r.Listeners, fmt.Sprintf("noise %s", *noiseMgmtAddr))
+		}
+		return r
+	}
+}
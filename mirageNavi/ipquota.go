@@ -0,0 +1,114 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"expvar"
+	"flag"
+	"net"
+	"net/netip"
+	"sync"
+
+	"tailscale.com/metrics"
+)
+
+// Per-source-IP connection quotas. The accept rate limiter only
+// bounds the aggregate rate of new connections; one misbehaving host
+// reconnecting in a tight loop or holding connections open can still
+// exhaust the server's descriptors. These quotas cap the number of
+// simultaneous connections per source IP and per aggregation prefix
+// (/24 for IPv4, /64 for IPv6, since v6 hosts have a whole /64 of
+// addresses to rotate through).
+
+var (
+	maxConnsPerIP     = flag.Int("max-conns-per-ip", 0, "maximum simultaneous connections from a single source IP; 0 means unlimited")
+	maxConnsPerPrefix = flag.Int("max-conns-per-prefix", 0, "maximum simultaneous connections from a single source /24 (IPv4) or /64 (IPv6); 0 means unlimited")
+)
+
+var (
+	quotaRejects      = &metrics.LabelMap{Label: "scope"}
+	quotaRejectIP     = quotaRejects.Get("ip")
+	quotaRejectPrefix = quotaRejects.Get("prefix")
+)
+
+func init() {
+	expvar.Publish("derper_ip_quota_rejects", quotaRejects)
+}
+
+// ipQuota tracks the number of live connections per source IP and per
+// aggregation prefix.
+type ipQuota struct {
+	mu        sync.Mutex
+	perIP     map[netip.Addr]int
+	perPrefix map[netip.Prefix]int
+}
+
+// connQuota is the process-wide quota, shared by all listeners.
+var connQuota = &ipQuota{
+	perIP:     map[netip.Addr]int{},
+	perPrefix: map[netip.Prefix]int{},
+}
+
+// quotaPrefix returns the aggregation prefix for ip: /24 for IPv4,
+// /64 for IPv6.
+func quotaPrefix(ip netip.Addr) netip.Prefix {
+	bits := 64
+	if ip.Is4() {
+		bits = 24
+	}
+	p, _ := ip.Prefix(bits)
+	return p
+}
+
+// acquire reserves a connection slot for remote, reporting whether
+// the connection fits in the quotas. On success the returned release
+// func gives the slot back; it is safe to call more than once.
+func (q *ipQuota) acquire(remote net.Addr) (release func(), ok bool) {
+	if *maxConnsPerIP <= 0 && *maxConnsPerPrefix <= 0 {
+		return func() {}, true
+	}
+	ap, err := netip.ParseAddrPort(remote.String())
+	if err != nil {
+		return func() {}, true // not ip:port (unix socket?); not subject to quota
+	}
+	ip := ap.Addr().Unmap()
+	pfx := quotaPrefix(ip)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if *maxConnsPerIP > 0 && q.perIP[ip] >= *maxConnsPerIP {
+		quotaRejectIP.Add(1)
+		return nil, false
+	}
+	if *maxConnsPerPrefix > 0 && q.perPrefix[pfx] >= *maxConnsPerPrefix {
+		quotaRejectPrefix.Add(1)
+		return nil, false
+	}
+	q.perIP[ip]++
+	q.perPrefix[pfx]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			if q.perIP[ip]--; q.perIP[ip] <= 0 {
+				delete(q.perIP, ip)
+			}
+			if q.perPrefix[pfx]--; q.perPrefix[pfx] <= 0 {
+				delete(q.perPrefix, pfx)
+			}
+		})
+	}, true
+}
+
+// quotaConn gives its quota slot back when the connection closes.
+type quotaConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *quotaConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
@@ -0,0 +1,145 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Load-based admission control. When the process is running hot —
+// CPU, resident memory or file descriptors past their thresholds —
+// new DERP connection upgrades are rejected with a Retry-After hint
+// so clients fail over to another relay, while sessions that are
+// already established keep running. Shedding clears only after the
+// load has stayed below the thresholds for a few consecutive
+// samples, so a server hovering at the limit doesn't flap.
+
+var (
+	loadShedCPU = flag.Float64("load-shed-cpu-pct", 0, "process CPU use (percent of all cores) above which new DERP connections are rejected; 0 disables")
+	loadShedMem = flag.Int64("load-shed-mem-bytes", 0, "resident memory bytes above which new DERP connections are rejected; 0 disables")
+	loadShedFD  = flag.Float64("load-shed-fd-pct", 90, "open file descriptors as a percent of the soft limit above which new DERP connections are rejected; 0 disables")
+)
+
+// loadShedInterval is how often resource usage is sampled.
+const loadShedInterval = 5 * time.Second
+
+// loadShedClearSamples is how many consecutive below-threshold
+// samples are needed before shedding is lifted.
+const loadShedClearSamples = 3
+
+// loadShedRetryAfter is the Retry-After hint sent with rejections.
+const loadShedRetryAfter = 30 * time.Second
+
+// loadShedding is whether new DERP connections are currently being
+// rejected.
+var loadShedding atomic.Bool
+
+var (
+	loadShedGauge   expvar.Int // gauge: 1 while shedding is active
+	loadShedRejects expvar.Int
+	loadShedCPUPct  expvar.Float // gauge: last sampled CPU percent
+	loadShedRSS     expvar.Int   // gauge: last sampled resident bytes
+	loadShedFDs     expvar.Int   // gauge: last sampled open descriptors
+)
+
+func init() {
+	expvar.Publish("gauge_derper_load_shedding", &loadShedGauge)
+	expvar.Publish("counter_derper_load_shed_rejects", &loadShedRejects)
+	expvar.Publish("gauge_derper_load_cpu_pct", &loadShedCPUPct)
+	expvar.Publish("gauge_derper_load_rss_bytes", &loadShedRSS)
+	expvar.Publish("gauge_derper_load_open_fds", &loadShedFDs)
+}
+
+// shedOnOverload wraps the DERP upgrade handler with the admission
+// check. Everything else on the mux (probe, health, metrics) stays
+// reachable while shedding, so monitoring keeps working.
+func shedOnOverload(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if loadShedding.Load() {
+			loadShedRejects.Add(1)
+			w.Header().Set("Retry-After", fmt.Sprint(int(loadShedRetryAfter.Seconds())))
+			http.Error(w, "derp server overloaded; retry later", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// runLoadShedMonitor samples resource usage every loadShedInterval
+// and flips loadShedding accordingly. It runs until shutdown is
+// closed. On platforms without the needed sampling support the
+// unreadable dimensions are skipped.
+func runLoadShedMonitor(shutdown chan struct{}) {
+	if *loadShedCPU <= 0 && *loadShedMem <= 0 && *loadShedFD <= 0 {
+		return
+	}
+	ticker := time.NewTicker(loadShedInterval)
+	defer ticker.Stop()
+	lastCPU := processCPUTime()
+	lastSample := time.Now()
+	clearStreak := 0
+	for {
+		select {
+		case <-shutdown:
+			return
+		case now := <-ticker.C:
+			var reasons []string
+
+			if *loadShedCPU > 0 {
+				if busy := processCPUTime(); busy >= 0 && lastCPU >= 0 {
+					elapsed := now.Sub(lastSample)
+					pct := (busy - lastCPU).Seconds() / elapsed.Seconds() / float64(runtime.NumCPU()) * 100
+					loadShedCPUPct.Set(pct)
+					if pct > *loadShedCPU {
+						reasons = append(reasons, fmt.Sprintf("cpu %.0f%% > %.0f%%", pct, *loadShedCPU))
+					}
+					lastCPU = busy
+				}
+			}
+			lastSample = now
+
+			if *loadShedMem > 0 {
+				if rss := processRSSBytes(); rss >= 0 {
+					loadShedRSS.Set(rss)
+					if rss > *loadShedMem {
+						reasons = append(reasons, fmt.Sprintf("rss %d > %d bytes", rss, *loadShedMem))
+					}
+				}
+			}
+
+			if *loadShedFD > 0 {
+				if open, limit := processOpenFDs(); open >= 0 && limit > 0 {
+					loadShedFDs.Set(open)
+					pct := float64(open) / float64(limit) * 100
+					if pct > *loadShedFD {
+						reasons = append(reasons, fmt.Sprintf("fds %d/%d (%.0f%% > %.0f%%)", open, limit, pct, *loadShedFD))
+					}
+				}
+			}
+
+			switch {
+			case len(reasons) > 0:
+				clearStreak = 0
+				if loadShedding.CompareAndSwap(false, true) {
+					loadShedGauge.Set(1)
+					log.Printf("load shedding active: %v", reasons)
+				}
+			case loadShedding.Load():
+				clearStreak++
+				if clearStreak >= loadShedClearSamples {
+					loadShedding.Store(false)
+					loadShedGauge.Set(0)
+					log.Printf("load shedding cleared")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package main
+
+import "time"
+
+// Resource sampling for load shedding is only implemented on Linux;
+// on other platforms the monitor skips whatever it can't read.
+
+func processCPUTime() time.Duration { return -1 }
+
+func processRSSBytes() int64 { return -1 }
+
+func processOpenFDs() (open, limit int64) { return -1, 0 }
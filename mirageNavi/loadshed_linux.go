@@ -0,0 +1,55 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// processCPUTime returns the CPU time (user+system) consumed by this
+// process, or a negative duration if it can't be read.
+func processCPUTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return -1
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// processRSSBytes returns the process's resident set size in bytes,
+// or -1 if it can't be read.
+func processRSSBytes() int64 {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return -1
+	}
+	// ru_maxrss is in kilobytes on Linux, and is a high-water mark;
+	// current RSS from statm is preferred when available.
+	if data, err := os.ReadFile("/proc/self/statm"); err == nil {
+		var size, rss int64
+		if n, _ := fmt.Sscanf(string(data), "%d %d", &size, &rss); n == 2 {
+			return rss * int64(os.Getpagesize())
+		}
+	}
+	return ru.Maxrss * 1024
+}
+
+// processOpenFDs returns the number of open file descriptors and the
+// soft descriptor limit. Either is negative/zero if unreadable.
+func processOpenFDs() (open, limit int64) {
+	open = -1
+	if ents, err := os.ReadDir("/proc/self/fd"); err == nil {
+		open = int64(len(ents))
+	}
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err == nil {
+		limit = int64(rl.Cur)
+	}
+	return open, limit
+}
@@ -18,7 +18,32 @@ import (
 	"tailscale.com/types/logger"
 )
 
+// meshedHosts are the hosts this server's mesh clients were started
+// for, so a settings reload can add new peers without duplicating the
+// existing ones. Reset when a (re)started server meshes from scratch.
+var meshedHosts = map[string]bool{}
+
 func startMesh(s *derp.Server) error {
+	meshedHosts = map[string]bool{}
+	if *meshWith == "" {
+		return nil
+	}
+	if !s.HasMeshKey() {
+		return errors.New("--mesh-with requires --mesh-psk-file")
+	}
+	for _, host := range strings.Split(*meshWith, ",") {
+		if err := startMeshWithHost(s, host); err != nil {
+			return err
+		}
+		meshedHosts[host] = true
+	}
+	return nil
+}
+
+// addMeshHosts starts mesh clients for hosts newly added to
+// --mesh-with. Used by the SIGHUP settings reload; hosts we're already
+// meshed with are left alone.
+func addMeshHosts(s *derp.Server) error {
 	if *meshWith == "" {
 		return nil
 	}
@@ -26,9 +51,14 @@ func startMesh(s *derp.Server) error {
 		return errors.New("--mesh-with requires --mesh-psk-file")
 	}
 	for _, host := range strings.Split(*meshWith, ",") {
+		if meshedHosts[host] {
+			continue
+		}
 		if err := startMeshWithHost(s, host); err != nil {
 			return err
 		}
+		meshedHosts[host] = true
+		log.Printf("mesh: added peer %q via settings reload", host)
 	}
 	return nil
 }
@@ -0,0 +1,122 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/binary"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// PROXY protocol v2 support. When derper runs behind HAProxy or an
+// NLB in TCP mode, every connection arrives from the proxy's address;
+// rate limiting, per-IP quotas, logging and the keyOfAddr map would
+// all see one IP. With --proxy-protocol the listeners consume the
+// PROXY v2 header the proxy prepends and use the client address it
+// carries instead.
+
+var proxyProtocol = flag.Bool("proxy-protocol", false, "expect a PROXY protocol v2 header on connections to the TLS and plaintext listeners; only enable when the listeners are reachable exclusively through a proxy that sends it")
+
+var counterProxyProtoErrors = expvar.NewInt("derper_proxy_protocol_errors")
+
+// proxyHeaderTimeout bounds how long a connection may take to deliver
+// its PROXY header. Real proxies send it together with the connection.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Sig is the fixed 12-byte signature a PROXY v2 header starts with.
+var proxyV2Sig = [12]byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// maybeProxyListener wraps ln to consume PROXY v2 headers if
+// --proxy-protocol is set; otherwise it returns ln unchanged.
+func maybeProxyListener(ln net.Listener) net.Listener {
+	if !*proxyProtocol {
+		return ln
+	}
+	return &proxyProtoListener{Listener: ln}
+}
+
+type proxyProtoListener struct {
+	net.Listener
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	for {
+		cn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		cn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		remote, err := readProxyV2Header(cn)
+		cn.SetReadDeadline(time.Time{})
+		if err != nil {
+			counterProxyProtoErrors.Add(1)
+			cn.Close()
+			continue
+		}
+		if remote != nil {
+			cn = &proxyProtoConn{Conn: cn, remote: remote}
+		}
+		return cn, nil
+	}
+}
+
+// readProxyV2Header consumes a PROXY protocol v2 header from c and
+// returns the client address it carries. A nil address with nil error
+// means the header was valid but carried no usable address (a LOCAL
+// health check from the proxy, or an unsupported address family).
+func readProxyV2Header(c net.Conn) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(c, hdr[:]); err != nil {
+		return nil, fmt.Errorf("proxy header: %w", err)
+	}
+	if [12]byte(hdr[:12]) != proxyV2Sig {
+		return nil, fmt.Errorf("proxy header: bad signature")
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("proxy header: unsupported version %d", verCmd>>4)
+	}
+	addrLen := int(binary.BigEndian.Uint16(hdr[14:16]))
+	addrs := make([]byte, addrLen)
+	if _, err := io.ReadFull(c, addrs); err != nil {
+		return nil, fmt.Errorf("proxy header: %w", err)
+	}
+	if verCmd&0xF == 0 {
+		return nil, nil // LOCAL: the proxy's own connection (health check)
+	}
+	if verCmd&0xF != 1 {
+		return nil, fmt.Errorf("proxy header: unsupported command %d", verCmd&0xF)
+	}
+	switch fam >> 4 {
+	case 1: // AF_INET
+		if addrLen < 12 {
+			return nil, fmt.Errorf("proxy header: short TCP4 addresses")
+		}
+		ip, _ := netip.AddrFromSlice(addrs[0:4])
+		port := binary.BigEndian.Uint16(addrs[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	case 2: // AF_INET6
+		if addrLen < 36 {
+			return nil, fmt.Errorf("proxy header: short TCP6 addresses")
+		}
+		ip, _ := netip.AddrFromSlice(addrs[0:16])
+		port := binary.BigEndian.Uint16(addrs[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	}
+	return nil, nil // AF_UNSPEC/AF_UNIX: keep the proxy's address
+}
+
+// proxyProtoConn reports the client address from the PROXY header as
+// its remote address.
+type proxyProtoConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr { return c.remote }
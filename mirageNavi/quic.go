@@ -0,0 +1,106 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"expvar"
+	"flag"
+	"log"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"tailscale.com/derp"
+)
+
+// DERP over QUIC. An optional UDP listener speaks the normal DERP
+// framing over a single bidirectional QUIC stream. The server opens
+// the stream: DERP's server speaks first (the server-key frame), and
+// a QUIC stream only becomes visible to the peer once data is sent on
+// it, so a client-opened stream would deadlock with both sides
+// waiting.
+// Compared to TCP+TLS this saves a handshake round trip and survives
+// NAT rebinding, which matters for mobile clients on flaky networks.
+// The listener shares the TLS certificate configuration with the
+// HTTPS listener and is advertised in the /ctrl/info inventory.
+
+var quicAddr = flag.String("quic-addr", "", "optional UDP address (e.g. :3340) on which to serve DERP over QUIC; empty disables the QUIC listener")
+
+// derpQUICNextProto is the ALPN protocol name DERP-over-QUIC clients
+// must offer.
+const derpQUICNextProto = "derp"
+
+var counterQUICAccepts = expvar.NewInt("derp_quic_accepts")
+
+// servedQUICAddr is the address the QUIC listener is serving on, for
+// the /ctrl/info inventory report. Empty if not serving.
+var servedQUICAddr string
+
+// serveDERPQUIC runs the DERP-over-QUIC listener until it fails.
+// tlsConfig is the same certificate configuration the TCP/TLS
+// listener uses.
+func serveDERPQUIC(s *derp.Server, tlsConfig *tls.Config) {
+	tc := tlsConfig.Clone()
+	tc.NextProtos = []string{derpQUICNextProto}
+	ln, err := quic.ListenAddr(*quicAddr, tc, &quic.Config{
+		MaxIdleTimeout: 90 * time.Second,
+	})
+	if err != nil {
+		log.Fatalf("failed to open QUIC listener: %v", err)
+	}
+	servedQUICAddr = ln.Addr().String()
+	log.Printf("running DERP-over-QUIC server on %v", ln.Addr())
+	for {
+		qc, err := ln.Accept(context.Background())
+		if err != nil {
+			log.Printf("QUIC accept: %v", err)
+			return
+		}
+		go serveQUICConn(s, qc)
+	}
+}
+
+// serveQUICConn runs one QUIC connection to completion: it opens the
+// DERP stream and hands it to the DERP server like any other
+// connection.
+func serveQUICConn(s *derp.Server, qc quic.Connection) {
+	release, ok := connQuota.acquire(qc.RemoteAddr())
+	if !ok {
+		qc.CloseWithError(1, "per-IP connection quota exceeded")
+		return
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	st, err := qc.OpenStreamSync(ctx)
+	cancel()
+	if err != nil {
+		qc.CloseWithError(1, "no derp stream")
+		return
+	}
+	counterQUICAccepts.Add(1)
+	nc := &quicDERPConn{Stream: st, qc: qc}
+	brw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	s.Accept(qc.Context(), nc, brw, qc.RemoteAddr().String())
+	qc.CloseWithError(0, "")
+}
+
+// quicDERPConn adapts a QUIC stream to the derp.Conn interface.
+type quicDERPConn struct {
+	quic.Stream
+	qc quic.Connection
+}
+
+func (c *quicDERPConn) LocalAddr() net.Addr { return c.qc.LocalAddr() }
+
+// Close closes the whole QUIC connection, not just the stream, so a
+// server-initiated close unblocks the reader the way closing a TCP
+// conn would.
+func (c *quicDERPConn) Close() error {
+	c.Stream.CancelRead(0)
+	c.Stream.Close()
+	return c.qc.CloseWithError(0, "")
+}
@@ -0,0 +1,106 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/time/rate"
+	"tailscale.com/derp"
+)
+
+// SIGHUP hot reload. The restart loop (SIGUSR2) tears down every DERP
+// session, which is the right tool for a new listen address but far
+// too blunt for tuning a rate limit. Settings listed in
+// the settings file can instead be changed in place: edit the file,
+// send SIGHUP, and live client connections are untouched.
+
+var settingsPath = flag.String("settings-file", "", "optional JSON file of hot-reloadable settings, applied at startup and re-applied on SIGHUP without dropping DERP sessions")
+
+// hotSettings is the subset of flag-backed settings that can be
+// re-applied to a running server. Fields are pointers so that settings
+// omitted from the file keep their command-line values.
+type hotSettings struct {
+	AcceptConnLimit *float64 `json:"acceptConnLimit,omitempty"`
+	AcceptConnBurst *int     `json:"acceptConnBurst,omitempty"`
+	BootstrapDNS    *string  `json:"bootstrapDNSNames,omitempty"`
+	UnpublishedDNS  *string  `json:"unpublishedBootstrapDNSNames,omitempty"`
+	MeshWith        *string  `json:"meshWith,omitempty"`
+	VerifyClients   *bool    `json:"verifyClients,omitempty"`
+}
+
+var settingsReloads expvar.Int // counter of successful settings reloads
+
+func init() {
+	expvar.Publish("derper_settings_reloads", &settingsReloads)
+}
+
+// applyHotSettings reads the settings file and writes its values into
+// the flag variables the rest of the server reads, pushing them into
+// live state where one exists. live is false at startup, when serving
+// hasn't begun yet and the normal startup path (mesh dial, bootstrap
+// DNS loop) will pick the flags up itself.
+func applyHotSettings(s *derp.Server, live bool) error {
+	if *settingsPath == "" {
+		return nil
+	}
+	b, err := os.ReadFile(*settingsPath)
+	if err != nil {
+		return err
+	}
+	var hs hotSettings
+	if err := json.Unmarshal(b, &hs); err != nil {
+		return fmt.Errorf("settings file %s: %v", *settingsPath, err)
+	}
+
+	if hs.AcceptConnLimit != nil {
+		*acceptConnLimit = *hs.AcceptConnLimit
+		if l := activeListener.Load(); l != nil {
+			l.lim.SetLimit(rate.Limit(*hs.AcceptConnLimit))
+		}
+	}
+	if hs.AcceptConnBurst != nil {
+		*acceptConnBurst = *hs.AcceptConnBurst
+		if l := activeListener.Load(); l != nil {
+			l.lim.SetBurst(*hs.AcceptConnBurst)
+		}
+	}
+	if hs.BootstrapDNS != nil && *hs.BootstrapDNS != *bootstrapDNS {
+		*bootstrapDNS = *hs.BootstrapDNS
+		if live {
+			go refreshBootstrapDNS()
+		}
+	}
+	if hs.UnpublishedDNS != nil && *hs.UnpublishedDNS != *unpublishedDNS {
+		*unpublishedDNS = *hs.UnpublishedDNS
+		if live {
+			go refreshUnpublishedDNS()
+		}
+	}
+	if hs.MeshWith != nil && *hs.MeshWith != *meshWith {
+		*meshWith = *hs.MeshWith
+		if live {
+			// Only additions take effect in place; dropping a mesh
+			// peer still needs a restart to tear its client down.
+			if err := addMeshHosts(s); err != nil {
+				log.Printf("derper: mesh reload: %v", err)
+			}
+		}
+	}
+	if hs.VerifyClients != nil {
+		s.SetVerifyClient(*hs.VerifyClients)
+		*verifyClients = *hs.VerifyClients
+	}
+
+	if live {
+		settingsReloads.Add(1)
+		log.Printf("derper: settings reloaded from %s", *settingsPath)
+	}
+	return nil
+}
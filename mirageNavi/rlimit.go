@@ -0,0 +1,59 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"flag"
+	"log"
+)
+
+// Descriptor limit self-configuration. Each DERP client costs a
+// descriptor, and an inherited soft RLIMIT_NOFILE of 1024 shows up
+// in production as confusing accept/handshake errors long before the
+// host is actually out of resources. At startup the soft limit is
+// raised toward a configurable target (capped by the hard limit),
+// and the server can be told to refuse to start when the result is
+// still too small for the expected load.
+
+var (
+	fdLimitTarget = flag.Uint64("fd-limit", 65536, "soft RLIMIT_NOFILE to raise to at startup, capped by the hard limit; 0 leaves the limit unchanged")
+	fdLimitMin    = flag.Uint64("fd-limit-min", 0, "refuse to start if the soft descriptor limit is still below this after raising; 0 only warns")
+)
+
+// fdLimitWarn is the soft limit below which a warning is always
+// logged, even without --fd-limit-min.
+const fdLimitWarn = 4096
+
+// configureFDLimit raises the soft descriptor limit toward
+// --fd-limit and validates the result. Called once at startup,
+// before any listeners are opened.
+func configureFDLimit() {
+	cur, max, ok := getFDLimit()
+	if !ok {
+		return
+	}
+	if *fdLimitTarget > 0 && cur < *fdLimitTarget {
+		want := *fdLimitTarget
+		if want > max {
+			want = max
+		}
+		if want <= cur {
+			// Already at the hard cap; nothing to raise.
+		} else if err := setFDLimit(want, max); err != nil {
+			log.Printf("derper: raising RLIMIT_NOFILE soft limit %d -> %d: %v", cur, want, err)
+		} else {
+			log.Printf("derper: raised RLIMIT_NOFILE soft limit %d -> %d (hard %d)", cur, want, max)
+			cur = want
+		}
+		if cur < *fdLimitTarget {
+			log.Printf("derper: descriptor soft limit %d is below target %d; raise the hard limit (e.g. LimitNOFILE= in the systemd unit) to serve more concurrent clients", cur, *fdLimitTarget)
+		}
+	}
+	if *fdLimitMin > 0 && cur < *fdLimitMin {
+		log.Fatalf("derper: descriptor soft limit %d is below required --fd-limit-min=%d; refusing to start", cur, *fdLimitMin)
+	}
+	if cur < fdLimitWarn {
+		log.Printf("derper: descriptor soft limit is only %d; expect accept errors under load", cur)
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !unix
+
+package main
+
+// Descriptor limits are a Unix concept; elsewhere the startup
+// self-configuration is a no-op.
+
+func getFDLimit() (cur, max uint64, ok bool) { return 0, 0, false }
+
+func setFDLimit(cur, max uint64) error { return nil }
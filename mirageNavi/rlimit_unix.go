@@ -0,0 +1,26 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package main
+
+import (
+	"log"
+	"syscall"
+)
+
+// getFDLimit returns the current soft and hard RLIMIT_NOFILE.
+func getFDLimit() (cur, max uint64, ok bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		log.Printf("derper: getrlimit(RLIMIT_NOFILE): %v", err)
+		return 0, 0, false
+	}
+	return uint64(rl.Cur), uint64(rl.Max), true
+}
+
+// setFDLimit sets the soft and hard RLIMIT_NOFILE.
+func setFDLimit(cur, max uint64) error {
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: cur, Max: max})
+}
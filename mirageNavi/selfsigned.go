@@ -0,0 +1,259 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/atomicfile"
+)
+
+// certmode=selfsigned: the server generates and rotates its own
+// serving certificate, for air-gapped deployments where neither an
+// ACME CA nor manual provisioning is practical. Optionally the cert
+// is signed by an operator-provided CA instead of being bare
+// self-signed, so fleets that already distribute an internal root
+// need no per-relay trust configuration. Managed clients learn the
+// current fingerprint through the controller's /ctrl/info inventory.
+
+var (
+	selfsignedCACrt = flag.String("selfsigned-ca-crt", "", "optional PEM CA certificate used to sign the serving cert in selfsigned mode; without it the cert is bare self-signed")
+	selfsignedCAKey = flag.String("selfsigned-ca-key", "", "optional PEM CA private key paired with --selfsigned-ca-crt")
+)
+
+// selfsignedValidity is how long each generated certificate lasts.
+const selfsignedValidity = 365 * 24 * time.Hour
+
+// selfsignedRotateEarly is how long before expiry the certificate is
+// regenerated. Rotation happens in place during a handshake, so no
+// restart is needed.
+const selfsignedRotateEarly = 30 * 24 * time.Hour
+
+// selfSignedFP holds the SHA-256 fingerprint ("sha256:<hex>") of the
+// current self-signed certificate, for the /ctrl/info report.
+var selfSignedFP atomic.Value // of string
+
+// currentSelfSignedFP returns the current certificate fingerprint,
+// or "" when not running in selfsigned mode.
+func currentSelfSignedFP() string {
+	fp, _ := selfSignedFP.Load().(string)
+	return fp
+}
+
+type selfSignedCertManager struct {
+	mu        sync.Mutex
+	dir       string
+	hostnames []string
+	caCert    *x509.Certificate // non-nil when signing from a provided CA
+	caKey     crypto.Signer
+	cert      *tls.Certificate
+	leaf      *x509.Certificate
+}
+
+// NewSelfSignedCertManager returns a cert provider that serves a
+// locally generated certificate covering hostnames, persisting it in
+// certdir so the identity (and fingerprint) survives restarts.
+func NewSelfSignedCertManager(certdir string, hostnames []string) (certProvider, error) {
+	if (*selfsignedCACrt == "") != (*selfsignedCAKey == "") {
+		return nil, fmt.Errorf("--selfsigned-ca-crt and --selfsigned-ca-key must be set together")
+	}
+	if err := os.MkdirAll(certdir, 0700); err != nil {
+		return nil, err
+	}
+	m := &selfSignedCertManager{dir: certdir, hostnames: hostnames}
+	if *selfsignedCACrt != "" {
+		ca, err := tls.LoadX509KeyPair(*selfsignedCACrt, *selfsignedCAKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading signing CA: %w", err)
+		}
+		m.caCert, err = x509.ParseCertificate(ca.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parsing signing CA: %w", err)
+		}
+		signer, ok := ca.PrivateKey.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("signing CA key type %T can't sign", ca.PrivateKey)
+		}
+		m.caKey = signer
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.loadLocked(); err != nil {
+		log.Printf("selfsigned: generating new certificate: %v", err)
+		if err := m.generateLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *selfSignedCertManager) crtPath() string { return filepath.Join(m.dir, "selfsigned.crt") }
+func (m *selfSignedCertManager) keyPath() string { return filepath.Join(m.dir, "selfsigned.key") }
+
+// loadLocked loads a previously generated certificate from disk and
+// checks that it is still usable: covers every hostname, chains to
+// the configured CA (if any), and isn't due for rotation.
+func (m *selfSignedCertManager) loadLocked() error {
+	cert, err := tls.LoadX509KeyPair(m.crtPath(), m.keyPath())
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	for _, h := range m.hostnames {
+		if ip := net.ParseIP(h); ip != nil {
+			if err := verifyCertIP(leaf, ip); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := leaf.VerifyHostname(h); err != nil {
+			return err
+		}
+	}
+	if m.caCert != nil {
+		if err := leaf.CheckSignatureFrom(m.caCert); err != nil {
+			return fmt.Errorf("stored cert not signed by configured CA: %w", err)
+		}
+	} else if leaf.Issuer.String() != leaf.Subject.String() {
+		return fmt.Errorf("stored cert is CA-signed but no CA is configured")
+	}
+	if time.Until(leaf.NotAfter) < selfsignedRotateEarly {
+		return fmt.Errorf("stored cert expires %v, rotating", leaf.NotAfter)
+	}
+	cert.Leaf = leaf
+	m.cert, m.leaf = &cert, leaf
+	m.noteFingerprintLocked()
+	return nil
+}
+
+// verifyCertIP reports whether leaf covers the given IP SAN.
+func verifyCertIP(leaf *x509.Certificate, ip net.IP) error {
+	for _, san := range leaf.IPAddresses {
+		if san.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("cert has no SAN for IP %v", ip)
+}
+
+// generateLocked creates a fresh key and certificate covering
+// m.hostnames, writes both to disk, and installs them.
+func (m *selfSignedCertManager) generateLocked() error {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: m.hostnames[0]},
+		NotBefore:    now.Add(-time.Hour), // tolerate clock skew
+		NotAfter:     now.Add(selfsignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range m.hostnames {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	parent, signKey := tmpl, crypto.Signer(priv)
+	if m.caCert != nil {
+		parent, signKey = m.caCert, m.caKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &priv.PublicKey, signKey)
+	if err != nil {
+		return err
+	}
+	chain := [][]byte{der}
+	crtPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if m.caCert != nil {
+		chain = append(chain, m.caCert.Raw)
+		crtPEM = append(crtPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := atomicfile.WriteFile(m.keyPath(), keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(m.crtPath(), crtPEM, 0644); err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	m.cert = &tls.Certificate{Certificate: chain, PrivateKey: priv, Leaf: leaf}
+	m.leaf = leaf
+	m.noteFingerprintLocked()
+	log.Printf("selfsigned: new certificate for %v, expires %v, fingerprint %s",
+		m.hostnames, leaf.NotAfter.Format(time.RFC3339), currentSelfSignedFP())
+	return nil
+}
+
+// noteFingerprintLocked publishes the current leaf's fingerprint for
+// the /ctrl/info report.
+func (m *selfSignedCertManager) noteFingerprintLocked() {
+	sum := sha256.Sum256(m.leaf.Raw)
+	selfSignedFP.Store(fmt.Sprintf("sha256:%x", sum))
+}
+
+func (m *selfSignedCertManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		NextProtos: []string{
+			"h2", "http/1.1", // enable HTTP/2
+		},
+		GetCertificate: m.getCertificate,
+	}
+}
+
+func (m *selfSignedCertManager) getCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Until(m.leaf.NotAfter) < selfsignedRotateEarly {
+		if err := m.generateLocked(); err != nil {
+			log.Printf("selfsigned: rotation failed, serving old cert: %v", err)
+		}
+	}
+	// Return a shallow copy so the caller can append the meta cert.
+	certCopy := new(tls.Certificate)
+	*certCopy = *m.cert
+	certCopy.Certificate = certCopy.Certificate[:len(certCopy.Certificate):len(certCopy.Certificate)]
+	return certCopy, nil
+}
+
+func (m *selfSignedCertManager) HTTPHandler(fallback http.Handler) http.Handler {
+	return fallback
+}
@@ -0,0 +1,268 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/net/stun"
+	"tailscale.com/types/key"
+)
+
+var selfTestFlag = flag.Bool("self-test", false, "start the server on ephemeral loopback ports with a self-signed cert, verify DERP relaying and STUN with loopback clients, then exit 0 on success or 1 on failure")
+
+const selfTestTimeout = 30 * time.Second
+
+// runSelfTest is the entry point for --self-test. It runs an end-to-end
+// smoke test against a throwaway in-process server and returns the
+// process exit code. Intended for packaging smoke tests and image health
+// validation; it touches no on-disk state.
+func runSelfTest() int {
+	if err := selfTest(); err != nil {
+		log.Printf("self-test: FAIL: %v", err)
+		return 1
+	}
+	log.Printf("self-test: OK")
+	return 0
+}
+
+func selfTest() error {
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+
+	// The loopback STUN client's source address is a bogon; don't let
+	// the scanner-noise filter drop it.
+	*stunAllowPrivate = true
+
+	s := derp.NewServer(key.NewNode(), log.Printf)
+	defer s.Close()
+
+	cert, err := selfSignedCert()
+	if err != nil {
+		return fmt.Errorf("generating self-signed cert: %w", err)
+	}
+
+	// TLS listener, checked with a plain handshake below. The DERP
+	// clients go over a second plaintext listener because the client
+	// stack (tlsdial) intentionally refuses certs the system doesn't
+	// trust, which a self-signed throwaway can never satisfy.
+	tlsLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer tlsLn.Close()
+	httpsrv := &http.Server{
+		Handler:   derphttp.Handler(s),
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	go httpsrv.ServeTLS(tlsLn, "", "")
+	defer httpsrv.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	plainsrv := &http.Server{Handler: derphttp.Handler(s)}
+	go plainsrv.Serve(ln)
+	defer plainsrv.Close()
+
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	go serverSTUNListener(ctx, pc)
+
+	if err := selfTestSTUN(ctx, pc.LocalAddr().String()); err != nil {
+		return fmt.Errorf("stun: %w", err)
+	}
+	if err := selfTestTLS(ctx, tlsLn.Addr().String(), cert); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	if err := selfTestDERP(ctx, ln.Addr().String()); err != nil {
+		return fmt.Errorf("derp: %w", err)
+	}
+	return nil
+}
+
+// selfTestSTUN sends one binding request to addr and checks that the
+// response carries our observed address.
+func selfTestSTUN(ctx context.Context, addr string) error {
+	uc, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer uc.Close()
+	if d, ok := ctx.Deadline(); ok {
+		uc.SetDeadline(d)
+	}
+
+	txID := stun.NewTxID()
+	if _, err := uc.Write(stun.Request(txID)); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	n, err := uc.Read(buf)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	gotTx, stunAddr, err := stun.ParseResponse(buf[:n])
+	if err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+	if gotTx != txID {
+		return fmt.Errorf("response txid mismatch")
+	}
+	if !stunAddr.Addr().IsLoopback() {
+		return fmt.Errorf("unexpected observed address %v", stunAddr)
+	}
+	log.Printf("self-test: stun: observed address %v", stunAddr)
+	return nil
+}
+
+// selfTestDERP connects two loopback clients and relays a packet from
+// one to the other through the server.
+func selfTestDERP(ctx context.Context, addr string) error {
+	serverURL := "http://" + addr + "/derp"
+	newClient := func() (*derphttp.Client, key.NodePrivate, error) {
+		priv := key.NewNode()
+		c, err := derphttp.NewClient(priv, serverURL, log.Printf)
+		if err != nil {
+			return nil, priv, err
+		}
+		if err := c.Connect(ctx); err != nil {
+			c.Close()
+			return nil, priv, err
+		}
+		return c, priv, nil
+	}
+
+	sender, _, err := newClient()
+	if err != nil {
+		return fmt.Errorf("connecting sender: %w", err)
+	}
+	defer sender.Close()
+	receiver, receiverPriv, err := newClient()
+	if err != nil {
+		return fmt.Errorf("connecting receiver: %w", err)
+	}
+	defer receiver.Close()
+
+	const payload = "derper self-test packet"
+	recvc := make(chan error, 1)
+	go func() {
+		for {
+			m, err := receiver.Recv()
+			if err != nil {
+				recvc <- err
+				return
+			}
+			if p, ok := m.(derp.ReceivedPacket); ok {
+				if !bytes.Equal(p.Data, []byte(payload)) {
+					recvc <- fmt.Errorf("payload mismatch: got %q", p.Data)
+					return
+				}
+				recvc <- nil
+				return
+			}
+		}
+	}()
+
+	// The client handshake doesn't wait for server-side registration,
+	// so an immediate single send can race it and get dropped as an
+	// unknown destination. Resend until the packet comes through.
+	if err := sender.Send(receiverPriv.Public(), []byte(payload)); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	resend := time.NewTicker(100 * time.Millisecond)
+	defer resend.Stop()
+	for done := false; !done; {
+		select {
+		case err := <-recvc:
+			if err != nil {
+				return fmt.Errorf("recv: %w", err)
+			}
+			done = true
+		case <-resend.C:
+			if err := sender.Send(receiverPriv.Public(), []byte(payload)); err != nil {
+				return fmt.Errorf("send: %w", err)
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for relayed packet")
+		}
+	}
+	log.Printf("self-test: derp: relayed %d bytes between two clients", len(payload))
+	return nil
+}
+
+// selfTestTLS performs a TLS handshake against the HTTPS listener,
+// verifying the chain against the just-generated cert.
+func selfTestTLS(ctx context.Context, addr string, cert tls.Certificate) error {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	tc := tls.Client(nc, &tls.Config{
+		ServerName: "127.0.0.1",
+		RootCAs:    roots,
+	})
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+	log.Printf("self-test: tls: handshake completed, %s", tls.VersionName(tc.ConnectionState().Version))
+	return nil
+}
+
+// selfSignedCert generates a throwaway certificate for 127.0.0.1, good
+// only for the lifetime of the self-test.
+func selfSignedCert() (tls.Certificate, error) {
+	priv, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "derper-self-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(crand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+	}, nil
+}
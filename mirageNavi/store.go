@@ -0,0 +1,158 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var storeSpec = flag.String("store", "", `where to persist traffic and audit records: "" (none), "file:<dir>" (JSON lines, one file per day), or an http(s):// URL records are POSTed to`)
+
+// Record is one accounting or audit entry written to the configured
+// Store.
+type Record struct {
+	Time time.Time       `json:"time"`
+	Type string          `json:"type"`           // e.g. "audit", "traffic"
+	Node string          `json:"node,omitempty"` // subject node key, if any
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Store persists accounting/audit records. Implementations must be safe
+// for concurrent use. Writes are best effort: callers log failures but
+// never block relay operation on them.
+type Store interface {
+	WriteRecord(ctx context.Context, rec Record) error
+	Close() error
+}
+
+// openStore returns the Store for a --store value. An empty spec
+// returns a no-op store, so call sites never need a nil check.
+func openStore(spec string) (Store, error) {
+	switch {
+	case spec == "":
+		return noopStore{}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return newFileStore(strings.TrimPrefix(spec, "file:"))
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &httpStore{url: spec, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unsupported store %q", spec)
+}
+
+// noopStore discards all records; it's the default.
+type noopStore struct{}
+
+func (noopStore) WriteRecord(context.Context, Record) error { return nil }
+func (noopStore) Close() error                              { return nil }
+
+// fileStore appends records as JSON lines to one file per day in a
+// directory, so retention is a matter of deleting old files.
+type fileStore struct {
+	dir string
+
+	mu      sync.Mutex
+	day     string // "20060102" of the open file
+	f       *os.File
+	enc     *json.Encoder
+	lastErr error
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file store: empty directory")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (fs *fileStore) WriteRecord(_ context.Context, rec Record) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	day := rec.Time.UTC().Format("20060102")
+	if fs.f == nil || day != fs.day {
+		if fs.f != nil {
+			fs.f.Close()
+		}
+		f, err := os.OpenFile(filepath.Join(fs.dir, "records-"+day+".json"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		fs.f, fs.enc, fs.day = f, json.NewEncoder(f), day
+	}
+	return fs.enc.Encode(rec)
+}
+
+func (fs *fileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil {
+		return nil
+	}
+	err := fs.f.Close()
+	fs.f = nil
+	return err
+}
+
+// httpStore POSTs each record as JSON to a collector URL.
+type httpStore struct {
+	url    string
+	client *http.Client
+}
+
+func (hs *httpStore) WriteRecord(ctx context.Context, rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", hs.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := hs.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("store: collector returned %v", res.Status)
+	}
+	return nil
+}
+
+func (hs *httpStore) Close() error { return nil }
+
+// recordStore is the process-wide store, a no-op until main opens the
+// configured one.
+var recordStore Store = noopStore{}
+
+// writeAuditRecord is a convenience wrapper for writing an audit event.
+func writeAuditRecord(event string, detail any) error {
+	data, err := json.Marshal(struct {
+		Event  string `json:"event"`
+		Detail any    `json:"detail,omitempty"`
+	}{event, detail})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return recordStore.WriteRecord(ctx, Record{
+		Time: time.Now(),
+		Type: "audit",
+		Data: data,
+	})
+}
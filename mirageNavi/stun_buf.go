@@ -0,0 +1,19 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import "sync"
+
+// stunBufPool supplies read buffers for the STUN loop. STUN binding
+// requests fit in a single MTU, so a dedicated 64KB buffer per listener is
+// mostly wasted; pooling also lets multiple listeners share buffers. The
+// buffer size is architecture-dependent (see stunBufSize): the small ARM
+// and RISC-V boards used as edge relays get smaller buffers to keep GC
+// pressure down.
+var stunBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, stunBufSize)
+		return &b
+	},
+}
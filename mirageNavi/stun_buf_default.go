@@ -0,0 +1,10 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !arm && !arm64 && !riscv64
+
+package main
+
+// stunBufSize is the STUN read buffer size on server-class architectures,
+// matching the historical 64KB stack buffer.
+const stunBufSize = 64 << 10
@@ -0,0 +1,12 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build arm || arm64 || riscv64
+
+package main
+
+// stunBufSize is the STUN read buffer size on memory-constrained
+// architectures. Any packet a real STUN client sends fits in one MTU, so
+// 8KB leaves ample headroom while keeping per-buffer cost low on ARM SBCs
+// and RISC-V boards.
+const stunBufSize = 8 << 10
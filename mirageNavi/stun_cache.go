@@ -0,0 +1,78 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/net/stun"
+)
+
+const (
+	// stunCacheTTL is how long a computed binding response stays
+	// valid for replays of the same request.
+	stunCacheTTL = 3 * time.Second
+	// stunCacheMax bounds the number of cached responses so a flood
+	// of unique sources can't grow the map without limit.
+	stunCacheMax = 4096
+)
+
+type stunCacheKey struct {
+	txid stun.TxID
+	ap   netip.AddrPort
+}
+
+type stunCacheEntry struct {
+	res     []byte
+	expires time.Time
+}
+
+// stunCache memoizes STUN binding responses keyed by transaction ID and
+// source address, so floods replaying the same binding request don't
+// recompute the response per packet.
+type stunCache struct {
+	mu sync.Mutex
+	m  map[stunCacheKey]stunCacheEntry
+}
+
+func newSTUNCache() *stunCache {
+	return &stunCache{m: make(map[stunCacheKey]stunCacheEntry)}
+}
+
+// get returns the cached response for k, or nil.
+func (c *stunCache) get(k stunCacheKey) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.m[k]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expires) {
+		delete(c.m, k)
+		return nil
+	}
+	return e.res
+}
+
+func (c *stunCache) put(k stunCacheKey, res []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.m) >= stunCacheMax {
+		// Evict expired entries first; if none are expired the
+		// map is cleared entirely, which is cheaper than
+		// tracking LRU order for what is only an optimization.
+		now := time.Now()
+		for k, e := range c.m {
+			if now.After(e.expires) {
+				delete(c.m, k)
+			}
+		}
+		if len(c.m) >= stunCacheMax {
+			c.m = make(map[stunCacheKey]stunCacheEntry)
+		}
+	}
+	c.m[k] = stunCacheEntry{res: res, expires: time.Now().Add(stunCacheTTL)}
+}
@@ -0,0 +1,30 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package main
+
+import "net"
+
+// stunWriter writes STUN responses back to clients. On this platform
+// each response is a single sendto; batching is only implemented on
+// Linux where sendmmsg is available.
+type stunWriter struct {
+	pc *net.UDPConn
+}
+
+func newSTUNWriter(pc *net.UDPConn) *stunWriter {
+	return &stunWriter{pc: pc}
+}
+
+// send writes res to ua and updates the STUN write counters.
+func (w *stunWriter) send(res []byte, ua *net.UDPAddr) {
+	if _, err := w.pc.WriteTo(res, ua); err != nil {
+		stunWriteError.Add(1)
+	} else {
+		stunSuccess.Add(1)
+	}
+}
+
+func (w *stunWriter) close() {}
@@ -0,0 +1,105 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// stunBatchSize is how many responses are coalesced into one sendmmsg
+// call before a flush is forced.
+const stunBatchSize = 32
+
+// stunFlushInterval bounds the extra latency batching may add to a
+// response at low QPS.
+const stunFlushInterval = time.Millisecond
+
+// batchConn is the part of ipv4.PacketConn and ipv6.PacketConn the
+// writer needs. ipv4.Message and ipv6.Message are the same underlying
+// type, so one slice serves both.
+type batchConn interface {
+	WriteBatch(ms []ipv6.Message, flags int) (int, error)
+}
+
+// stunWriter batches STUN responses and flushes them with sendmmsg,
+// cutting per-response syscall overhead at high QPS. A background
+// flusher keeps latency bounded when traffic is light.
+type stunWriter struct {
+	bc   batchConn
+	done chan struct{}
+
+	mu   sync.Mutex
+	msgs []ipv6.Message
+}
+
+func newSTUNWriter(pc *net.UDPConn) *stunWriter {
+	var bc batchConn
+	if la, ok := pc.LocalAddr().(*net.UDPAddr); ok && la.IP.To4() != nil {
+		bc = ipv4.NewPacketConn(pc)
+	} else {
+		bc = ipv6.NewPacketConn(pc)
+	}
+	w := &stunWriter{
+		bc:   bc,
+		done: make(chan struct{}),
+		msgs: make([]ipv6.Message, 0, stunBatchSize),
+	}
+	go w.flushLoop()
+	return w
+}
+
+// send queues res for delivery to ua, flushing if the batch is full.
+func (w *stunWriter) send(res []byte, ua *net.UDPAddr) {
+	w.mu.Lock()
+	w.msgs = append(w.msgs, ipv6.Message{
+		Buffers: [][]byte{res},
+		Addr:    ua,
+	})
+	full := len(w.msgs) >= stunBatchSize
+	if full {
+		w.flushLocked()
+	}
+	w.mu.Unlock()
+}
+
+func (w *stunWriter) flushLoop() {
+	ticker := time.NewTicker(stunFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			w.flushLocked()
+			w.mu.Unlock()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *stunWriter) flushLocked() {
+	ms := w.msgs
+	for len(ms) > 0 {
+		n, err := w.bc.WriteBatch(ms, 0)
+		if err != nil {
+			stunWriteError.Add(int64(len(ms)))
+			break
+		}
+		stunSuccess.Add(int64(n))
+		ms = ms[n:]
+	}
+	w.msgs = w.msgs[:0]
+}
+
+func (w *stunWriter) close() {
+	close(w.done)
+	w.mu.Lock()
+	w.flushLocked()
+	w.mu.Unlock()
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/tls"
+	"expvar"
+	"flag"
+	"fmt"
+
+	"tailscale.com/metrics"
+)
+
+var (
+	minTLSVersion = flag.String("min-tls", "1.2", "minimum TLS version to accept: 1.2 or 1.3")
+	cipherPolicy  = flag.String("cipher-policy", "compat", "TLS 1.2 cipher suite policy: modern, intermediate, or compat (Go defaults)")
+)
+
+// tlsWouldBreak counts handshakes that succeeded under the running
+// policy but would have been rejected by a stricter one, so operators
+// can gauge breakage before tightening --min-tls/--cipher-policy.
+var tlsWouldBreak = &metrics.LabelMap{Label: "policy"}
+
+func init() {
+	expvar.Publish("derper_tls_would_break", tlsWouldBreak)
+}
+
+// modernCipherSuites are the TLS 1.2 ECDHE+AEAD suites allowed by the
+// "modern" policy. TLS 1.3 suites are not configurable and always
+// allowed.
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// intermediateCipherSuites additionally allow ECDHE+CBC for older
+// clients that can't do AEAD suites.
+var intermediateCipherSuites = append(modernCipherSuites[:len(modernCipherSuites):len(modernCipherSuites)],
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+)
+
+// applyTLSPolicy configures cfg's minimum version and cipher suites
+// from the --min-tls and --cipher-policy flags.
+func applyTLSPolicy(cfg *tls.Config) error {
+	switch *minTLSVersion {
+	case "1.2":
+		cfg.MinVersion = tls.VersionTLS12
+	case "1.3":
+		cfg.MinVersion = tls.VersionTLS13
+	default:
+		return fmt.Errorf("invalid --min-tls %q; must be 1.2 or 1.3", *minTLSVersion)
+	}
+	switch *cipherPolicy {
+	case "modern":
+		cfg.CipherSuites = modernCipherSuites
+	case "intermediate":
+		cfg.CipherSuites = intermediateCipherSuites
+	case "compat":
+		// Leave Go's defaults in place.
+	default:
+		return fmt.Errorf("invalid --cipher-policy %q; must be modern, intermediate, or compat", *cipherPolicy)
+	}
+	return nil
+}
+
+// noteTLSPolicyImpact records whether cs would have been rejected under
+// stricter settings than the ones in effect.
+func noteTLSPolicyImpact(cs *tls.ConnectionState) {
+	if cs.Version < tls.VersionTLS13 {
+		tlsWouldBreak.Add("min_tls_1.3", 1)
+	}
+	if cs.Version == tls.VersionTLS12 {
+		modern := false
+		for _, id := range modernCipherSuites {
+			if cs.CipherSuite == id {
+				modern = true
+				break
+			}
+		}
+		if !modern {
+			tlsWouldBreak.Add("cipher_modern", 1)
+		}
+	}
+}
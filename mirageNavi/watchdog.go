@@ -0,0 +1,115 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"expvar"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+)
+
+var (
+	watchdogThreshold = flag.Duration("watchdog-threshold", 5*time.Second, "scheduling latency or accept loop stall above which the watchdog reports a stall")
+	watchdogDumpDir   = flag.String("watchdog-dump-dir", "", "if non-empty, directory to write a goroutine dump into when the watchdog detects a stall")
+)
+
+// watchdogInterval is how often the watchdog wakes up to take measurements.
+const watchdogInterval = time.Second
+
+// watchdogDumpMinInterval limits how often goroutine dumps are written, so a
+// long-lasting stall doesn't fill the disk.
+const watchdogDumpMinInterval = time.Minute
+
+var (
+	watchdogSchedLatency  expvar.Int // gauge: milliseconds the last ticker wakeup was late
+	watchdogAcceptBlocked expvar.Int // gauge: milliseconds the serve loop has been away from Accept
+	watchdogStalls        expvar.Int
+)
+
+func init() {
+	expvar.Publish("gauge_watchdog_sched_latency_ms", &watchdogSchedLatency)
+	expvar.Publish("gauge_watchdog_accept_blocked_ms", &watchdogAcceptBlocked)
+	expvar.Publish("counter_watchdog_stalls", &watchdogStalls)
+}
+
+// runWatchdog periodically measures how late its own ticker wakeups are
+// (scheduling latency) and how long the HTTP serve loop has been away from
+// Accept. Both are exported as gauges; when either exceeds
+// --watchdog-threshold the stall is logged and, if --watchdog-dump-dir is
+// set, a goroutine dump is written there for later debugging.
+//
+// It runs until shutdown is closed.
+func runWatchdog(shutdown chan struct{}) {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	var lastDump time.Time
+	for {
+		select {
+		case <-shutdown:
+			return
+		case now := <-ticker.C:
+			stalled := false
+
+			lat := now.Sub(last) - watchdogInterval
+			if lat < 0 {
+				lat = 0
+			}
+			last = now
+			watchdogSchedLatency.Set(lat.Milliseconds())
+			if lat > *watchdogThreshold {
+				stalled = true
+				log.Printf("derper: watchdog: scheduling latency %v exceeds threshold %v", lat.Round(time.Millisecond), *watchdogThreshold)
+			}
+
+			// An accept loop that returned from Accept and hasn't
+			// come back is blocked somewhere in conn setup.
+			// Being parked inside Accept waiting for clients is
+			// the normal idle state and doesn't count.
+			var blocked time.Duration
+			if rln := activeListener.Load(); rln != nil && !rln.acceptActive.Load() {
+				if lastRet := rln.lastAcceptReturn.Load(); lastRet != 0 {
+					blocked = now.Sub(time.Unix(0, lastRet))
+				}
+			}
+			if blocked < 0 {
+				blocked = 0
+			}
+			watchdogAcceptBlocked.Set(blocked.Milliseconds())
+			if blocked > *watchdogThreshold {
+				stalled = true
+				log.Printf("derper: watchdog: accept loop blocked for %v (threshold %v)", blocked.Round(time.Millisecond), *watchdogThreshold)
+			}
+
+			if stalled {
+				watchdogStalls.Add(1)
+				if *watchdogDumpDir != "" && now.Sub(lastDump) > watchdogDumpMinInterval {
+					lastDump = now
+					writeGoroutineDump(now)
+				}
+			}
+		}
+	}
+}
+
+// writeGoroutineDump writes a goroutine dump into --watchdog-dump-dir,
+// named after the time of the stall.
+func writeGoroutineDump(now time.Time) {
+	name := filepath.Join(*watchdogDumpDir, "derper-stall-"+now.Format("20060102-150405")+".txt")
+	f, err := os.Create(name)
+	if err != nil {
+		log.Printf("derper: watchdog: can't write goroutine dump: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := pprof.Lookup("goroutine").WriteTo(f, 1); err != nil {
+		log.Printf("derper: watchdog: goroutine dump failed: %v", err)
+		return
+	}
+	log.Printf("derper: watchdog: wrote goroutine dump to %s", name)
+}
@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 
 	"nhooyr.io/websocket"
 	"tailscale.com/derp"
@@ -17,6 +18,15 @@ import (
 
 var counterWebSocketAccepts = expvar.NewInt("derp_websocket_accepts")
 
+// bufio read/write buffers for websocket DERP connections are pooled
+// across connections to cut per-connection allocation churn.
+var (
+	counterWebSocketBufioReuse = expvar.NewInt("derp_websocket_bufio_reuse")
+
+	wsReaderPool sync.Pool // of *bufio.Reader
+	wsWriterPool sync.Pool // of *bufio.Writer
+)
+
 // addWebSocketSupport returns a Handle wrapping base that adds WebSocket server support.
 func addWebSocketSupport(s *derp.Server, base http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -51,7 +61,28 @@ func addWebSocketSupport(s *derp.Server, base http.Handler) http.Handler {
 		}
 		counterWebSocketAccepts.Add(1)
 		wc := wsconn.NetConn(r.Context(), c, websocket.MessageBinary)
-		brw := bufio.NewReadWriter(bufio.NewReader(wc), bufio.NewWriter(wc))
+		br, _ := wsReaderPool.Get().(*bufio.Reader)
+		bw, _ := wsWriterPool.Get().(*bufio.Writer)
+		if br != nil && bw != nil {
+			counterWebSocketBufioReuse.Add(1)
+		}
+		if br == nil {
+			br = bufio.NewReader(wc)
+		} else {
+			br.Reset(wc)
+		}
+		if bw == nil {
+			bw = bufio.NewWriter(wc)
+		} else {
+			bw.Reset(wc)
+		}
+		brw := bufio.NewReadWriter(br, bw)
 		s.Accept(r.Context(), wc, brw, r.RemoteAddr)
+		// Accept runs the connection to completion, so the buffers are
+		// free to be reused now.
+		br.Reset(nil)
+		bw.Reset(nil)
+		wsReaderPool.Put(br)
+		wsWriterPool.Put(bw)
 	})
 }
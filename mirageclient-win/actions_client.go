@@ -1,4 +1,5 @@
 //go:build windows
+
 package main
 
 import (
@@ -11,13 +12,13 @@ import (
 func (m *MiraMenu) kickLogin() {
 	prefs := m.createPref()
 	if err := m.lc.CheckPrefs(m.ctx, prefs); err != nil {
-		go m.SendNotify("Pref出错", err.Error(), NL_Error)
+		go m.SendNotify("Pref出错", humanizeErr(err), NL_Error)
 	}
 	if err := m.lc.Start(m.ctx, ipn.Options{
 		AuthKey:     m.data.AuthKey,
 		UpdatePrefs: prefs,
 	}); err != nil {
-		go m.SendNotify("无法开始", err.Error(), NL_Error)
+		go m.SendNotify("无法开始", humanizeErr(err), NL_Error)
 	}
 }
 
@@ -48,19 +49,24 @@ func (m *MiraMenu) createPref() *ipn.Prefs {
 func (m *MiraMenu) updatePref(desc string, newPrefs *ipn.MaskedPrefs) {
 	curPrefs, err := m.lc.GetPrefs(m.ctx)
 	if err != nil {
-		go m.SendNotify(desc, "更新Pref出错:"+err.Error(), NL_Error)
+		go m.SendNotify(desc, "更新Pref出错:"+humanizeErr(err), NL_Error)
 		return
 	}
 
 	checkPrefs := curPrefs.Clone()
 	checkPrefs.ApplyEdits(newPrefs)
 	if err := m.lc.CheckPrefs(m.ctx, checkPrefs); err != nil {
-		go m.SendNotify(desc, "Pref检查出错:"+err.Error(), NL_Error)
+		go m.SendNotify(desc, "Pref检查出错:"+humanizeErr(err), NL_Error)
 		return
 	}
 
 	if _, err := m.lc.EditPrefs(m.ctx, newPrefs); err != nil {
-		go m.SendNotify(desc, "设置Pref出错:"+err.Error(), NL_Error)
+		go m.SendNotify(desc, "设置Pref出错:"+humanizeErr(err), NL_Error)
 		return
 	}
+	if isRiskyPrefChange(newPrefs) {
+		// 高风险变更（出口节点、子网路由、DNS）应用后检验连通性，
+		// 失联则自动回滚
+		go m.watchPrefCommit(desc, rollbackMaskFor(newPrefs, curPrefs))
+	}
 }
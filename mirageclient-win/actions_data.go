@@ -17,6 +17,10 @@ import (
 )
 
 // 处理接收到的通讯兵消息
+// largeNetmapMenuThreshold 是改用设备列表窗口替代逐节点子菜单的
+// 网内设备数量阈值；Win32菜单没有虚拟化，几百项的构建会冻结托盘
+const largeNetmapMenuThreshold = 100
+
 func (s *MiraMenu) handleRx() {
 	var newMsg interface{}
 	for {
@@ -26,7 +30,7 @@ func (s *MiraMenu) handleRx() {
 			switch newMsg.(type) {
 			case error: // 遇到通讯兵无法恢复严重错误崩溃，导致程序只能由用户选择重启动通讯员或者退出程序
 				go func(msg string) {
-					confirm := PopConfirmDlg("严重错误", "程序通讯报错:"+msg+" 无法执行，是否重试？", 300, 150)
+					confirm := PopConfirmDlg("严重错误", "程序通讯报错:"+humanizeErrMsg(msg)+" 无法执行，是否重试？", 300, 150)
 					if confirm {
 						go s.startWatch(s.ctx, s.lc)
 						return
@@ -45,6 +49,12 @@ func (s *MiraMenu) handleRx() {
 				s.data.SetPrefs(newMsg.(*ipn.Prefs))
 			case *netmap.NetworkMap:
 				s.data.SetNetMap(newMsg.(*netmap.NetworkMap))
+			case *DaemonUnresponsiveEvent: // 心跳探测认定守护进程失联
+				s.data.SetState(ipn.NoState.String())
+				s.SendNotify("后台服务无响应",
+					"与后台服务的通讯已静默且探测无响应，显示的连接状态可能已过期，请尝试“配置项→重启后台服务”", NL_Warn)
+				go s.relayToCompanions("后台服务无响应",
+					"该设备的后台服务心跳探测无响应，连接可能已异常，请留意", NL_Warn)
 			}
 		}
 		s.rcvdRx.Publish(newMsg)
@@ -56,6 +66,12 @@ func (m *MiraMenu) bindStateChange() {
 	m.data.StateChanged().Attach(func(data interface{}) {
 		m.ChangeIconDueRunState()
 		state := data.(ipn.State)
+		m.noteStateForRemoval(m.lastState, state)
+		m.lastState = state
+		if state == ipn.NeedsLogin {
+			// 策略预配了认证密钥时自动重新认证（kiosk场景）
+			go m.tryAutoRelogin()
+		}
 		switch ipn.State(state) {
 		case ipn.Stopped:
 			m.connectField.connectAction.SetText("连接")
@@ -63,6 +79,7 @@ func (m *MiraMenu) bindStateChange() {
 			m.connectField.connectAction.SetVisible(true)
 			m.connectField.disconnectAction.SetVisible(false)
 			m.connectField.loginAction.SetVisible(false)
+			m.connectField.reopenLoginAction.SetVisible(false)
 
 			m.userField.userMenu.SetVisible(true)
 
@@ -81,6 +98,7 @@ func (m *MiraMenu) bindStateChange() {
 			m.connectField.disconnectAction.SetEnabled(true)
 			m.connectField.disconnectAction.SetVisible(true)
 			m.connectField.loginAction.SetVisible(false)
+			m.connectField.reopenLoginAction.SetVisible(false)
 
 			m.userField.userMenu.SetVisible(true)
 
@@ -99,11 +117,14 @@ func (m *MiraMenu) bindStateChange() {
 			m.connectField.disconnectAction.SetEnabled(true)
 			m.connectField.disconnectAction.SetVisible(true)
 			m.connectField.loginAction.SetVisible(false)
+			m.connectField.reopenLoginAction.SetVisible(false)
 
 			m.userField.userMenu.SetVisible(true)
 
 			m.nodeField.nodeAction.SetEnabled(true)
 			m.nodeField.nodeAction.SetVisible(true)
+			m.nodeField.copyAddrMenu.SetVisible(true)
+			m.nodeField.relayMenu.SetVisible(true)
 			m.nodeField.nodesMenu.SetEnabled(true)
 			m.nodeField.nodesMenu.SetVisible(true)
 
@@ -113,10 +134,13 @@ func (m *MiraMenu) bindStateChange() {
 			m.connectField.connectAction.SetVisible(false)
 			m.connectField.disconnectAction.SetVisible(false)
 			m.connectField.loginAction.SetVisible(true)
+			m.connectField.reopenLoginAction.SetVisible(true)
 
 			m.userField.userMenu.SetVisible(false)
 
 			m.nodeField.nodeAction.SetVisible(false)
+			m.nodeField.copyAddrMenu.SetVisible(false)
+			m.nodeField.relayMenu.SetVisible(false)
 			m.nodeField.nodesMenu.SetVisible(false)
 
 			m.exitField.exitNodeMenu.SetVisible(false)
@@ -163,6 +187,7 @@ func (m *MiraMenu) bindNetMapChange() {
 		}
 		selfName := netmap.SelfNode.DisplayName(true)
 		m.nodeField.nodeAction.SetText("本设备: " + selfName + " (" + selfIPv4.String() + ")")
+		m.rebuildRelayMenu()
 		// 清理节点菜单区
 		m.nodeField.nodesMenu.Menu().Actions().Clear()
 		myNodeContain, err := walk.NewMenu()
@@ -191,24 +216,28 @@ func (m *MiraMenu) bindNetMapChange() {
 			delete(m.exitField.exitNodeIDMap, sni)
 		}
 
+		// 网络图很大时逐节点构建子菜单会卡住托盘好几秒，改为只留
+		// 设备列表窗口入口（TableView虚拟列表），出口节点菜单照常
+		largeNetmap := len(netmap.Peers) >= largeNetmapMenuThreshold
+		if largeNetmap {
+			log.Printf("网内设备%d个，超过菜单上限%d，改用设备列表窗口", len(netmap.Peers), largeNetmapMenuThreshold)
+		}
+
 		// 生成节点及出口菜单区
+		deviceMeta := loadDeviceMeta()
 		for _, node := range netmap.Peers {
 			name, hostname := node.DisplayNames(true)
 			if hostname != "" && hostname != name {
 				name += "(" + hostname + ")"
 			}
+			if nick := deviceMeta.Nicknames[string(node.StableID)]; nick != "" {
+				name = nick + "（" + name + "）"
+			}
 			ip := node.Addresses[0].Addr()
 			if !ip.Is4() {
 				ip = node.Addresses[1].Addr()
 			}
 
-			tmpNodeAction := walk.NewAction()
-			tmpNodeAction.SetText(name)
-			tmpNodeAction.Triggered().Attach(func() {
-				walk.Clipboard().SetText(ip.String())
-				go m.SendNotify(name, "已复制节点IP("+ip.String()+")到剪贴板", NL_Info)
-			})
-
 			if tsaddr.ContainsExitRoutes(node.AllowedIPs) { // 是出口节点
 				tmpExitNodeAction := walk.NewAction()
 				tmpExitNodeAction.SetText(name)
@@ -225,6 +254,17 @@ func (m *MiraMenu) bindNetMapChange() {
 				m.exitField.exitNodeMenu.Menu().Actions().Insert(m.exitField.exitNodeList.Len(), tmpExitNodeAction)
 			}
 
+			if largeNetmap {
+				continue
+			}
+
+			tmpNodeAction := walk.NewAction()
+			tmpNodeAction.SetText(name)
+			tmpNodeAction.Triggered().Attach(func() {
+				walk.Clipboard().SetText(ip.String())
+				go m.SendNotify(name, "已复制节点IP("+ip.String()+")到剪贴板", NL_Info)
+			})
+
 			if node.Tags != nil { // 有标签的节点
 				tagNodeMenu.Menu().Actions().Add(tmpNodeAction)
 			} else if node.User == netmap.SelfNode.User && node.ID != netmap.SelfNode.ID { // 本用户节点
@@ -287,10 +327,15 @@ func (m *MiraMenu) bindNetMapChange() {
 			peerMenuList.Add(tagNodeMenu)
 			m.nodeField.nodesMenu.Menu().Actions().Add(tagNodeMenu)
 		}
-		if m.nodeField.nodesMenu.Menu().Actions().Len() > 0 { // 有节点
+		if largeNetmap { // 大网络图只留设备列表窗口入口
+			m.nodeField.nodesMenu.SetVisible(false)
+			m.nodeField.deviceListAction.SetVisible(true)
+		} else if m.nodeField.nodesMenu.Menu().Actions().Len() > 0 { // 有节点
 			m.nodeField.nodesMenu.SetVisible(true)
+			m.nodeField.deviceListAction.SetVisible(true)
 		} else { // 无节点
 			m.nodeField.nodesMenu.SetVisible(false)
+			m.nodeField.deviceListAction.SetVisible(false)
 		}
 
 		if m.exitField.exitNodeList.Len() > 0 { // 有出口节点
@@ -316,6 +361,8 @@ func (m *MiraMenu) bindNetMapChange() {
 		lastDays := ""
 		if !netmap.SelfNode.KeyExpiry.IsZero() && !netmap.SelfNode.KeyExpiry.After(time.Now().AddDate(0, 0, 7)) {
 			lastDays = strings.TrimSuffix((netmap.SelfNode.KeyExpiry.Sub(time.Now()) / time.Duration(time.Hour*24)).String(), "ns")
+			go m.relayToCompanions("设备密钥临期",
+				"该设备密钥还有"+lastDays+"天过期，请尽快在该设备上登录延期", NL_Warn)
 			go func(lastDays string) {
 				confirm := PopConfirmDlg("临期设备延期提醒", "该设备密钥还有"+lastDays+"天过期，是否现在进行登录延期（将轮换新设备密钥）", 300, 150)
 				if confirm {
@@ -330,4 +377,10 @@ func (m *MiraMenu) bindDataPool() {
 	m.bindStateChange()
 	m.bindPrefsChange()
 	m.bindNetMapChange()
+
+	// 可插拔菜单区统一由数据变化驱动刷新
+	refresh := func(interface{}) { m.refreshFields() }
+	m.data.StateChanged().Attach(refresh)
+	m.data.PrefsChanged().Attach(refresh)
+	m.data.NetmapChanged().Attach(refresh)
 }
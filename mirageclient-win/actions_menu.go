@@ -48,18 +48,21 @@ func (m *MiraMenu) DoDisconn() {
 
 // 登出动作
 func (m *MiraMenu) DoLogout() {
+	userInitiatedLogout.Store(true)
 	err := m.lc.Logout(m.ctx)
 	if err != nil {
-		go m.SendNotify("登出出错", err.Error(), NL_Error)
+		go m.SendNotify("登出出错", humanizeErr(err), NL_Error)
 		return
 	}
 }
 
 // 登录动作
 func (m *MiraMenu) DoLogin() {
+	suppressAutoLoginOpen.Store(false) // 用户主动登录，恢复自动打开登录URL
+	resetLoginURL()                    // 新一轮认证的URL应再次自动打开
 	serverCodeData, err := m.lc.GetStore(m.ctx, string(ipn.CurrentServerCodeKey))
 	if err != nil && !strings.Contains(err.Error(), ipn.ErrStateNotExist.Error()) {
-		go m.SendNotify("读取服务器代码出错", err.Error(), NL_Error)
+		go m.SendNotify("读取服务器代码出错", humanizeErr(err), NL_Error)
 	} else if err != nil && strings.Contains(err.Error(), ipn.ErrStateNotExist.Error()) || serverCodeData == nil || string(serverCodeData) == "" {
 		m.tray.SetVisible(false)
 		confirm, newServerCode := PopTextInputDlg("初始化", "请输入您接入的控制器代码（留空默认）:")
@@ -71,7 +74,7 @@ func (m *MiraMenu) DoLogin() {
 			}
 			err := m.lc.SetStore(m.ctx, string(ipn.CurrentServerCodeKey), newServerCode)
 			if err != nil {
-				go m.SendNotify("设置服务器代码出错", err.Error(), NL_Error)
+				go m.SendNotify("设置服务器代码出错", humanizeErr(err), NL_Error)
 				return
 			}
 			m.control_url = "https://sdp." + newServerCode
@@ -80,9 +83,10 @@ func (m *MiraMenu) DoLogin() {
 			}
 			st, err := m.lc.Status(m.ctx)
 			if err != nil {
-				go m.SendNotify("获取状态出错", err.Error(), NL_Error)
+				go m.SendNotify("获取状态出错", humanizeErr(err), NL_Error)
 				return
 			}
+			go m.checkTimeSkew()
 			if st.BackendState != "Running" {
 				m.kickLogin()
 			}
@@ -97,9 +101,10 @@ func (m *MiraMenu) DoLogin() {
 
 		st, err := m.lc.Status(m.ctx)
 		if err != nil {
-			go m.SendNotify("获取状态出错", err.Error(), NL_Error)
+			go m.SendNotify("获取状态出错", humanizeErr(err), NL_Error)
 			return
 		}
+		go m.checkTimeSkew()
 		if st.BackendState != "Running" {
 			m.kickLogin()
 		}
@@ -117,6 +122,9 @@ func (m *MiraMenu) ShowAbout() {
 		if m.data.ClientVersion.LatestVersion == m.data.Version {
 			msg += "\n已是最新版本"
 		} else if m.data.ClientVersion.LatestVersion != "" {
+			if advice := archAdvice(); advice != "" {
+				msg += "\n" + advice
+			}
 			msg += "\n官方最新版本：" + m.data.ClientVersion.LatestVersion + "\n是否下载？"
 			msgid := walk.MsgBox(m.mw, "关于蜃境", msg, walk.MsgBoxIconInformation|walk.MsgBoxOKCancel)
 			if msgid == 1 {
@@ -184,6 +192,14 @@ func (m *MiraMenu) SetUnattendOpt() {
 	m.updatePref("设置无人值守", maskedPrefs)
 }
 
+// SetCompanionRelayOpt 切换"转发重要通知到其他设备"。本地设置，
+// 不经守护进程，立即生效并持久化。
+func (m *MiraMenu) SetCompanionRelayOpt() {
+	newV := m.prefField.companionRelayAction.Checked()
+	m.setCompanionRelay(newV)
+	m.prefField.companionRelayAction.SetChecked(newV)
+}
+
 // SetPrefsDefault 恢复为默认配置
 func (m *MiraMenu) SetPrefsDefault() {
 	maskedPrefs := &ipn.MaskedPrefs{
@@ -226,7 +242,7 @@ func (m *MiraMenu) SetAsExitNode() {
 		}
 		st, err := m.lc.Status(m.ctx)
 		if err != nil {
-			go m.SendNotify("[设置为出口节点] 获取状态出错", err.Error(), NL_Error)
+			go m.SendNotify("[设置为出口节点] 获取状态出错", humanizeErr(err), NL_Error)
 			return // err
 		}
 		if st.ExitNodeStatus != nil {
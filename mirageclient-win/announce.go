@@ -0,0 +1,165 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tailscale/walk"
+	"github.com/tailscale/win"
+)
+
+// announcement 是控制器推送的一条公告（维护通知、策略变更等）。
+type announcement struct {
+	ID    string    `json:"id"`
+	Title string    `json:"title"`
+	Body  string    `json:"body"`
+	Time  time.Time `json:"time"`
+}
+
+// announceHistory 是本地保存的公告历史，用于去重和历史查看。
+type announceHistory struct {
+	Seen  map[string]time.Time `json:"seen"`
+	Items []announcement       `json:"items"`
+}
+
+// announcePollInterval 是向控制器轮询公告的间隔。
+const announcePollInterval = 15 * time.Minute
+
+// announceHistoryLimit 是本地保留的公告条数上限。
+const announceHistoryLimit = 50
+
+func announceHistoryPath() string {
+	return filepath.Join(programPath, "announcements.json")
+}
+
+func loadAnnounceHistory() *announceHistory {
+	h := &announceHistory{Seen: map[string]time.Time{}}
+	b, err := os.ReadFile(announceHistoryPath())
+	if err == nil {
+		json.Unmarshal(b, h)
+	}
+	if h.Seen == nil {
+		h.Seen = map[string]time.Time{}
+	}
+	return h
+}
+
+func (h *announceHistory) save() {
+	b, err := json.MarshalIndent(h, "", "\t")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(announceHistoryPath(), b, 0600); err != nil {
+		log.Printf("[公告] 保存历史记录失败: %v", err)
+	}
+}
+
+// watchAnnouncements 周期性从控制器拉取公告，新公告以可关闭的通知
+// 弹出并写入本地历史。控制器未配置或不提供公告接口时静默退出本轮。
+func (m *MiraMenu) watchAnnouncements() {
+	ticker := time.NewTicker(announcePollInterval)
+	defer ticker.Stop()
+	for {
+		m.pollAnnouncements()
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			ticker.Reset(powerAwareInterval(announcePollInterval))
+		}
+	}
+}
+
+func (m *MiraMenu) pollAnnouncements() {
+	url := m.control_url
+	if url == "" {
+		if prefs, err := m.lc.GetPrefs(m.ctx); err == nil {
+			url = prefs.ControlURL
+		}
+	}
+	if url == "" {
+		return
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(url + "/motd")
+	if err != nil {
+		log.Printf("[公告] 拉取公告失败: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// 控制器不提供公告接口（404等），不算错误
+		return
+	}
+	var items []announcement
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		log.Printf("[公告] 解析公告失败: %v", err)
+		return
+	}
+
+	h := loadAnnounceHistory()
+	changed := false
+	for _, a := range items {
+		if a.ID == "" || !h.Seen[a.ID].IsZero() {
+			continue
+		}
+		h.Seen[a.ID] = time.Now()
+		h.Items = append(h.Items, a)
+		changed = true
+		go m.SendNotify("控制器公告："+a.Title, a.Body, NL_Info)
+	}
+	if changed {
+		if n := len(h.Items); n > announceHistoryLimit {
+			h.Items = h.Items[n-announceHistoryLimit:]
+		}
+		h.save()
+	}
+}
+
+// ShowAnnounceHistory 弹出公告历史窗口，最新的公告在最上面。
+func (m *MiraMenu) ShowAnnounceHistory() {
+	h := loadAnnounceHistory()
+
+	const w, ht = 420, 360
+	dlg, err := walk.NewDialogWithFixedSize(nil)
+	if err != nil {
+		log.Printf("[公告] 创建历史窗口出错: %v", err)
+		return
+	}
+	dlg.SetTitle("公告历史")
+	dlg.SetIcon(Icons[Logo])
+	dlg.SetMinMaxSize(walk.Size{Width: w, Height: ht}, walk.Size{Width: w, Height: ht})
+	dlg.SetX(int(win.GetSystemMetrics(win.SM_CXSCREEN)/2 - w/2))
+	dlg.SetY(int(win.GetSystemMetrics(win.SM_CYSCREEN)/2 - ht/2))
+	dlg.SetLayout(walk.NewVBoxLayout())
+
+	te, err := walk.NewTextEdit(dlg)
+	if err != nil {
+		log.Printf("[公告] 创建历史列表出错: %v", err)
+		return
+	}
+	te.SetReadOnly(true)
+	if len(h.Items) == 0 {
+		te.SetText("暂无公告")
+	} else {
+		text := ""
+		for i := len(h.Items) - 1; i >= 0; i-- {
+			a := h.Items[i]
+			when := a.Time
+			if when.IsZero() {
+				when = h.Seen[a.ID]
+			}
+			text += "[" + when.Format("2006-01-02 15:04") + "] " + a.Title + "\r\n" + a.Body + "\r\n\r\n"
+		}
+		te.SetText(text)
+	}
+
+	dlg.Run()
+}
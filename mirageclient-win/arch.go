@@ -0,0 +1,54 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+	"runtime"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows-on-ARM 支持：Surface Pro X 等ARM64设备能以x86/amd64模拟
+// 方式运行本程序，但模拟层开销大，且wintun.dll等驱动组件必须与
+// 进程架构一致。这里检测宿主机真实架构，在模拟运行时提示用户换用
+// 原生ARM64版本（更新提示与自诊断报告也会带上该信息）。
+
+// IsWow64Process2 返回的镜像机器类型常量
+const (
+	imageFileMachineUnknown = 0x0
+	imageFileMachineARM64   = 0xAA64
+)
+
+// nativeMachineARM64 报告宿主机的真实架构是否为ARM64。
+// IsWow64Process2 在Win10 1709之前不存在，调用失败按非ARM64处理。
+func nativeMachineARM64() bool {
+	var processMachine, nativeMachine uint16
+	if err := windows.IsWow64Process2(windows.CurrentProcess(), &processMachine, &nativeMachine); err != nil {
+		return false
+	}
+	return nativeMachine == imageFileMachineARM64
+}
+
+// runningEmulatedOnARM64 报告本进程是否在ARM64设备上以模拟方式运行
+// （即程序本身不是ARM64原生构建）。
+func runningEmulatedOnARM64() bool {
+	return runtime.GOARCH != "arm64" && nativeMachineARM64()
+}
+
+// archAdvice 返回需要提示用户的架构建议，无需提示时返回空串。
+func archAdvice() string {
+	if runningEmulatedOnARM64() {
+		return "检测到本机为ARM64架构，当前运行的是" + runtime.GOARCH + "模拟版本，建议改装ARM64原生版本以获得更好的性能与兼容性"
+	}
+	return ""
+}
+
+// logArchInfo 在启动时记录进程与宿主机架构，模拟运行时额外告警。
+func logArchInfo() {
+	if advice := archAdvice(); advice != "" {
+		log.Printf("[架构] %s", advice)
+	} else {
+		log.Printf("[架构] 进程架构: %s", runtime.GOARCH)
+	}
+}
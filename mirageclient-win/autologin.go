@@ -0,0 +1,64 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/util/winutil"
+)
+
+// autoReloginMinInterval 是两次自动重新认证尝试间的最短间隔，
+// 避免密钥已失效时反复打扰控制器。
+const autoReloginMinInterval = 5 * time.Minute
+
+var lastAutoRelogin time.Time
+
+// policyAuthKey 返回通过策略（注册表）预配的认证密钥，未配置时为空。
+func policyAuthKey() string {
+	return winutil.GetPolicyString("AuthKey", "")
+}
+
+// tryAutoRelogin 在节点密钥过期进入NeedsLogin状态时，若存在策略预配的
+// 认证密钥则自动重新认证，不再弹出登录URL，仅在失败时通知用户。
+// 用于无人值守的kiosk机器。
+func (m *MiraMenu) tryAutoRelogin() {
+	authKey := policyAuthKey()
+	if authKey == "" {
+		return
+	}
+	if time.Since(lastAutoRelogin) < autoReloginMinInterval {
+		return
+	}
+	lastAutoRelogin = time.Now()
+
+	if m.control_url == "" {
+		serverCodeData, err := m.lc.GetStore(m.ctx, string(ipn.CurrentServerCodeKey))
+		if err != nil || len(serverCodeData) == 0 {
+			go m.SendNotify("自动登录失败", "未配置控制器代码，无法使用策略认证密钥自动登录", NL_Error)
+			return
+		}
+		serverCode := string(serverCodeData)
+		m.control_url = "https://sdp." + serverCode
+		if !strings.Contains(serverCode, ".") {
+			m.control_url = m.control_url + ".com"
+		}
+	}
+
+	log.Printf("[自动登录] 节点密钥已过期，使用策略认证密钥重新认证")
+	m.data.SetAuthKey(authKey)
+	prefs := m.createPref()
+	if err := m.lc.CheckPrefs(m.ctx, prefs); err != nil {
+		go m.SendNotify("自动登录失败", humanizeErr(err), NL_Error)
+		return
+	}
+	if err := m.lc.Start(m.ctx, ipn.Options{
+		AuthKey:     authKey,
+		UpdatePrefs: prefs,
+	}); err != nil {
+		go m.SendNotify("自动登录失败", humanizeErr(err), NL_Error)
+	}
+}
@@ -0,0 +1,276 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 伴侣设备通知转发：可选功能，把本机的重要通知（设备密钥临期、
+// 后台服务失联、疑似被移除等）经蜃境网内直连转发给同一用户名下
+// 的其他设备，使无人值守的桌面机出问题时能在用户手边的手机或
+// 笔记本上弹出提醒。收发双方都是本客户端：监听端只接受来自同一
+// 用户设备地址的消息，且功能未开启时收发都不生效。
+
+// companionNotifyPort 是接收转发通知的端口（仅蜃境网内可达）。
+const companionNotifyPort = 53189
+
+// companionSendTimeout 是向单台设备投递一条通知的超时。
+const companionSendTimeout = 5 * time.Second
+
+// companionRepeatSuppress 是同一标题的通知重复转发的抑制窗口：
+// 密钥临期等检查随网络图更新反复触发，不能每次都去打扰对端。
+const companionRepeatSuppress = 6 * time.Hour
+
+// companionNotice 是设备间转发的一条通知。
+type companionNotice struct {
+	From  string    // 发出设备名
+	Title string    // 通知标题
+	Msg   string    // 通知内容
+	Level NotifyLvL // 通知等级
+	Time  time.Time // 发出时间
+}
+
+// companionRelayOn 是通知转发开关的运行时状态，随配置项菜单切换。
+var companionRelayOn atomic.Bool
+
+// companionListenMu 保护下面的接收监听状态。
+var companionListenMu sync.Mutex
+var companionListener net.Listener // 当前接收监听，未建立时为nil
+var companionListenIP netip.Addr   // 当前监听绑定的本机蜃境地址
+
+// companionSentMu 保护 companionSent（标题→最近一次转发时间）。
+var companionSentMu sync.Mutex
+var companionSent = map[string]time.Time{}
+
+func companionConfPath() string {
+	return filepath.Join(programPath, "companion.conf")
+}
+
+// loadCompanionRelay 读取保存的转发开关状态，默认关闭。
+func loadCompanionRelay() bool {
+	b, err := os.ReadFile(companionConfPath())
+	return err == nil && strings.TrimSpace(string(b)) == "1"
+}
+
+// saveCompanionRelay 持久化转发开关状态。
+func saveCompanionRelay(on bool) error {
+	if !on {
+		err := os.Remove(companionConfPath())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(companionConfPath(), []byte("1\n"), 0600)
+}
+
+// setCompanionRelay 切换转发开关并持久化，随即按新状态建立或关闭
+// 接收监听。
+func (m *MiraMenu) setCompanionRelay(on bool) {
+	companionRelayOn.Store(on)
+	if err := saveCompanionRelay(on); err != nil {
+		log.Printf("[伴侣通知] 保存开关状态失败: %v", err)
+	}
+	m.ensureCompanionListener()
+	if on {
+		log.Printf("[伴侣通知] 已开启重要通知转发")
+	} else {
+		log.Printf("[伴侣通知] 已关闭重要通知转发")
+	}
+}
+
+// initCompanionRelay 在启动时恢复保存的开关状态，并让接收监听
+// 跟随网络图维护（入网后才有可绑定的蜃境地址）。
+func (m *MiraMenu) initCompanionRelay() {
+	companionRelayOn.Store(loadCompanionRelay())
+	m.data.NetmapChanged().Attach(func(interface{}) {
+		m.ensureCompanionListener()
+	})
+}
+
+// companionSelfIP 返回本机的蜃境地址（优先IPv4），未入网时无效。
+func (m *MiraMenu) companionSelfIP() netip.Addr {
+	nm := m.data.NetMap
+	if nm == nil || len(nm.Addresses) == 0 {
+		return netip.Addr{}
+	}
+	ip := nm.Addresses[0].Addr()
+	if !ip.Is4() && len(nm.Addresses) > 1 {
+		ip = nm.Addresses[1].Addr()
+	}
+	return ip
+}
+
+// ensureCompanionListener 按开关状态和当前网络图维护接收监听：
+// 只绑定在本机的蜃境地址上，不暴露到所在局域网；地址变化时重新
+// 绑定，开关关闭或未入网时关闭监听。
+func (m *MiraMenu) ensureCompanionListener() {
+	var ip netip.Addr
+	if companionRelayOn.Load() {
+		ip = m.companionSelfIP()
+	}
+	companionListenMu.Lock()
+	defer companionListenMu.Unlock()
+	if companionListener != nil && companionListenIP == ip {
+		return
+	}
+	if companionListener != nil {
+		companionListener.Close()
+		companionListener = nil
+		companionListenIP = netip.Addr{}
+	}
+	if !ip.IsValid() {
+		return
+	}
+	ln, err := net.Listen("tcp", netip.AddrPortFrom(ip, companionNotifyPort).String())
+	if err != nil {
+		log.Printf("[伴侣通知] 监听 %v 失败: %v", ip, err)
+		return
+	}
+	companionListener = ln
+	companionListenIP = ip
+	log.Printf("[伴侣通知] 接收监听于 %v", ln.Addr())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/notify", m.handleCompanionNotice)
+	srv := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("[伴侣通知] 接收监听 %v 退出: %v", ip, srv.Serve(ln))
+	}()
+}
+
+// handleCompanionNotice 校验来源后弹出收到的通知。
+func (m *MiraMenu) handleCompanionNotice(w http.ResponseWriter, r *http.Request) {
+	if !companionRelayOn.Load() {
+		http.Error(w, "notify relay disabled", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "bad remote", http.StatusForbidden)
+		return
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !m.isCompanionAddr(addr) {
+		http.Error(w, "not a companion device", http.StatusForbidden)
+		return
+	}
+	var n companionNotice
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4<<10)).Decode(&n); err != nil {
+		http.Error(w, "bad notice", http.StatusBadRequest)
+		return
+	}
+	if n.Title == "" {
+		http.Error(w, "empty notice", http.StatusBadRequest)
+		return
+	}
+	if n.Level < NL_Msg || n.Level > NL_Error {
+		n.Level = NL_Warn
+	}
+	log.Printf("[伴侣通知] 收到来自 %s(%v) 的通知: %s", n.From, addr, n.Title)
+	go m.SendNotify("["+n.From+"] "+n.Title, n.Msg, n.Level)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isCompanionAddr 报告addr是否为同一用户名下某台设备的蜃境地址。
+// 共享进来的他人设备不算。
+func (m *MiraMenu) isCompanionAddr(addr netip.Addr) bool {
+	nm := m.data.NetMap
+	if nm == nil || nm.SelfNode == nil {
+		return false
+	}
+	for _, p := range nm.Peers {
+		if p.User != nm.SelfNode.User || p.Sharer != 0 {
+			continue
+		}
+		for _, pfx := range p.Addresses {
+			if pfx.IsSingleIP() && pfx.Addr() == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// relayToCompanions 把一条重要通知转发给同一用户名下的其他在线
+// 设备。开关未开启、未入网或同一标题仍在抑制窗口内时静默跳过；
+// 对端未安装本客户端或未开启接收时投递失败，仅记日志。
+func (m *MiraMenu) relayToCompanions(title, msg string, level NotifyLvL) {
+	if !companionRelayOn.Load() {
+		return
+	}
+	nm := m.data.NetMap
+	if nm == nil || nm.SelfNode == nil {
+		return
+	}
+	companionSentMu.Lock()
+	if last, ok := companionSent[title]; ok && time.Since(last) < companionRepeatSuppress {
+		companionSentMu.Unlock()
+		return
+	}
+	companionSent[title] = time.Now()
+	companionSentMu.Unlock()
+
+	n := companionNotice{
+		From:  nm.SelfNode.DisplayName(false),
+		Title: title,
+		Msg:   msg,
+		Level: level,
+		Time:  time.Now(),
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return
+	}
+	client := &http.Client{Timeout: companionSendTimeout}
+	sent := 0
+	for _, p := range nm.Peers {
+		if p.User != nm.SelfNode.User || p.Sharer != 0 {
+			continue
+		}
+		if p.Online != nil && !*p.Online {
+			continue
+		}
+		if len(p.Addresses) == 0 {
+			continue
+		}
+		ip := p.Addresses[0].Addr()
+		if !ip.Is4() && len(p.Addresses) > 1 {
+			ip = p.Addresses[1].Addr()
+		}
+		url := fmt.Sprintf("http://%v/notify", netip.AddrPortFrom(ip, companionNotifyPort))
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[伴侣通知] 投递到 %s 失败: %v", p.DisplayName(false), err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNoContent {
+			sent++
+		}
+	}
+	if sent > 0 {
+		log.Printf("[伴侣通知] 已把通知 %q 转发到 %d 台设备", title, sent)
+	}
+}
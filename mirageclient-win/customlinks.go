@@ -0,0 +1,163 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skratchdot/open-golang/open"
+	"github.com/tailscale/walk"
+)
+
+// 控制器自定义链接菜单区：组织可以在控制器上配置少量链接（内部
+// wiki、工单系统、管理后台等），推送给客户端显示在托盘菜单里。
+// 客户端定期从控制器拉取，并在本地缓存一份，离线启动时也能显示。
+
+// customLink 是控制器推送的一条链接。
+type customLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// customLinksLimit 是菜单中显示的链接条数上限，防止控制器配置
+// 失误撑爆托盘菜单。
+const customLinksLimit = 8
+
+// customLinksPollInterval 是向控制器轮询链接的间隔。
+const customLinksPollInterval = 30 * time.Minute
+
+func customLinksPath() string {
+	return filepath.Join(programPath, "customlinks.json")
+}
+
+func loadCustomLinks() []customLink {
+	var links []customLink
+	b, err := os.ReadFile(customLinksPath())
+	if err == nil {
+		json.Unmarshal(b, &links)
+	}
+	return links
+}
+
+func saveCustomLinks(links []customLink) {
+	b, err := json.MarshalIndent(links, "", "\t")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(customLinksPath(), b, 0600); err != nil {
+		log.Printf("[组织链接] 保存本地缓存失败: %v", err)
+	}
+}
+
+// linksField 是托盘菜单中的组织链接菜单区，走可插拔Field框架。
+type linksField struct {
+	menu *walk.Action
+}
+
+func init() {
+	RegisterField("组织链接", &linksField{})
+}
+
+func (f *linksField) Create(m *MiraMenu) error {
+	contain, err := walk.NewMenu()
+	if err != nil {
+		return err
+	}
+	f.menu = walk.NewMenuAction(contain)
+	f.menu.SetText("组织链接")
+	f.menu.SetVisible(false)
+	if err := m.tray.ContextMenu().Actions().Add(f.menu); err != nil {
+		return err
+	}
+	// 先用本地缓存填充，拉取成功后再更新
+	f.rebuild(m, loadCustomLinks())
+	return nil
+}
+
+func (f *linksField) HandleEvent(m *MiraMenu) {}
+
+func (f *linksField) Refresh(m *MiraMenu) {}
+
+// rebuild 重建链接菜单项，必须在walk主线程上调用。
+func (f *linksField) rebuild(m *MiraMenu, links []customLink) {
+	if f.menu == nil {
+		return
+	}
+	if len(links) > customLinksLimit {
+		links = links[:customLinksLimit]
+	}
+	f.menu.Menu().Actions().Clear()
+	for _, l := range links {
+		if l.Title == "" || !strings.HasPrefix(l.URL, "http") {
+			continue
+		}
+		url := l.URL
+		item := walk.NewAction()
+		item.SetText(l.Title)
+		item.Triggered().Attach(func() {
+			if err := open.Run(url); err != nil {
+				go m.SendNotify("组织链接", "打开链接失败："+humanizeErr(err), NL_Error)
+			}
+		})
+		f.menu.Menu().Actions().Add(item)
+	}
+	f.menu.SetVisible(f.menu.Menu().Actions().Len() > 0)
+}
+
+// watchCustomLinks 周期性从控制器拉取自定义链接并更新菜单。控制器
+// 未配置或不提供该接口时静默跳过本轮。
+func (m *MiraMenu) watchCustomLinks() {
+	ticker := time.NewTicker(customLinksPollInterval)
+	defer ticker.Stop()
+	for {
+		m.pollCustomLinks()
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			ticker.Reset(powerAwareInterval(customLinksPollInterval))
+		}
+	}
+}
+
+func (m *MiraMenu) pollCustomLinks() {
+	url := m.control_url
+	if url == "" {
+		if prefs, err := m.lc.GetPrefs(m.ctx); err == nil {
+			url = prefs.ControlURL
+		}
+	}
+	if url == "" {
+		return
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(url + "/client-links")
+	if err != nil {
+		log.Printf("[组织链接] 拉取失败: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// 控制器不提供该接口（404等），不算错误
+		return
+	}
+	var links []customLink
+	if err := json.NewDecoder(resp.Body).Decode(&links); err != nil {
+		log.Printf("[组织链接] 解析失败: %v", err)
+		return
+	}
+	saveCustomLinks(links)
+	for _, rf := range fieldRegistry {
+		if lf, ok := rf.field.(*linksField); ok {
+			m.mw.Synchronize(func() { lf.rebuild(m, links) })
+			break
+		}
+	}
+}
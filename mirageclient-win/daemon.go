@@ -16,6 +16,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
@@ -94,7 +95,9 @@ func beWindowsSubprocess() bool {
 	}
 	dll := windows.NewLazyDLL("wintun.dll")
 	if err := dll.Load(); err != nil {
-		log.Fatalf("Cannot load wintun.dll for daemon: %v", err)
+		// ARM64设备上装错架构的安装包时wintun.dll必然加载失败，
+		// 把架构信息带进日志便于定位
+		log.Fatalf("Cannot load wintun.dll for daemon (process arch %s, native ARM64: %v): %v", runtime.GOARCH, nativeMachineARM64(), err)
 	}
 
 	logid := args.logid // 传入的logtail ID
@@ -170,6 +173,8 @@ func beFirewallKillswitch() bool {
 
 // 实际创建daemon IPN
 func StartDaemon(ctx context.Context, logf logger.Logf, logID string) error { // lbChn chan *ipnlocal.LocalBackend) {
+	applyTunMTUOverride(logf)
+
 	ln, err := safesocket.Listen(socketPath)
 	if err != nil {
 		return fmt.Errorf("safesocket.Listen: %v", err)
@@ -276,6 +281,8 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logid logid.PublicID
 		}
 	}
 
+	startLocalProxies(logf, dialer)
+
 	e = wgengine.NewWatchdog(e)
 
 	opts := serverOptions{
@@ -309,9 +316,14 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logid logid.PublicID
 	return lb, nil
 }
 
+// tunNames is the comma-separated list of tun device names to try, in
+// order. User mode appends "userspace-networking" as a fallback for
+// when the wintun adapter can't be created without elevation.
+var tunNames = serviceName
+
 func createEngine(logf logger.Logf, linkMon *monitor.Mon, dialer *tsdial.Dialer) (e wgengine.Engine, onlyNetstack bool, err error) {
 	var errs []error
-	for _, name := range strings.Split(serviceName, ",") {
+	for _, name := range strings.Split(tunNames, ",") {
 		logf("wgengine.NewUserspaceEngine(tun %q) ...", name)
 		e, onlyNetstack, err = tryEngine(logf, linkMon, dialer, name)
 		if err == nil {
@@ -331,7 +343,7 @@ func tryEngine(logf logger.Logf, linkMon *monitor.Mon, dialer *tsdial.Dialer, na
 		LinkMonitor: linkMon,
 		Dialer:      dialer,
 	}
-	onlyNetstack = false
+	onlyNetstack = name == "userspace-networking"
 	netns.SetEnabled(true)
 
 	if !onlyNetstack {
@@ -3,7 +3,14 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
 	"tailscale.com/ipn"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/netmap"
 )
@@ -22,6 +29,12 @@ type DataPool struct {
 	StatePuber  *DataEventPublisher
 	PrefsPuber  *DataEventPublisher
 	NetmapPuber *DataEventPublisher
+
+	// 网络图UI刷新节流状态，见 SetNetMap
+	netmapMu      sync.Mutex
+	netmapDigest  string      // 上次触发菜单重建的网络图摘要
+	netmapLastPub time.Time   // 上次触发菜单重建的时间
+	netmapFlush   *time.Timer // 节流期内合并变化的尾随刷新，nil表示未挂起
 }
 
 func NewDataPool() *DataPool {
@@ -79,11 +92,94 @@ func (pool *DataPool) SetPrefs(newV *ipn.Prefs) {
 	pool.Prefs = newV
 	pool.PrefsPuber.Publish(newV)
 }
+
+// netmapRefreshMinInterval 是网络图驱动的菜单重建的最小间隔。
+// 大网络中控制端推送很频繁，逐条重建会闪烁卡顿。
+const netmapRefreshMinInterval = 3 * time.Second
+
+// SetNetMap 更新网络图。数据总是立即更新（设备列表窗口等直接读
+// pool.NetMap），但菜单重建事件做两层过滤：与菜单显示无关的变化
+// （仅端点、延迟等）通过摘要比对直接滤掉；菜单相关变化至多每
+// netmapRefreshMinInterval 触发一次，节流期内的变化合并到尾随的
+// 一次刷新。
 func (pool *DataPool) SetNetMap(newV *netmap.NetworkMap) {
 	pool.NetMap = newV
+	digest := netmapMenuDigest(newV)
+
+	pool.netmapMu.Lock()
+	if digest == pool.netmapDigest {
+		pool.netmapMu.Unlock()
+		return
+	}
+	if since := time.Since(pool.netmapLastPub); since < netmapRefreshMinInterval {
+		if pool.netmapFlush == nil {
+			pool.netmapFlush = time.AfterFunc(netmapRefreshMinInterval-since, pool.flushNetmap)
+		}
+		pool.netmapMu.Unlock()
+		return
+	}
+	pool.netmapDigest = digest
+	pool.netmapLastPub = time.Now()
+	pool.netmapMu.Unlock()
 	pool.NetmapPuber.Publish(newV)
 }
 
+// flushNetmap 是节流期结束后的尾随刷新，发布期间累积的最新网络图。
+func (pool *DataPool) flushNetmap() {
+	newV := pool.NetMap
+	digest := netmapMenuDigest(newV)
+
+	pool.netmapMu.Lock()
+	pool.netmapFlush = nil
+	if digest == pool.netmapDigest {
+		pool.netmapMu.Unlock()
+		return
+	}
+	pool.netmapDigest = digest
+	pool.netmapLastPub = time.Now()
+	pool.netmapMu.Unlock()
+	pool.NetmapPuber.Publish(newV)
+}
+
+// netmapMenuDigest 提取网络图中影响菜单显示的部分生成摘要。摘要
+// 不变的网络图更新（仅节点端点、DERP延迟等高频变化）不会触发菜单
+// 重建。新增菜单要显示的字段时记得同步加进来。
+func netmapMenuDigest(nm *netmap.NetworkMap) string {
+	if nm == nil {
+		return ""
+	}
+	var b strings.Builder
+	if nm.SelfNode != nil {
+		self := nm.UserProfiles[nm.SelfNode.User]
+		fmt.Fprintf(&b, "self:%s|%s|%v|%s|%s|%d\n",
+			nm.SelfNode.DisplayName(true), nm.Name, nm.Addresses,
+			self.DisplayName, self.LoginName, nm.SelfNode.KeyExpiry.Unix())
+	}
+	if nm.DERPMap != nil {
+		rids := make([]int, 0, len(nm.DERPMap.Regions))
+		for rid := range nm.DERPMap.Regions {
+			rids = append(rids, rid)
+		}
+		sort.Ints(rids)
+		for _, rid := range rids {
+			if r := nm.DERPMap.Regions[rid]; r != nil {
+				fmt.Fprintf(&b, "derp:%d|%s\n", rid, r.RegionName)
+			}
+		}
+	}
+	for _, p := range nm.Peers {
+		name, hostname := p.DisplayNames(true)
+		owner := ""
+		if prof, ok := nm.UserProfiles[p.User]; ok {
+			owner = prof.DisplayName + "|" + prof.LoginName
+		}
+		fmt.Fprintf(&b, "peer:%s|%s|%s|%v|%v|%d|%d|%s|%v\n",
+			p.StableID, name, hostname, p.Addresses, p.Tags,
+			p.User, p.Sharer, owner, tsaddr.ContainsExitRoutes(p.AllowedIPs))
+	}
+	return b.String()
+}
+
 // 以下为事件订阅
 
 func (pool *DataPool) StateChanged() *DataEvent {
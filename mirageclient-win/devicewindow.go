@@ -0,0 +1,326 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tailscale/walk"
+	"github.com/tailscale/win"
+	"tailscale.com/tailcfg"
+)
+
+// deviceRow 是设备列表窗口中的一行
+type deviceRow struct {
+	Name     string
+	Group    string // 分组：我的设备/标签节点/其他用户显示名
+	IP       string
+	Note     string // 本地备注
+	StableID tailcfg.StableNodeID
+	Pinned   bool
+	LastUsed time.Time
+}
+
+// deviceLocalMeta 是本地保存的设备置顶、最近使用与昵称备注记录
+type deviceLocalMeta struct {
+	Pinned    map[string]bool      `json:"pinned"`
+	LastUsed  map[string]time.Time `json:"lastUsed"`
+	Nicknames map[string]string    `json:"nicknames,omitempty"` // 节点ID -> 本地昵称
+	Notes     map[string]string    `json:"notes,omitempty"`     // 节点ID -> 本地备注
+}
+
+func deviceMetaPath() string {
+	return filepath.Join(programPath, "devices.json")
+}
+
+func loadDeviceMeta() *deviceLocalMeta {
+	meta := &deviceLocalMeta{
+		Pinned:    map[string]bool{},
+		LastUsed:  map[string]time.Time{},
+		Nicknames: map[string]string{},
+		Notes:     map[string]string{},
+	}
+	b, err := os.ReadFile(deviceMetaPath())
+	if err == nil {
+		json.Unmarshal(b, meta)
+	}
+	if meta.Nicknames == nil {
+		meta.Nicknames = map[string]string{}
+	}
+	if meta.Notes == nil {
+		meta.Notes = map[string]string{}
+	}
+	return meta
+}
+
+// peerDisplayName 返回节点显示名，本地设置过昵称时优先使用昵称。
+// 用在通知等只涉及单个节点的场合；批量场合请自行加载一次meta。
+func peerDisplayName(node *tailcfg.Node) string {
+	if nick := loadDeviceMeta().Nicknames[string(node.StableID)]; nick != "" {
+		return nick
+	}
+	return node.DisplayName(true)
+}
+
+func (meta *deviceLocalMeta) save() {
+	b, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(deviceMetaPath(), b, 0600); err != nil {
+		log.Printf("[设备列表] 保存本地记录失败: %v", err)
+	}
+}
+
+// deviceTableModel 支持搜索过滤的设备表格模型
+type deviceTableModel struct {
+	walk.TableModelBase
+	all      []*deviceRow
+	filtered []*deviceRow
+}
+
+func (m *deviceTableModel) RowCount() int {
+	return len(m.filtered)
+}
+
+func (m *deviceTableModel) Value(row, col int) interface{} {
+	r := m.filtered[row]
+	switch col {
+	case 0:
+		if r.Pinned {
+			return "★ " + r.Name
+		}
+		return r.Name
+	case 1:
+		return r.Group
+	case 2:
+		return r.IP
+	case 3:
+		return r.Note
+	}
+	return ""
+}
+
+// applyFilter 按搜索词过滤并按置顶、最近使用、名称排序
+func (m *deviceTableModel) applyFilter(search string) {
+	search = strings.ToLower(strings.TrimSpace(search))
+	m.filtered = m.filtered[:0]
+	for _, r := range m.all {
+		if search == "" ||
+			strings.Contains(strings.ToLower(r.Name), search) ||
+			strings.Contains(strings.ToLower(r.Group), search) ||
+			strings.Contains(strings.ToLower(r.Note), search) ||
+			strings.Contains(r.IP, search) {
+			m.filtered = append(m.filtered, r)
+		}
+	}
+	sort.SliceStable(m.filtered, func(i, j int) bool {
+		a, b := m.filtered[i], m.filtered[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if !a.LastUsed.Equal(b.LastUsed) {
+			return a.LastUsed.After(b.LastUsed)
+		}
+		return a.Name < b.Name
+	})
+	m.PublishRowsReset()
+}
+
+// deviceRows 从网络图生成设备行，分组规则与托盘菜单一致
+func (m *MiraMenu) deviceRows(meta *deviceLocalMeta) []*deviceRow {
+	var rows []*deviceRow
+	netmap := m.data.NetMap
+	if netmap == nil {
+		return rows
+	}
+	for _, node := range netmap.Peers {
+		name, hostname := node.DisplayNames(true)
+		if hostname != "" && hostname != name {
+			name += "(" + hostname + ")"
+		}
+		if len(node.Addresses) == 0 {
+			continue
+		}
+		ip := node.Addresses[0].Addr()
+		if !ip.Is4() && len(node.Addresses) > 1 {
+			ip = node.Addresses[1].Addr()
+		}
+		group := ""
+		if node.Tags != nil {
+			group = "标签节点"
+		} else if node.User == netmap.SelfNode.User {
+			group = "我的设备"
+		} else {
+			owner := node.User
+			if !node.Sharer.IsZero() && node.Sharer != node.User {
+				owner = node.Sharer
+			}
+			group = netmap.UserProfiles[owner].DisplayName
+			if group == "" {
+				group = strconv.FormatInt(int64(owner), 10)
+			}
+		}
+		id := string(node.StableID)
+		if nick := meta.Nicknames[id]; nick != "" {
+			name = nick + "（" + name + "）"
+		}
+		rows = append(rows, &deviceRow{
+			Name:     name,
+			Group:    group,
+			IP:       ip.String(),
+			Note:     meta.Notes[id],
+			StableID: node.StableID,
+			Pinned:   meta.Pinned[id],
+			LastUsed: meta.LastUsed[id],
+		})
+	}
+	return rows
+}
+
+// ShowDeviceWindow 弹出可搜索的设备列表窗口
+func (m *MiraMenu) ShowDeviceWindow() {
+	meta := loadDeviceMeta()
+	model := &deviceTableModel{all: m.deviceRows(meta)}
+
+	const w, h = 480, 420
+	dlg, err := walk.NewDialogWithFixedSize(nil)
+	if err != nil {
+		log.Printf("[设备列表] 创建窗口出错: %v", err)
+		return
+	}
+	dlg.SetTitle("网内设备")
+	dlg.SetIcon(Icons[Logo])
+	dlg.SetMinMaxSize(walk.Size{Width: w, Height: h}, walk.Size{Width: w, Height: h})
+	dlg.SetX(int(win.GetSystemMetrics(win.SM_CXSCREEN)/2 - w/2))
+	dlg.SetY(int(win.GetSystemMetrics(win.SM_CYSCREEN)/2 - h/2))
+	dlg.SetLayout(walk.NewVBoxLayout())
+
+	search, err := walk.NewLineEdit(dlg)
+	if err != nil {
+		log.Printf("[设备列表] 创建搜索框出错: %v", err)
+		return
+	}
+	search.SetCueBanner("搜索设备名称、分组或IP")
+
+	tv, err := walk.NewTableView(dlg)
+	if err != nil {
+		log.Printf("[设备列表] 创建表格出错: %v", err)
+		return
+	}
+	for _, c := range []struct {
+		title string
+		width int
+	}{{"设备", 170}, {"分组", 90}, {"地址", 100}, {"备注", 90}} {
+		col := walk.NewTableViewColumn()
+		col.SetTitle(c.title)
+		col.SetWidth(c.width)
+		tv.Columns().Add(col)
+	}
+	tv.SetModel(model)
+	model.applyFilter("")
+
+	search.TextChanged().Attach(func() {
+		model.applyFilter(search.Text())
+	})
+
+	tip, err := walk.NewTextLabel(dlg)
+	if err == nil {
+		tip.SetText("双击复制设备地址；右键菜单可置顶设备、设置本地昵称和备注")
+	}
+
+	// 双击复制地址并记录最近使用
+	tv.ItemActivated().Attach(func() {
+		idx := tv.CurrentIndex()
+		if idx < 0 || idx >= len(model.filtered) {
+			return
+		}
+		r := model.filtered[idx]
+		walk.Clipboard().SetText(r.IP)
+		meta.LastUsed[string(r.StableID)] = time.Now()
+		meta.save()
+		go m.SendNotify(r.Name, "已复制节点IP("+r.IP+")到剪贴板", NL_Info)
+	})
+
+	// 右键菜单：置顶/取消置顶
+	ctxMenu, err := walk.NewMenu()
+	if err == nil {
+		pinAction := walk.NewAction()
+		pinAction.SetText("置顶/取消置顶")
+		pinAction.Triggered().Attach(func() {
+			idx := tv.CurrentIndex()
+			if idx < 0 || idx >= len(model.filtered) {
+				return
+			}
+			r := model.filtered[idx]
+			r.Pinned = !r.Pinned
+			meta.Pinned[string(r.StableID)] = r.Pinned
+			meta.save()
+			model.applyFilter(search.Text())
+		})
+		ctxMenu.Actions().Add(pinAction)
+
+		currentRow := func() *deviceRow {
+			idx := tv.CurrentIndex()
+			if idx < 0 || idx >= len(model.filtered) {
+				return nil
+			}
+			return model.filtered[idx]
+		}
+		nickAction := walk.NewAction()
+		nickAction.SetText("设置昵称…")
+		nickAction.Triggered().Attach(func() {
+			r := currentRow()
+			if r == nil {
+				return
+			}
+			ok, nick := PopTextInputDlg("设置昵称", "为 "+r.Name+" 设置本地昵称（留空清除）")
+			if !ok {
+				return
+			}
+			nick = strings.TrimSpace(nick)
+			if nick == "" {
+				delete(meta.Nicknames, string(r.StableID))
+			} else {
+				meta.Nicknames[string(r.StableID)] = nick
+			}
+			meta.save()
+			model.all = m.deviceRows(meta)
+			model.applyFilter(search.Text())
+		})
+		ctxMenu.Actions().Add(nickAction)
+
+		noteAction := walk.NewAction()
+		noteAction.SetText("设置备注…")
+		noteAction.Triggered().Attach(func() {
+			r := currentRow()
+			if r == nil {
+				return
+			}
+			ok, note := PopTextInputDlg("设置备注", "为 "+r.Name+" 设置本地备注（留空清除）")
+			if !ok {
+				return
+			}
+			note = strings.TrimSpace(note)
+			if note == "" {
+				delete(meta.Notes, string(r.StableID))
+			} else {
+				meta.Notes[string(r.StableID)] = note
+			}
+			meta.save()
+			model.all = m.deviceRows(meta)
+			model.applyFilter(search.Text())
+		})
+		ctxMenu.Actions().Add(noteAction)
+		tv.SetContextMenu(ctxMenu)
+	}
+
+	dlg.Run()
+}
@@ -0,0 +1,141 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/tailscale/walk"
+	"github.com/tailscale/win"
+)
+
+// 自诊断窗口：显示GUI进程自身的协程数、堆内存、事件管道积压和
+// 通讯协程重启次数，用于排查长期运行用户反馈的泄漏问题。通过
+// 调试菜单打开，报告可复制或导出成文件随诊断材料提交。
+
+// appStartTime 是GUI进程启动时间，用于计算运行时长。
+var appStartTime = time.Now()
+
+// buildDiagReport 生成自诊断报告文本。
+func (m *MiraMenu) buildDiagReport() string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "生成时间: %s\n", time.Now().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "运行时长: %s\n", time.Since(appStartTime).Round(time.Second))
+	fmt.Fprintf(&b, "\n[运行时]\n")
+	fmt.Fprintf(&b, "进程架构: %s (宿主机ARM64: %v)\n", runtime.GOARCH, nativeMachineARM64())
+	fmt.Fprintf(&b, "协程数: %d\n", runtime.NumGoroutine())
+	fmt.Fprintf(&b, "堆内存: 已用 %s / 保留 %s (对象 %d 个)\n",
+		fmtBytes(ms.HeapAlloc), fmtBytes(ms.HeapSys), ms.HeapObjects)
+	fmt.Fprintf(&b, "进程内存: %s (自系统申请)\n", fmtBytes(ms.Sys))
+	fmt.Fprintf(&b, "GC次数: %d (累计暂停 %s)\n", ms.NumGC, time.Duration(ms.PauseTotalNs))
+
+	fmt.Fprintf(&b, "\n[通讯协程]\n")
+	if watcher != nil {
+		for _, line := range watcher.DiagSnapshot() {
+			fmt.Fprintf(&b, "%s\n", line)
+		}
+	} else {
+		fmt.Fprintf(&b, "未创建\n")
+	}
+
+	fmt.Fprintf(&b, "\n[数据池]\n")
+	fmt.Fprintf(&b, "状态: %s\n", m.data.State.String())
+	fmt.Fprintf(&b, "网内设备数: %d\n", len(m.data.NetMap.Peers))
+	return b.String()
+}
+
+// fmtBytes 把字节数格式化为可读单位。
+func fmtBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// exportDiagReport 把报告（附全量协程栈）写入本地文件，返回文件路径。
+func (m *MiraMenu) exportDiagReport() (string, error) {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	report := m.buildDiagReport() + "\n[协程栈]\n" + string(buf)
+
+	path := filepath.Join(programPath, "diag-"+time.Now().Format("20060102-150405")+".txt")
+	if err := os.WriteFile(path, []byte(report), 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// ShowDiagWindow 弹出自诊断窗口。
+func (m *MiraMenu) ShowDiagWindow() {
+	const w, h = 420, 400
+	dlg, err := walk.NewDialogWithFixedSize(nil)
+	if err != nil {
+		log.Printf("[自诊断] 创建窗口出错: %v", err)
+		return
+	}
+	dlg.SetTitle("自诊断信息")
+	dlg.SetIcon(Icons[Logo])
+	dlg.SetMinMaxSize(walk.Size{Width: w, Height: h}, walk.Size{Width: w, Height: h})
+	dlg.SetX(int(win.GetSystemMetrics(win.SM_CXSCREEN)/2 - w/2))
+	dlg.SetY(int(win.GetSystemMetrics(win.SM_CYSCREEN)/2 - h/2))
+	dlg.SetLayout(walk.NewVBoxLayout())
+
+	text, err := walk.NewTextEdit(dlg)
+	if err != nil {
+		log.Printf("[自诊断] 创建文本区出错: %v", err)
+		return
+	}
+	text.SetReadOnly(true)
+	refresh := func() {
+		text.SetText(strings.ReplaceAll(m.buildDiagReport(), "\n", "\r\n"))
+	}
+	refresh()
+
+	row, err := walk.NewComposite(dlg)
+	if err != nil {
+		log.Printf("[自诊断] 创建按钮区出错: %v", err)
+		return
+	}
+	row.SetLayout(walk.NewHBoxLayout())
+
+	refreshBtn, err := walk.NewPushButton(row)
+	if err == nil {
+		refreshBtn.SetText("刷新")
+		refreshBtn.Clicked().Attach(refresh)
+	}
+	copyBtn, err := walk.NewPushButton(row)
+	if err == nil {
+		copyBtn.SetText("复制")
+		copyBtn.Clicked().Attach(func() {
+			walk.Clipboard().SetText(text.Text())
+		})
+	}
+	exportBtn, err := walk.NewPushButton(row)
+	if err == nil {
+		exportBtn.SetText("导出(含协程栈)")
+		exportBtn.Clicked().Attach(func() {
+			path, err := m.exportDiagReport()
+			if err != nil {
+				go m.SendNotify("自诊断", "导出报告失败："+humanizeErr(err), NL_Error)
+				return
+			}
+			go m.SendNotify("自诊断", "报告已导出到 "+path, NL_Info)
+		})
+	}
+
+	dlg.Run()
+}
@@ -0,0 +1,73 @@
+//go:build windows
+
+package main
+
+import "strings"
+
+// 错误分类层：把后台服务返回的原始错误（EOF、管道忙、拒绝访问、
+// 密钥过期等）翻译成带处置建议的中文提示，代替通知里直接显示
+// err.Error()。原始错误文本仍附在括号里，便于反馈问题时定位。
+
+// errHint 是一类已知错误：任一关键字命中即采用该提示。
+type errHint struct {
+	substrs []string // 在错误文本中查找的关键字（不分大小写）
+	msg     string   // 给用户看的提示与建议
+}
+
+// errHints 按序匹配，越具体的放越前面。
+var errHints = []errHint{
+	{
+		substrs: []string{"key expired", "keyexpired"},
+		msg:     "设备密钥已过期，无法继续组网。建议：点击“登录”重新认证本设备",
+	},
+	{
+		substrs: []string{"access is denied", "access denied", "拒绝访问"},
+		msg:     "操作被系统拒绝。建议：以管理员身份运行客户端，或检查所在用户组权限",
+	},
+	{
+		substrs: []string{"all pipe instances are busy", "pipe is being closed", "pipe busy"},
+		msg:     "后台服务通讯管道繁忙或正在关闭。建议：稍候重试；若持续出现可在“配置项”中重启后台服务",
+	},
+	{
+		substrs: []string{"the system cannot find the file specified", "no such file"},
+		msg:     "找不到后台服务通讯管道，服务可能未在运行。建议：在“配置项”中重启后台服务",
+	},
+	{
+		substrs: []string{"eof"},
+		msg:     "与后台服务的连接意外断开。建议：等待自动重连；若持续出现可在“配置项”中重启后台服务",
+	},
+	{
+		substrs: []string{"context deadline exceeded", "i/o timeout", "timeout"},
+		msg:     "操作超时。建议：检查网络连通性后重试",
+	},
+	{
+		substrs: []string{"no such host", "connection refused", "network is unreachable"},
+		msg:     "无法连接服务器。建议：检查网络连接与服务器代码设置",
+	},
+	{
+		substrs: []string{"logged out", "needslogin"},
+		msg:     "当前未登录。建议：点击“登录”完成认证",
+	},
+}
+
+// humanizeErrMsg 把原始错误文本映射为用户可读提示；没有命中任何
+// 已知类别时原样返回。
+func humanizeErrMsg(raw string) string {
+	lower := strings.ToLower(raw)
+	for _, h := range errHints {
+		for _, sub := range h.substrs {
+			if strings.Contains(lower, sub) {
+				return h.msg + " (" + raw + ")"
+			}
+		}
+	}
+	return raw
+}
+
+// humanizeErr 是 humanizeErrMsg 的error版本。
+func humanizeErr(err error) string {
+	if err == nil {
+		return ""
+	}
+	return humanizeErrMsg(err.Error())
+}
@@ -0,0 +1,120 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/tailcfg"
+)
+
+// exitNodeMemory 是本地保存的"按网络记住出口节点"设置：
+// 以默认网关地址标识所在网络，记录各网络下用户选择的出口节点。
+type exitNodeMemory struct {
+	Enabled   bool                            `json:"enabled"`
+	ByNetwork map[string]tailcfg.StableNodeID `json:"byNetwork"` // 网关地址 -> 出口节点ID（空串表示不使用）
+}
+
+// exitMemoryCheckInterval 是检测所在网络变化的间隔。
+const exitMemoryCheckInterval = 15 * time.Second
+
+func exitMemoryPath() string {
+	return filepath.Join(programPath, "exitmemory.json")
+}
+
+func loadExitMemory() *exitNodeMemory {
+	mem := &exitNodeMemory{ByNetwork: map[string]tailcfg.StableNodeID{}}
+	b, err := os.ReadFile(exitMemoryPath())
+	if err == nil {
+		json.Unmarshal(b, mem)
+	}
+	if mem.ByNetwork == nil {
+		mem.ByNetwork = map[string]tailcfg.StableNodeID{}
+	}
+	return mem
+}
+
+func (mem *exitNodeMemory) save() {
+	b, err := json.MarshalIndent(mem, "", "\t")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(exitMemoryPath(), b, 0600); err != nil {
+		log.Printf("[出口记忆] 保存设置失败: %v", err)
+	}
+}
+
+// currentNetworkKey 返回标识当前所在网络的键（默认网关地址），
+// 无法判断时返回空串。
+func currentNetworkKey() string {
+	gw, _, ok := interfaces.LikelyHomeRouterIP()
+	if !ok {
+		return ""
+	}
+	return gw.String()
+}
+
+// rememberExitNodeChoice 在用户手动选择出口节点时记录当前网络下的选择。
+func (m *MiraMenu) rememberExitNodeChoice(stableID tailcfg.StableNodeID) {
+	mem := loadExitMemory()
+	if !mem.Enabled {
+		return
+	}
+	key := currentNetworkKey()
+	if key == "" {
+		return
+	}
+	mem.ByNetwork[key] = stableID
+	mem.save()
+	log.Printf("[出口记忆] 已记录网络 %s 的出口节点选择: %q", key, stableID)
+}
+
+// watchNetworkForExitNode 周期性检测所在网络变化，启用按网络记忆时
+// 自动切换到该网络下记录的出口节点。
+func (m *MiraMenu) watchNetworkForExitNode() {
+	ticker := time.NewTicker(exitMemoryCheckInterval)
+	defer ticker.Stop()
+	lastKey := ""
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			ticker.Reset(powerAwareInterval(exitMemoryCheckInterval))
+		}
+		mem := loadExitMemory()
+		if !mem.Enabled {
+			continue
+		}
+		key := currentNetworkKey()
+		if key == "" || key == lastKey {
+			continue
+		}
+		lastKey = key
+		want, ok := mem.ByNetwork[key]
+		if !ok {
+			continue
+		}
+		if m.data.State != ipn.Running {
+			continue
+		}
+		if m.data.Prefs != nil && m.data.Prefs.ExitNodeID == want {
+			continue
+		}
+		log.Printf("[出口记忆] 检测到网络切换到 %s，应用记录的出口节点 %q", key, want)
+		m.mw.Synchronize(func() {
+			m.setUseExitNode(want)
+		})
+		if want == "" {
+			go m.SendNotify("出口节点", "已按本网络的记录停用出口节点", NL_Info)
+		} else if node, ok := m.data.NetMap.PeerWithStableID(want); ok {
+			go m.SendNotify("出口节点", "已按本网络的记录切换到出口节点 "+peerDisplayName(node), NL_Info)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+//go:build windows
+
+package main
+
+import "log"
+
+// Field 是托盘菜单的一个可插拔菜单区。新菜单区（设备列表、通知等）
+// 实现本接口并在 init 中调用 RegisterField 即可挂入菜单，无需改动
+// MiraMenu 核心代码；现有的几个内置菜单区先于该框架存在，可以逐步迁移。
+//
+// 三个方法都只会在 walk 主线程上被调用。把 walk 控件的创建集中在
+// Create 里，业务逻辑留在 HandleEvent / Refresh 中，便于用假的
+// walk 层做单元测试。
+type Field interface {
+	// Create 创建菜单区的 walk 控件并挂到托盘菜单上。
+	// 在托盘初始化时按注册顺序调用一次。
+	Create(m *MiraMenu) error
+
+	// HandleEvent 绑定菜单项的触发处理。在所有菜单区创建完成后
+	// 按注册顺序调用一次。
+	HandleEvent(m *MiraMenu)
+
+	// Refresh 根据数据池的最新状态（连接状态、配置、网络图）刷新
+	// 菜单区显示。任一数据变化时都会被调用，实现应自行判断增量。
+	Refresh(m *MiraMenu)
+}
+
+// registeredField 记录注册的菜单区及其名称（用于日志）。
+type registeredField struct {
+	name  string
+	field Field
+}
+
+// fieldRegistry 按注册顺序保存所有可插拔菜单区；顺序即菜单中的
+// 显示顺序（位于内置菜单区之后、“退出”之前）。
+var fieldRegistry []registeredField
+
+// RegisterField 注册一个菜单区，通常在实现文件的 init 中调用。
+func RegisterField(name string, f Field) {
+	fieldRegistry = append(fieldRegistry, registeredField{name, f})
+}
+
+// createFields 按注册顺序创建所有可插拔菜单区。单个菜单区创建失败
+// 只记录日志并跳过，与内置菜单区的处理方式一致。
+func (m *MiraMenu) createFields() {
+	created := fieldRegistry[:0:0]
+	for _, rf := range fieldRegistry {
+		if err := rf.field.Create(m); err != nil {
+			log.Printf("初始化%s菜单区错误：%s", rf.name, err)
+			continue
+		}
+		created = append(created, rf)
+	}
+	fieldRegistry = created
+}
+
+// bindFieldEvents 绑定所有已创建菜单区的触发处理。
+func (m *MiraMenu) bindFieldEvents() {
+	for _, rf := range fieldRegistry {
+		rf.field.HandleEvent(m)
+	}
+}
+
+// refreshFields 刷新所有已创建菜单区的显示，由数据池变化事件驱动。
+func (m *MiraMenu) refreshFields() {
+	for _, rf := range fieldRegistry {
+		rf.field.Refresh(m)
+	}
+}
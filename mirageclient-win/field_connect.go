@@ -7,32 +7,47 @@ import (
 )
 
 type connectField struct {
-	loginAction      *walk.Action // 登录按钮
-	connectAction    *walk.Action // 连接按钮
-	disconnectAction *walk.Action // 断开按钮
+	loginAction       *walk.Action // 登录按钮
+	reopenLoginAction *walk.Action // 重新打开登录页面按钮
+	connectAction     *walk.Action // 连接按钮
+	disconnectAction  *walk.Action // 断开按钮
+	powerSaveAction   *walk.Action // 省电模式状态行
 }
 
 func (m *MiraMenu) newConnectField() (cf *connectField, err error) {
 	cf = &connectField{}
 	cf.loginAction = walk.NewAction()
 	cf.loginAction.SetText("登录…")
+	cf.reopenLoginAction = walk.NewAction()
+	cf.reopenLoginAction.SetText("打开登录页面")
+	cf.reopenLoginAction.SetVisible(false)
 	cf.connectAction = walk.NewAction()
 	cf.connectAction.SetVisible(false)
 	cf.disconnectAction = walk.NewAction()
 	cf.disconnectAction.SetText("断开")
 	cf.disconnectAction.SetVisible(false)
+	cf.powerSaveAction = walk.NewAction()
+	cf.powerSaveAction.SetText("省电模式：已降低后台活动频率")
+	cf.powerSaveAction.SetEnabled(false)
+	cf.powerSaveAction.SetVisible(false)
 
 	// 待登录态连接区样式
 
 	if err := m.tray.ContextMenu().Actions().Add(cf.loginAction); err != nil {
 		return nil, err
 	}
+	if err := m.tray.ContextMenu().Actions().Add(cf.reopenLoginAction); err != nil {
+		return nil, err
+	}
 	if err := m.tray.ContextMenu().Actions().Add(cf.connectAction); err != nil {
 		return nil, err
 	}
 	if err := m.tray.ContextMenu().Actions().Add(cf.disconnectAction); err != nil {
 		return nil, err
 	}
+	if err := m.tray.ContextMenu().Actions().Add(cf.powerSaveAction); err != nil {
+		return nil, err
+	}
 	if err := m.tray.ContextMenu().Actions().Add(walk.NewSeparatorAction()); err != nil {
 		return nil, err
 	}
@@ -1,4 +1,5 @@
 //go:build windows
+
 package main
 
 import (
@@ -45,12 +46,17 @@ func (m *MiraMenu) newDebugField() (df *walk.Action, err error) {
 		if confirm {
 			err := m.lc.SetStore(m.ctx, string(ipn.CurrentServerCodeKey), "")
 			if err != nil {
-				go m.SendNotify("重设置服务器代码出错", err.Error(), NL_Error)
+				go m.SendNotify("重设置服务器代码出错", humanizeErr(err), NL_Error)
 				return
 			}
 			m.DoLogout()
 		}
 	})
+	diagAction := walk.NewAction()
+	diagAction.SetText("#自诊断信息")
+	diagAction.Triggered().Attach(func() {
+		m.ShowDiagWindow()
+	})
 	uninstallServiceAction := walk.NewAction()
 	uninstallServiceAction.SetText("#卸载后台服务并退出")
 	uninstallServiceAction.Triggered().Attach(func() {
@@ -60,7 +66,7 @@ func (m *MiraMenu) newDebugField() (df *walk.Action, err error) {
 		if confirm {
 			err := ElevateToUinstallService()
 			if err != nil {
-				go m.SendNotify("卸载后台服务出错", err.Error(), NL_Error)
+				go m.SendNotify("卸载后台服务出错", humanizeErr(err), NL_Error)
 				return
 			}
 			os.Exit(0)
@@ -74,6 +80,7 @@ func (m *MiraMenu) newDebugField() (df *walk.Action, err error) {
 	debugContain.Actions().Add(setAuthKeyAction)
 	debugContain.Actions().Add(cleanAuthKeyAction)
 	debugContain.Actions().Add(resetAction)
+	debugContain.Actions().Add(diagAction)
 	debugContain.Actions().Add(uninstallServiceAction)
 	m.debugAction = walk.NewMenuAction(debugContain)
 	m.debugAction.SetText("调试项")
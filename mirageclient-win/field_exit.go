@@ -18,6 +18,7 @@ type exitField struct {
 	exitPrefTitle        *walk.Action // 出口节点配置标题
 	exitAllowLocalAction *walk.Action // 出口节点配置项 -- 允许访问本地网络
 	exitRunExitAction    *walk.Action // 出口节点配置项 -- 用作出口节点
+	exitRememberAction   *walk.Action // 出口节点配置项 -- 按网络记住出口选择
 }
 
 func (m *MiraMenu) newExitField() (ef *exitField, err error) {
@@ -54,11 +55,23 @@ func (m *MiraMenu) newExitField() (ef *exitField, err error) {
 	ef.exitRunExitAction.SetCheckable(true)
 	ef.exitRunExitAction.SetChecked(false)
 
+	ef.exitRememberAction = walk.NewAction()
+	ef.exitRememberAction.SetText("按网络记住出口选择")
+	ef.exitRememberAction.SetCheckable(true)
+	ef.exitRememberAction.SetChecked(loadExitMemory().Enabled)
+	ef.exitRememberAction.Triggered().Attach(func() {
+		mem := loadExitMemory()
+		mem.Enabled = !mem.Enabled
+		mem.save()
+		ef.exitRememberAction.SetChecked(mem.Enabled)
+	})
+
 	ef.exitNodeMenu.Menu().Actions().Add(ef.exitNodeListTitle)
 	ef.exitNodeMenu.Menu().Actions().Add(walk.NewSeparatorAction())
 	ef.exitNodeMenu.Menu().Actions().Add(ef.exitPrefTitle)
 	ef.exitNodeMenu.Menu().Actions().Add(ef.exitAllowLocalAction)
 	ef.exitNodeMenu.Menu().Actions().Add(ef.exitRunExitAction)
+	ef.exitNodeMenu.Menu().Actions().Add(ef.exitRememberAction)
 
 	if err := m.tray.ContextMenu().Actions().Add(ef.exitNodeMenu); err != nil {
 		return nil, err
@@ -78,7 +91,7 @@ func (m *MiraMenu) updateCurrentExitNode(stableID tailcfg.StableNodeID) {
 		m.exitField.exitNodeList.At(index).SetChecked(true)
 	}
 	if node, ok := m.data.NetMap.PeerWithStableID(m.data.Prefs.ExitNodeID); ok {
-		m.exitField.exitNodeMenu.SetText("出口节点(" + node.DisplayName(true) + ")")
+		m.exitField.exitNodeMenu.SetText("出口节点(" + peerDisplayName(node) + ")")
 		return
 	}
 	m.exitField.exitNodeMenu.SetText("出口节点")
@@ -90,6 +103,10 @@ func (m *MiraMenu) setUseExitNode(stableID tailcfg.StableNodeID) {
 		go m.SendNotify("设置出口节点", "当前节点用作出口节点，无法使用其他节点作为出口节点", NL_Warn)
 		return
 	}
+	if stableID != "" && !m.confirmExitNodeOnRDP() {
+		m.updateCurrentExitNode(m.data.Prefs.ExitNodeID)
+		return
+	}
 	maskedPrefs := &ipn.MaskedPrefs{
 		Prefs: ipn.Prefs{
 			ExitNodeID: stableID,
@@ -98,20 +115,24 @@ func (m *MiraMenu) setUseExitNode(stableID tailcfg.StableNodeID) {
 	}
 	curPrefs, err := m.lc.GetPrefs(m.ctx)
 	if err != nil {
-		go m.SendNotify("设置出口节点", "获取当前配置失败:"+err.Error(), NL_Error)
+		go m.SendNotify("设置出口节点", "获取当前配置失败:"+humanizeErr(err), NL_Error)
 		return
 	}
 
 	checkPrefs := curPrefs.Clone()
 	checkPrefs.ApplyEdits(maskedPrefs)
 	if err := m.lc.CheckPrefs(m.ctx, checkPrefs); err != nil {
-		go m.SendNotify("设置出口节点", "检查配置失败:"+err.Error(), NL_Error)
+		go m.SendNotify("设置出口节点", "检查配置失败:"+humanizeErr(err), NL_Error)
 		return
 	}
 
 	_, err = m.lc.EditPrefs(m.ctx, maskedPrefs)
 	if err != nil {
-		go m.SendNotify("设置出口节点", "更新配置失败:"+err.Error(), NL_Error)
+		go m.SendNotify("设置出口节点", "更新配置失败:"+humanizeErr(err), NL_Error)
 		return
 	}
+	m.rememberExitNodeChoice(stableID)
+	if stableID != "" && isRemoteSession() {
+		go m.watchExitNodeConnectivity(curPrefs.ExitNodeID)
+	}
 }
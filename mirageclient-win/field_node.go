@@ -1,14 +1,25 @@
 //go:build windows
+
 package main
 
 import (
+	"strings"
+
 	"github.com/tailscale/walk"
 )
 
 // 节点菜单区
 type nodeField struct {
-	nodeAction *walk.Action // 本节点按钮
-	nodesMenu  *walk.Action // 网络设备菜单
+	nodeAction       *walk.Action // 本节点按钮
+	copyAddrMenu     *walk.Action // 复制本机地址菜单
+	copyIPv4Action   *walk.Action // 复制本机IPv4
+	copyIPv6Action   *walk.Action // 复制本机IPv6
+	copyDNSAction    *walk.Action // 复制本机MagicDNS名称
+	nodesMenu        *walk.Action // 网络设备菜单
+	deviceListAction *walk.Action // 设备列表窗口入口
+
+	relayMenu         *walk.Action // 司南中继菜单
+	relayStatusAction *walk.Action // 当前使用中继显示项
 }
 
 func (m *MiraMenu) newNodeField() (nf *nodeField, err error) {
@@ -17,6 +28,38 @@ func (m *MiraMenu) newNodeField() (nf *nodeField, err error) {
 	nf.nodeAction.SetText("本设备")
 	nf.nodeAction.SetVisible(false)
 
+	copyContain, err := walk.NewMenu()
+	if err != nil {
+		return nil, err
+	}
+	nf.copyAddrMenu = walk.NewMenuAction(copyContain)
+	nf.copyAddrMenu.SetText("复制本机地址")
+	nf.copyAddrMenu.SetVisible(false)
+	nf.copyIPv4Action = walk.NewAction()
+	nf.copyIPv4Action.SetText("IPv4地址")
+	nf.copyIPv4Action.Triggered().Attach(func() { m.copySelfAddr("IPv4") })
+	copyContain.Actions().Add(nf.copyIPv4Action)
+	nf.copyIPv6Action = walk.NewAction()
+	nf.copyIPv6Action.SetText("IPv6地址")
+	nf.copyIPv6Action.Triggered().Attach(func() { m.copySelfAddr("IPv6") })
+	copyContain.Actions().Add(nf.copyIPv6Action)
+	nf.copyDNSAction = walk.NewAction()
+	nf.copyDNSAction.SetText("MagicDNS名称")
+	nf.copyDNSAction.Triggered().Attach(func() { m.copySelfAddr("DNS") })
+	copyContain.Actions().Add(nf.copyDNSAction)
+
+	relayContain, err := walk.NewMenu()
+	if err != nil {
+		return nil, err
+	}
+	nf.relayMenu = walk.NewMenuAction(relayContain)
+	nf.relayMenu.SetText("司南中继")
+	nf.relayMenu.SetVisible(false)
+	nf.relayStatusAction = walk.NewAction()
+	nf.relayStatusAction.SetText("当前中继：未知")
+	nf.relayStatusAction.SetEnabled(false)
+	relayContain.Actions().Add(nf.relayStatusAction)
+
 	nodeContain, err := walk.NewMenu()
 	if err != nil {
 		return nil, err
@@ -28,11 +71,59 @@ func (m *MiraMenu) newNodeField() (nf *nodeField, err error) {
 	if err := m.tray.ContextMenu().Actions().Add(nf.nodeAction); err != nil {
 		return nil, err
 	}
+	if err := m.tray.ContextMenu().Actions().Add(nf.copyAddrMenu); err != nil {
+		return nil, err
+	}
+	if err := m.tray.ContextMenu().Actions().Add(nf.relayMenu); err != nil {
+		return nil, err
+	}
 	if err := m.tray.ContextMenu().Actions().Add(nf.nodesMenu); err != nil {
 		return nil, err
 	}
+	nf.deviceListAction = walk.NewAction()
+	nf.deviceListAction.SetText("设备列表…")
+	nf.deviceListAction.SetVisible(false)
+	nf.deviceListAction.Triggered().Attach(func() {
+		go m.mw.Synchronize(m.ShowDeviceWindow)
+	})
+	if err := m.tray.ContextMenu().Actions().Add(nf.deviceListAction); err != nil {
+		return nil, err
+	}
 	if err := m.tray.ContextMenu().Actions().Add(walk.NewSeparatorAction()); err != nil {
 		return nil, err
 	}
 	return nf, nil
 }
+
+// copySelfAddr 复制本机的IPv4/IPv6地址或MagicDNS名称到剪贴板
+func (m *MiraMenu) copySelfAddr(kind string) {
+	netmap := m.data.NetMap
+	if netmap == nil {
+		return
+	}
+	var value string
+	switch kind {
+	case "IPv4":
+		for _, pfx := range netmap.Addresses {
+			if pfx.Addr().Is4() {
+				value = pfx.Addr().String()
+				break
+			}
+		}
+	case "IPv6":
+		for _, pfx := range netmap.Addresses {
+			if pfx.Addr().Is6() && !pfx.Addr().Is4In6() {
+				value = pfx.Addr().String()
+				break
+			}
+		}
+	case "DNS":
+		value = strings.TrimSuffix(netmap.Name, ".")
+	}
+	if value == "" {
+		go m.SendNotify("复制本机地址", "本机暂无可用的"+kind+"地址", NL_Warn)
+		return
+	}
+	walk.Clipboard().SetText(value)
+	go m.SendNotify("复制本机地址", "已复制 ("+value+") 到剪贴板", NL_Info)
+}
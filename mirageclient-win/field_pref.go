@@ -1,4 +1,5 @@
 //go:build windows
+
 package main
 
 import (
@@ -14,9 +15,18 @@ type prefField struct {
 	prefUsingSubnetAction *walk.Action // 配置项 -- 使用子网路由
 	prefUnattendAction    *walk.Action // 配置项 -- 无人值守模式
 
+	companionRelayAction *walk.Action // 配置项 -- 转发重要通知到其他设备
+
 	prefToDefaultAction *walk.Action // 恢复默认设置
 	autoStartUpAction   *walk.Action // 开机自启动
 
+	restartServiceAction *walk.Action // 重启后台服务
+	repairAction         *walk.Action // 一键修复常见故障
+	tunMTUAction         *walk.Action // 隧道MTU设置与检测窗口入口
+	troubleshootAction   *walk.Action // 网络诊断向导入口
+
+	announceHistoryAction *walk.Action // 公告历史窗口入口
+
 	aboutAction *walk.Action // 关于菜单
 }
 
@@ -50,6 +60,11 @@ func (m *MiraMenu) newPrefField() (pf *prefField, err error) {
 	pf.prefUnattendAction.SetCheckable(true)
 	pf.prefUnattendAction.SetChecked(false)
 
+	pf.companionRelayAction = walk.NewAction()
+	pf.companionRelayAction.SetText("转发重要通知到其他设备")
+	pf.companionRelayAction.SetCheckable(true)
+	pf.companionRelayAction.SetChecked(false)
+
 	pf.prefToDefaultAction = walk.NewAction()
 	pf.prefToDefaultAction.SetText("恢复默认设置")
 
@@ -58,6 +73,21 @@ func (m *MiraMenu) newPrefField() (pf *prefField, err error) {
 	pf.autoStartUpAction.SetCheckable(true)
 	pf.autoStartUpAction.SetChecked(false)
 
+	pf.restartServiceAction = walk.NewAction()
+	pf.restartServiceAction.SetText("重启后台服务")
+
+	pf.repairAction = walk.NewAction()
+	pf.repairAction.SetText("修复…")
+
+	pf.tunMTUAction = walk.NewAction()
+	pf.tunMTUAction.SetText("高级：隧道MTU…")
+
+	pf.troubleshootAction = walk.NewAction()
+	pf.troubleshootAction.SetText("网络诊断…")
+
+	pf.announceHistoryAction = walk.NewAction()
+	pf.announceHistoryAction.SetText("公告历史…")
+
 	pf.aboutAction = walk.NewAction()
 	pf.aboutAction.SetText("关于…")
 
@@ -65,9 +95,17 @@ func (m *MiraMenu) newPrefField() (pf *prefField, err error) {
 	pf.prefMenu.Menu().Actions().Add(pf.prefUsingDNSAction)
 	pf.prefMenu.Menu().Actions().Add(pf.prefUsingSubnetAction)
 	pf.prefMenu.Menu().Actions().Add(pf.prefUnattendAction)
+	pf.prefMenu.Menu().Actions().Add(pf.companionRelayAction)
 	pf.prefMenu.Menu().Actions().Add(walk.NewSeparatorAction())
 	pf.prefMenu.Menu().Actions().Add(pf.prefToDefaultAction)
 	pf.prefMenu.Menu().Actions().Add(pf.autoStartUpAction)
+	pf.prefMenu.Menu().Actions().Add(walk.NewSeparatorAction())
+	pf.prefMenu.Menu().Actions().Add(pf.restartServiceAction)
+	pf.prefMenu.Menu().Actions().Add(pf.repairAction)
+	pf.prefMenu.Menu().Actions().Add(pf.tunMTUAction)
+	pf.prefMenu.Menu().Actions().Add(pf.troubleshootAction)
+	pf.prefMenu.Menu().Actions().Add(walk.NewSeparatorAction())
+	pf.prefMenu.Menu().Actions().Add(pf.announceHistoryAction)
 
 	if err := m.tray.ContextMenu().Actions().Add(pf.prefMenu); err != nil {
 		return nil, err
@@ -0,0 +1,130 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"tailscale.com/net/socks5"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/winutil"
+)
+
+// startLocalProxies 按注册表/策略配置启动本地SOCKS5和HTTP代理，
+// 把本机应用的流量经蜃境网络转发。主要面向无法安装TUN驱动、
+// 只能走用户态网络的机器：应用配置localhost代理后仍可访问网内服务。
+//
+// 监听地址分别取策略项 SocksProxyListen 和 HTTPProxyListen
+// （如 "localhost:1080"），未配置则不启动。
+func startLocalProxies(logf logger.Logf, dialer *tsdial.Dialer) {
+	if addr := winutil.GetPolicyString("SocksProxyListen", ""); addr != "" {
+		ln, err := net.Listen("tcp", proxyListenAddr(addr))
+		if err != nil {
+			logf("socks5: listen %q: %v", addr, err)
+		} else {
+			ss := &socks5.Server{
+				Logf:   logger.WithPrefix(logf, "socks5: "),
+				Dialer: dialer.UserDial,
+			}
+			logf("SOCKS5代理监听于 %v", ln.Addr())
+			go func() {
+				log.Printf("SOCKS5代理退出: %v", ss.Serve(ln))
+			}()
+		}
+	}
+	if addr := winutil.GetPolicyString("HTTPProxyListen", ""); addr != "" {
+		ln, err := net.Listen("tcp", proxyListenAddr(addr))
+		if err != nil {
+			logf("httpproxy: listen %q: %v", addr, err)
+		} else {
+			hs := &http.Server{Handler: httpProxyHandler(dialer.UserDial)}
+			logf("HTTP代理监听于 %v", ln.Addr())
+			go func() {
+				log.Printf("HTTP代理退出: %v", hs.Serve(ln))
+			}()
+		}
+	}
+}
+
+// proxyListenAddr 补全监听地址：只给端口时默认绑定回环地址，
+// 避免把代理意外暴露到局域网。
+func proxyListenAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err == nil && host == "" {
+		return "localhost" + addr
+	}
+	if err != nil && !strings.Contains(addr, ":") { // 纯端口数字
+		return "localhost:" + addr
+	}
+	return addr
+}
+
+// httpProxyHandler returns an HTTP proxy http.Handler using the
+// provided backend dialer.
+func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.Conn, error)) http.Handler {
+	rp := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {}, // no change
+		Transport: &http.Transport{
+			DialContext: dialer,
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			backURL := r.RequestURI
+			if strings.HasPrefix(backURL, "/") || backURL == "*" {
+				http.Error(w, "bogus RequestURI; must be absolute URL or CONNECT", 400)
+				return
+			}
+			rp.ServeHTTP(w, r)
+			return
+		}
+
+		// CONNECT support:
+
+		dst := r.RequestURI
+		c, err := dialer(r.Context(), "tcp", dst)
+		if err != nil {
+			w.Header().Set("Mirage-Connect-Error", err.Error())
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer c.Close()
+
+		cc, ccbuf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer cc.Close()
+
+		io.WriteString(cc, "HTTP/1.1 200 OK\r\n\r\n")
+
+		var clientSrc io.Reader = ccbuf
+		if ccbuf.Reader.Buffered() == 0 {
+			// In the common case (with no
+			// buffered data), read directly from
+			// the underlying client connection to
+			// save some memory, letting the
+			// bufio.Reader/Writer get GC'ed.
+			clientSrc = cc
+		}
+
+		errc := make(chan error, 1)
+		go func() {
+			_, err := io.Copy(cc, c)
+			errc <- err
+		}()
+		go func() {
+			_, err := io.Copy(c, clientSrc)
+			errc <- err
+		}()
+		<-errc
+	})
+}
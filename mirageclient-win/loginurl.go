@@ -0,0 +1,62 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/skratchdot/open-golang/open"
+)
+
+// 登录URL去重：后端认证重试期间会反复推送BrowseToURL，旧行为是
+// 每条都拉起浏览器，短时间内开出多个标签页。这里记录已自动打开
+// 过的URL，同一URL只自动打开一次；最近一条始终留存，供托盘菜单
+// 的"打开登录页面"手动重新打开。
+
+var (
+	loginURLMu     sync.Mutex
+	lastLoginURL   string          // 最近一次收到的登录URL
+	openedLoginURL map[string]bool // 已自动打开过的登录URL
+)
+
+// noteLoginURL 记录收到的登录URL，返回是否应当自动打开
+// （该URL尚未自动打开过时返回true并记为已打开）。
+func noteLoginURL(url string) bool {
+	loginURLMu.Lock()
+	defer loginURLMu.Unlock()
+	lastLoginURL = url
+	if openedLoginURL[url] {
+		return false
+	}
+	if openedLoginURL == nil {
+		openedLoginURL = make(map[string]bool)
+	}
+	openedLoginURL[url] = true
+	return true
+}
+
+// resetLoginURL 清除登录URL记录，在登录完成或用户主动发起
+// 新登录时调用，保证下一轮认证的URL能再次自动打开。
+func resetLoginURL() {
+	loginURLMu.Lock()
+	defer loginURLMu.Unlock()
+	lastLoginURL = ""
+	openedLoginURL = nil
+}
+
+// ReopenLoginURL 手动重新打开最近的登录URL（跳过去重）。
+// 没有可用URL时重新发起交互式登录获取新URL。
+func (m *MiraMenu) ReopenLoginURL() {
+	loginURLMu.Lock()
+	url := lastLoginURL
+	loginURLMu.Unlock()
+	if url == "" {
+		log.Printf("[登录URL] 没有待打开的登录URL，重新发起登录")
+		m.DoLogin()
+		return
+	}
+	suppressAutoLoginOpen.Store(false)
+	log.Printf("[登录URL] 手动重新打开: %s", url)
+	open.Run(url)
+}
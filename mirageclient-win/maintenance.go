@@ -0,0 +1,90 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"tailscale.com/util/winutil"
+)
+
+// 维护窗口：无人值守机器可通过策略配置一个时间窗口
+//（MaintenanceWindowStart/MaintenanceWindowEnd，"HH:MM" 24小时制），
+// 客户端在窗口内发现节点密钥临近到期时，使用策略预配的认证密钥
+// 主动续期，避免在工作时间掉线。
+
+// maintenanceCheckInterval 是维护窗口检查的周期
+const maintenanceCheckInterval = 10 * time.Minute
+
+// maintenanceRenewBefore 为密钥到期前多久视作"临近到期"
+const maintenanceRenewBefore = 72 * time.Hour
+
+// maintenanceWindow 读取策略配置的维护窗口，未配置或格式错误时ok为false
+func maintenanceWindow() (start, end time.Duration, ok bool) {
+	startStr := winutil.GetPolicyString("MaintenanceWindowStart", "")
+	endStr := winutil.GetPolicyString("MaintenanceWindowEnd", "")
+	if startStr == "" || endStr == "" {
+		return 0, 0, false
+	}
+	start, err := parseClock(startStr)
+	if err != nil {
+		log.Printf("维护窗口起始时间格式错误（应为HH:MM）: %q", startStr)
+		return 0, 0, false
+	}
+	end, err = parseClock(endStr)
+	if err != nil {
+		log.Printf("维护窗口结束时间格式错误（应为HH:MM）: %q", endStr)
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// parseClock 将"HH:MM"解析为自零点起的时长
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// inMaintenanceWindow 判断now是否落在策略配置的维护窗口内，
+// 支持跨零点的窗口（如23:00-02:00）
+func inMaintenanceWindow(now time.Time) bool {
+	start, end, ok := maintenanceWindow()
+	if !ok {
+		return false
+	}
+	cur := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	if start <= end {
+		return cur >= start && cur < end
+	}
+	return cur >= start || cur < end
+}
+
+// watchMaintenanceReauth 周期检查维护窗口，窗口内若节点密钥临近到期
+// 则用策略认证密钥自动续期认证
+func (m *MiraMenu) watchMaintenanceReauth() {
+	ticker := time.NewTicker(maintenanceCheckInterval)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		ticker.Reset(powerAwareInterval(maintenanceCheckInterval))
+		if policyAuthKey() == "" {
+			continue
+		}
+		if !inMaintenanceWindow(time.Now()) {
+			continue
+		}
+		nm := m.data.NetMap
+		if nm == nil || nm.SelfNode == nil {
+			continue
+		}
+		expiry := nm.SelfNode.KeyExpiry
+		if expiry.IsZero() || time.Until(expiry) > maintenanceRenewBefore {
+			continue
+		}
+		log.Printf("[维护窗口] 节点密钥将于 %v 到期，自动续期认证", expiry)
+		m.tryAutoRelogin()
+	}
+}
@@ -27,6 +27,10 @@ var args struct { // 命令行参数部分
 	tunGUID              string // 执行防火墙调整参数
 	asServiceSubProc     bool   // 作为后台服务子进程被调用
 	logid                string // 后台服务日志使用的logtail ID参数
+	asUserMode           bool   // 用户模式（无需管理员权限，功能受限）
+	asServiceRepairer    bool   // 执行服务修复（重写服务登记的程序路径）
+	asServiceRestarter   bool   // 执行服务重启
+	demoScript           string // 模拟IPN总线脚本（UI测试/演示用，隐藏参数）
 } // 启动参数
 
 var watcher *MiraWatcher // 通讯协程实体
@@ -39,11 +43,15 @@ func main() {
 	flag.StringVar(&args.tunGUID, "tunGUID", "", "管理防火墙使用tun的GUID值")
 	flag.BoolVar(&args.asServiceSubProc, "subproc", false, "是否服务的子进程调用")
 	flag.StringVar(&args.logid, "logid", "", "服务子进程使用的logtail ID值")
+	flag.BoolVar(&args.asUserMode, "user-mode", false, "以当前用户身份运行（无需管理员权限，功能受限）")
+	flag.BoolVar(&args.asServiceRepairer, "repair-service", false, "修复后台服务登记的程序路径")
+	flag.BoolVar(&args.asServiceRestarter, "restart-service", false, "重启后台服务")
+	flag.StringVar(&args.demoScript, "demo-script", "", "") // 隐藏参数：模拟IPN总线脚本路径或"builtin"
 	flag.Parse()
 
 	isService, _ := svc.IsWindowsService()
 
-	if args.asServiceInstaller || args.asServiceUninstaller || args.asFirewallKillswitch || args.asServiceSubProc || isService {
+	if args.asServiceInstaller || args.asServiceUninstaller || args.asServiceRepairer || args.asServiceRestarter || args.asFirewallKillswitch || args.asServiceSubProc || isService {
 		envknob.PanicIfAnyEnvCheckedInInit()
 		envknob.ApplyDiskConfig()
 		// 开局先屏蔽TS的日志 （但后续保留日志设置，以防后续我们希望使用logtail）
@@ -68,6 +76,16 @@ func main() {
 			return //结束安装
 		}
 
+		// 判断是否是服务修复
+		if isServiceRepairer() {
+			return //结束修复
+		}
+
+		// 判断是否是服务重启
+		if isServiceRestarter() {
+			return //结束重启
+		}
+
 		// 判断是否子进程
 		if beWindowsSubprocess() {
 			return //结束子进程
@@ -92,6 +110,14 @@ func main() {
 		return
 	}
 
+	// ARM64设备上以模拟方式运行时提示换用原生版本
+	logArchInfo()
+
+	// 用户模式：守护进程随客户端进程内运行
+	if args.asUserMode {
+		startUserMode()
+	}
+
 	// 创建与后台服务的通讯员
 	watcher = NewWatcher()
 
@@ -10,6 +10,7 @@ import (
 
 	"github.com/tailscale/walk"
 	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn"
 )
 
 type MiraMenu struct {
@@ -36,6 +37,10 @@ type MiraMenu struct {
 
 	debugAction *walk.Action
 	exitAction  *walk.Action
+
+	forcedRelayRegion int // 用户指定的优先中继区域ID（0为自动选择）
+
+	lastState ipn.State // 上一次收到的连接状态，用于识别异常掉登录
 }
 
 func (s *MiraMenu) Init() {
@@ -105,6 +110,8 @@ func (s *MiraMenu) Init() {
 		log.Printf("初始化调试菜单区错误：%s", err)
 	}
 
+	s.createFields()
+
 	s.exitAction = walk.NewAction()
 	s.exitAction.SetText("退出")
 	s.exitAction.Triggered().Attach(func() {
@@ -114,6 +121,7 @@ func (s *MiraMenu) Init() {
 	s.tray.ContextMenu().Actions().Add(s.exitAction)
 
 	s.connectField.loginAction.Triggered().Attach(s.DoLogin)
+	s.connectField.reopenLoginAction.Triggered().Attach(s.ReopenLoginURL)
 	s.userField.logoutAction.Triggered().Attach(s.DoLogout)
 	s.connectField.connectAction.Triggered().Attach(s.DoConn)
 	s.connectField.disconnectAction.Triggered().Attach(s.DoDisconn)
@@ -125,10 +133,28 @@ func (s *MiraMenu) Init() {
 	s.prefField.prefUsingDNSAction.Triggered().Attach(s.SetDNSOpt)
 	s.prefField.prefUsingSubnetAction.Triggered().Attach(s.SetSubnetOpt)
 	s.prefField.prefUnattendAction.Triggered().Attach(s.SetUnattendOpt)
+	s.prefField.companionRelayAction.SetChecked(loadCompanionRelay())
+	s.prefField.companionRelayAction.Triggered().Attach(s.SetCompanionRelayOpt)
 	s.prefField.prefToDefaultAction.Triggered().Attach(s.SetPrefsDefault)
 
+	s.prefField.restartServiceAction.Triggered().Attach(s.RestartBackendService)
+	s.prefField.repairAction.Triggered().Attach(s.RunRepairSequence)
+
+	s.prefField.tunMTUAction.Triggered().Attach(func() {
+		go s.mw.Synchronize(s.ShowMTUWindow)
+	})
+	s.prefField.troubleshootAction.Triggered().Attach(func() {
+		go s.mw.Synchronize(s.ShowTroubleshootWindow)
+	})
+
+	s.prefField.announceHistoryAction.Triggered().Attach(func() {
+		go s.mw.Synchronize(s.ShowAnnounceHistory)
+	})
+
 	s.prefField.aboutAction.Triggered().Attach(s.ShowAbout)
 
+	s.bindFieldEvents()
+
 	s.nodeField.nodeAction.Triggered().Attach(func() {
 		if s.data.NetMap != nil {
 			selfIPv4 := s.data.NetMap.Addresses[0].Addr()
@@ -143,6 +169,8 @@ func (s *MiraMenu) Init() {
 	})
 
 	s.bindDataPool()
+
+	s.initCompanionRelay()
 }
 
 func (s *MiraMenu) setIcon(state IconType) {
@@ -184,7 +212,7 @@ func (s *MiraMenu) Start() {
 		msg := <-firstRx
 		switch msg.(type) {
 		case error:
-			confirm := PopConfirmDlg("严重错误", "程序通讯员报错:"+msg.(error).Error()+" 无法执行，重试还是退出？", 300, 150)
+			confirm := PopConfirmDlg("严重错误", "程序通讯员报错:"+humanizeErr(msg.(error))+" 无法执行，重试还是退出？", 300, 150)
 			if confirm {
 				s.cancel()
 				s.ctx, s.cancel = context.WithCancel(context.Background())
@@ -194,6 +222,9 @@ func (s *MiraMenu) Start() {
 				return
 			}
 		case *WatcherUpEvent:
+			if userModeActive() {
+				s.SendNotify("用户模式", "当前以用户模式运行，无需管理员权限，但子网路由、出口节点等功能不可用", NL_Info)
+			}
 			isAutoStartUp, err := s.lc.GetAutoStartUp(s.ctx)
 			if err != nil {
 				log.Printf("获取自启动状态失败：%s", err)
@@ -206,14 +237,14 @@ func (s *MiraMenu) Start() {
 
 			prefs, err := s.lc.GetPrefs(s.ctx)
 			if err != nil {
-				s.SendNotify("加载配置错误", err.Error(), NL_Error) // 通知栏提示
+				s.SendNotify("加载配置错误", humanizeErr(err), NL_Error) // 通知栏提示
 				log.Printf("加载配置错误：%s", err)
 				return
 			}
 			log.Printf("加载配置：%v", prefs)
 			st, err := s.lc.Status(s.ctx)
 			if err != nil {
-				s.SendNotify("加载状态错误", err.Error(), NL_Error) // 通知栏提示
+				s.SendNotify("加载状态错误", humanizeErr(err), NL_Error) // 通知栏提示
 				log.Printf("加载状态错误：%s", err)
 				return
 			}
@@ -223,6 +254,14 @@ func (s *MiraMenu) Start() {
 			s.data.SetVersion(st.Version)
 			s.data.SetState(st.BackendState)
 
+			go s.watchAnnouncements()
+			go s.watchCustomLinks()
+			go s.watchNetworkForExitNode()
+			go s.watchRelayStatus()
+			go s.watchRouteConflicts()
+			go s.watchMaintenanceReauth()
+			go s.watchPowerStatus()
+
 			s.mw.Run()
 		}
 	}
@@ -0,0 +1,224 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+)
+
+// 模拟IPN事件总线。通过隐藏参数 -demo-script 启用后，通讯协程不再
+// 连接后台服务，而是按脚本把网络图、首选项和状态变化灌进事件管道，
+// 用于UI自动化测试和无守护进程的演示。脚本值为 "builtin" 时使用
+// 内置演示脚本，否则视为JSON脚本文件路径。
+//
+// 注意：模拟模式下菜单操作（连接、登录等）仍会尝试调用LocalAPI并
+// 失败，这是预期行为——该模式只负责喂数据。
+
+// mockScript 是脚本文件的顶层结构。
+type mockScript struct {
+	Loop  bool       `json:"loop"`  // 播完后是否从头循环
+	Steps []mockStep `json:"steps"` // 按序执行的事件步骤
+}
+
+// mockStep 是一个事件步骤；各字段均可选，省略的不产生事件。
+type mockStep struct {
+	DelayMs  int             `json:"delayMs"`            // 本步骤前的等待
+	State    string          `json:"state,omitempty"`    // ipn.State 名称，如 "Running"
+	LoginURL string          `json:"loginURL,omitempty"` // 模拟浏览器登录URL事件
+	Prefs    json.RawMessage `json:"prefs,omitempty"`    // ipn.Prefs 的JSON
+	Netmap   *mockNetmap     `json:"netmap,omitempty"`   // 简化的网络图描述
+}
+
+// mockNetmap 是简化的网络图描述，会被补全成 netmap.NetworkMap。
+type mockNetmap struct {
+	SelfName  string     `json:"selfName"` // 本机DNS名，如 "demo-pc.mirage."
+	SelfIP    string     `json:"selfIP"`   // 本机蜃境IP
+	LoginName string     `json:"loginName"`
+	Peers     []mockPeer `json:"peers"`
+}
+
+type mockPeer struct {
+	Name     string `json:"name"`
+	IP       string `json:"ip"`
+	Online   bool   `json:"online"`
+	ExitNode bool   `json:"exitNode"`
+	Routes   string `json:"routes,omitempty"` // 逗号分隔的子网路由
+}
+
+var mockStateNames = map[string]ipn.State{
+	"NoState":          ipn.NoState,
+	"InUseOtherUser":   ipn.InUseOtherUser,
+	"NeedsLogin":       ipn.NeedsLogin,
+	"NeedsMachineAuth": ipn.NeedsMachineAuth,
+	"Stopped":          ipn.Stopped,
+	"Starting":         ipn.Starting,
+	"Running":          ipn.Running,
+}
+
+// builtinMockScript 是内置演示脚本：登录→启动→运行，带两个对端
+// （其中一个出口节点），之后保持运行状态。
+const builtinMockScript = `{
+	"steps": [
+		{"state": "NeedsLogin"},
+		{"delayMs": 1500, "state": "Starting"},
+		{"delayMs": 500, "prefs": {"WantRunning": true, "CorpDNS": true, "RouteAll": true}},
+		{"netmap": {
+			"selfName": "demo-pc.mirage.",
+			"selfIP": "100.100.0.1",
+			"loginName": "demo@mirage",
+			"peers": [
+				{"name": "demo-nas.mirage.", "ip": "100.100.0.2", "online": true},
+				{"name": "demo-gw.mirage.", "ip": "100.100.0.3", "online": true, "exitNode": true}
+			]
+		}},
+		{"delayMs": 300, "state": "Running"}
+	]
+}`
+
+// runMockFeed 读取脚本并把事件灌入通讯管道，阻塞直到脚本播完
+// （loop 时直到 watcher 被停止）。
+func (w *MiraWatcher) runMockFeed(scriptSpec string) error {
+	var raw []byte
+	if scriptSpec == "builtin" {
+		raw = []byte(builtinMockScript)
+	} else {
+		b, err := os.ReadFile(scriptSpec)
+		if err != nil {
+			return fmt.Errorf("读取模拟脚本失败: %w", err)
+		}
+		raw = b
+	}
+	var script mockScript
+	if err := json.Unmarshal(raw, &script); err != nil {
+		return fmt.Errorf("解析模拟脚本失败: %w", err)
+	}
+
+	log.Printf("[通讯兵] 模拟模式启动，共%d个步骤", len(script.Steps))
+	w.publish(new(WatcherUpEvent))
+	w.publish(BackendVersion("demo"))
+
+	for {
+		for i, step := range script.Steps {
+			if step.DelayMs > 0 {
+				select {
+				case <-time.After(time.Duration(step.DelayMs) * time.Millisecond):
+				case <-w.ctx.Done():
+					return nil
+				}
+			}
+			if err := w.playMockStep(i, step); err != nil {
+				return err
+			}
+		}
+		if !script.Loop {
+			break
+		}
+	}
+	// 脚本播完后保持静默，直到被停止
+	<-w.ctx.Done()
+	return nil
+}
+
+func (w *MiraWatcher) playMockStep(i int, step mockStep) error {
+	if step.Prefs != nil {
+		prefs := ipn.NewPrefs()
+		if err := json.Unmarshal(step.Prefs, prefs); err != nil {
+			return fmt.Errorf("脚本步骤%d: prefs: %w", i, err)
+		}
+		w.publish(prefs)
+	}
+	if step.Netmap != nil {
+		nm, err := step.Netmap.build()
+		if err != nil {
+			return fmt.Errorf("脚本步骤%d: netmap: %w", i, err)
+		}
+		w.publish(nm)
+	}
+	if step.State != "" {
+		st, ok := mockStateNames[step.State]
+		if !ok {
+			return fmt.Errorf("脚本步骤%d: 未知状态 %q", i, step.State)
+		}
+		w.publish(st)
+	}
+	if step.LoginURL != "" {
+		// 真实通讯兵会打开浏览器；模拟模式只记录
+		log.Printf("[通讯兵] 模拟登录URL: %s", step.LoginURL)
+	}
+	return nil
+}
+
+// build 把简化描述补全为UI各菜单区所需字段齐全的网络图。
+func (s *mockNetmap) build() (*netmap.NetworkMap, error) {
+	selfIP, err := netip.ParseAddr(s.SelfIP)
+	if err != nil {
+		return nil, fmt.Errorf("selfIP: %w", err)
+	}
+	const selfUser = tailcfg.UserID(1)
+	self := &tailcfg.Node{
+		ID:        1,
+		StableID:  "demo-self",
+		Name:      s.SelfName,
+		User:      selfUser,
+		Key:       key.NewNode().Public(),
+		Addresses: []netip.Prefix{netip.PrefixFrom(selfIP, selfIP.BitLen())},
+		KeyExpiry: time.Now().AddDate(1, 0, 0),
+	}
+	nm := &netmap.NetworkMap{
+		SelfNode:  self,
+		Name:      s.SelfName,
+		Addresses: self.Addresses,
+		UserProfiles: map[tailcfg.UserID]tailcfg.UserProfile{
+			selfUser: {
+				ID:          selfUser,
+				LoginName:   s.LoginName,
+				DisplayName: s.LoginName,
+			},
+		},
+	}
+	for i, p := range s.Peers {
+		ip, err := netip.ParseAddr(p.IP)
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: %w", p.Name, err)
+		}
+		online := p.Online
+		node := &tailcfg.Node{
+			ID:        tailcfg.NodeID(i + 2),
+			StableID:  tailcfg.StableNodeID(fmt.Sprintf("demo-peer-%d", i)),
+			Name:      p.Name,
+			User:      selfUser,
+			Key:       key.NewNode().Public(),
+			Addresses: []netip.Prefix{netip.PrefixFrom(ip, ip.BitLen())},
+			Online:    &online,
+			KeyExpiry: time.Now().AddDate(1, 0, 0),
+		}
+		node.AllowedIPs = append(node.AllowedIPs, node.Addresses...)
+		if p.ExitNode {
+			node.AllowedIPs = append(node.AllowedIPs, tsaddr.AllIPv4(), tsaddr.AllIPv6())
+		}
+		if p.Routes != "" {
+			for _, r := range strings.Split(p.Routes, ",") {
+				pfx, err := netip.ParsePrefix(strings.TrimSpace(r))
+				if err != nil {
+					return nil, fmt.Errorf("peer %s: route %q: %w", p.Name, r, err)
+				}
+				node.AllowedIPs = append(node.AllowedIPs, pfx)
+				node.PrimaryRoutes = append(node.PrimaryRoutes, pfx)
+			}
+		}
+		nm.Peers = append(nm.Peers, node)
+	}
+	return nm, nil
+}
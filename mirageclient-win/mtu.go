@@ -0,0 +1,216 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tailscale/walk"
+	"github.com/tailscale/win"
+)
+
+// 隧道MTU高级设置：默认MTU(1280)在PPPoE等链路上仍可能遇到路径MTU
+// 黑洞（小包通、大包悄悄丢）。这里提供图形化的MTU覆盖设置和黑洞
+// 检测，代替以前手工改注册表再重启服务的做法。覆盖值保存在数据
+// 目录的 tun-mtu.conf 中，守护进程启动时读取并通过 TS_DEBUG_MTU
+// 环境变量传给引擎，重启后台服务后生效。
+
+// tunMTU 允许设置的范围。下限为IPv6最小MTU。
+const (
+	tunMTUMin = 1280
+	tunMTUMax = 65536
+)
+
+func tunMTUPath() string {
+	return filepath.Join(programPath, "tun-mtu.conf")
+}
+
+// loadTunMTU 读取保存的MTU覆盖值，未设置或无效时返回0（使用默认）。
+func loadTunMTU() int {
+	b, err := os.ReadFile(tunMTUPath())
+	if err != nil {
+		return 0
+	}
+	mtu, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || mtu < tunMTUMin || mtu > tunMTUMax {
+		return 0
+	}
+	return mtu
+}
+
+// saveTunMTU 保存MTU覆盖值，0表示清除覆盖、恢复默认。
+func saveTunMTU(mtu int) error {
+	if mtu == 0 {
+		err := os.Remove(tunMTUPath())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(tunMTUPath(), []byte(strconv.Itoa(mtu)+"\n"), 0600)
+}
+
+// applyTunMTUOverride 在守护进程启动时应用保存的MTU覆盖值。
+// 已有TS_DEBUG_MTU环境变量时不覆盖，便于临时排障。
+func applyTunMTUOverride(logf func(format string, args ...any)) {
+	if os.Getenv("TS_DEBUG_MTU") != "" {
+		return
+	}
+	if mtu := loadTunMTU(); mtu != 0 {
+		os.Setenv("TS_DEBUG_MTU", strconv.Itoa(mtu))
+		logf("应用隧道MTU覆盖值: %d", mtu)
+	}
+}
+
+// pathMTUProbeTarget 是路径MTU检测的目标地址（AliDNS anycast）。
+const pathMTUProbeTarget = "223.5.5.5"
+
+// pingDF 用系统ping发一个置DF位、载荷size字节的探测包，返回是否
+// 收到应答。应答行在中英文系统上都含有"TTL="。
+func pingDF(target string, size int) bool {
+	out, _ := exec.Command("ping", "-f", "-l", strconv.Itoa(size),
+		"-n", "1", "-w", "2000", target).CombinedOutput()
+	return strings.Contains(string(out), "TTL=")
+}
+
+// detectPathMTU 通过二分探测返回到公网的路径MTU（探测载荷+28字节
+// IP/ICMP头）。探测不到基础连通性时返回错误。
+func detectPathMTU() (int, error) {
+	// 1472载荷对应标准1500路径，通则无黑洞，不必继续
+	if pingDF(pathMTUProbeTarget, 1472) {
+		return 1500, nil
+	}
+	lo, hi := 472, 1471 // 载荷区间，对应路径MTU 500~1499
+	if !pingDF(pathMTUProbeTarget, lo) {
+		return 0, fmt.Errorf("最小探测包也无应答，当前网络不可达或目标屏蔽了ICMP")
+	}
+	for lo < hi { // 不变式：lo通，hi+1不通
+		mid := (lo + hi + 1) / 2
+		if pingDF(pathMTUProbeTarget, mid) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo + 28, nil
+}
+
+// suggestTunMTU 根据路径MTU给出建议的隧道MTU（扣除WireGuard封装
+// 开销，按IPv6外层的80字节算），不低于下限。
+func suggestTunMTU(pathMTU int) int {
+	mtu := pathMTU - 80
+	if mtu < tunMTUMin {
+		mtu = tunMTUMin
+	}
+	return mtu
+}
+
+// ShowMTUWindow 弹出隧道MTU设置与检测窗口（配置项菜单"高级：隧道MTU…"）。
+func (m *MiraMenu) ShowMTUWindow() {
+	const w, h = 380, 260
+	dlg, err := walk.NewDialogWithFixedSize(nil)
+	if err != nil {
+		log.Printf("[MTU] 创建窗口出错: %v", err)
+		return
+	}
+	dlg.SetTitle("隧道MTU设置")
+	dlg.SetIcon(Icons[Logo])
+	dlg.SetMinMaxSize(walk.Size{Width: w, Height: h}, walk.Size{Width: w, Height: h})
+	dlg.SetX(int(win.GetSystemMetrics(win.SM_CXSCREEN)/2 - w/2))
+	dlg.SetY(int(win.GetSystemMetrics(win.SM_CYSCREEN)/2 - h/2))
+	dlg.SetLayout(walk.NewVBoxLayout())
+
+	tip, err := walk.NewTextLabel(dlg)
+	if err != nil {
+		log.Printf("[MTU] 创建标签出错: %v", err)
+		return
+	}
+	tip.SetText(fmt.Sprintf("覆盖隧道MTU（%d~%d，留空恢复默认1280），\n保存后需重启后台服务生效。\n怀疑大包丢失（常见于PPPoE拨号）时可先检测。", tunMTUMin, tunMTUMax))
+
+	input, err := walk.NewLineEdit(dlg)
+	if err != nil {
+		log.Printf("[MTU] 创建输入框出错: %v", err)
+		return
+	}
+	if cur := loadTunMTU(); cur != 0 {
+		input.SetText(strconv.Itoa(cur))
+	}
+
+	result, err := walk.NewTextLabel(dlg)
+	if err != nil {
+		log.Printf("[MTU] 创建结果标签出错: %v", err)
+		return
+	}
+	result.SetMinMaxSize(walk.Size{Width: w - 20, Height: 60}, walk.Size{Width: w - 20, Height: 60})
+
+	row, err := walk.NewComposite(dlg)
+	if err != nil {
+		log.Printf("[MTU] 创建按钮区出错: %v", err)
+		return
+	}
+	row.SetLayout(walk.NewHBoxLayout())
+
+	detectBtn, err := walk.NewPushButton(row)
+	if err == nil {
+		detectBtn.SetText("检测路径MTU")
+		detectBtn.Clicked().Attach(func() {
+			detectBtn.SetEnabled(false)
+			result.SetText("正在检测（约需数秒）……")
+			go func() {
+				pathMTU, err := detectPathMTU()
+				m.mw.Synchronize(func() {
+					detectBtn.SetEnabled(true)
+					if err != nil {
+						result.SetText("检测失败：" + err.Error())
+						return
+					}
+					if pathMTU >= 1500 {
+						result.SetText("路径MTU为1500，未发现黑洞，无需覆盖。")
+						return
+					}
+					suggest := suggestTunMTU(pathMTU)
+					result.SetText(fmt.Sprintf("检测到路径MTU为 %d（存在大包受限），\n建议隧道MTU设为 %d，已填入输入框。", pathMTU, suggest))
+					input.SetText(strconv.Itoa(suggest))
+				})
+			}()
+		})
+	}
+
+	saveBtn, err := walk.NewPushButton(row)
+	if err == nil {
+		saveBtn.SetText("保存")
+		saveBtn.Clicked().Attach(func() {
+			text := strings.TrimSpace(input.Text())
+			mtu := 0
+			if text != "" {
+				v, err := strconv.Atoi(text)
+				if err != nil || v < tunMTUMin || v > tunMTUMax {
+					result.SetText(fmt.Sprintf("无效的MTU值，应为 %d~%d 之间的整数或留空。", tunMTUMin, tunMTUMax))
+					return
+				}
+				mtu = v
+			}
+			if err := saveTunMTU(mtu); err != nil {
+				result.SetText("保存失败：" + humanizeErr(err))
+				return
+			}
+			log.Printf("[MTU] 隧道MTU覆盖值已保存: %d (0为默认)", mtu)
+			dlg.Accept()
+			go m.RestartBackendService()
+		})
+	}
+
+	cancelBtn, err := walk.NewPushButton(row)
+	if err == nil {
+		cancelBtn.SetText("取消")
+		cancelBtn.Clicked().Attach(dlg.Cancel)
+	}
+
+	dlg.Run()
+}
@@ -0,0 +1,86 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// 省电模式联动：笔记本开启Windows节电模式后，把各后台轮询（公告、
+// 自定义链接、网络切换检测、路由冲突检测、中继状态等非关键流量）
+// 的间隔放大，减少唤醒和网络活动；菜单上显示一条状态行提示当前
+// 处于省电运行状态。隧道本身的连接不受影响。
+
+// powerSaveFactor 是省电模式下各轮询间隔的放大倍数。
+const powerSaveFactor = 4
+
+// powerStatusCheckInterval 是检测系统电源状态的间隔。
+const powerStatusCheckInterval = 30 * time.Second
+
+// systemPowerStatus 对应Windows的SYSTEM_POWER_STATUS结构。
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte // 1表示节电模式已开启
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+var getSystemPowerStatusProc = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+var powerSaverOn atomic.Bool
+
+// batterySaverActive 返回系统当前是否处于节电模式。
+func batterySaverActive() bool {
+	return powerSaverOn.Load()
+}
+
+// queryBatterySaver 查询一次系统电源状态，查询失败按未开启处理。
+func queryBatterySaver() bool {
+	var st systemPowerStatus
+	r, _, _ := getSystemPowerStatusProc.Call(uintptr(unsafe.Pointer(&st)))
+	if r == 0 {
+		return false
+	}
+	return st.SystemStatusFlag == 1
+}
+
+// powerAwareInterval 返回考虑省电模式后的轮询间隔：节电模式下放大
+// powerSaveFactor倍。各后台轮询每轮用它重置自己的定时器。
+func powerAwareInterval(base time.Duration) time.Duration {
+	if batterySaverActive() {
+		return base * powerSaveFactor
+	}
+	return base
+}
+
+// watchPowerStatus 周期性检测节电模式开关，更新菜单状态行并记录
+// 切换日志。
+func (m *MiraMenu) watchPowerStatus() {
+	ticker := time.NewTicker(powerStatusCheckInterval)
+	defer ticker.Stop()
+	for {
+		on := queryBatterySaver()
+		if on != powerSaverOn.Swap(on) {
+			if on {
+				log.Printf("[省电] 检测到系统节电模式开启，后台轮询间隔放大%d倍", powerSaveFactor)
+			} else {
+				log.Printf("[省电] 系统节电模式已关闭，后台轮询恢复正常频率")
+			}
+			m.mw.Synchronize(func() {
+				m.connectField.powerSaveAction.SetVisible(on)
+			})
+		}
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
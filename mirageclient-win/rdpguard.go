@@ -0,0 +1,43 @@
+//go:build windows
+
+package main
+
+import (
+	"time"
+
+	"github.com/tailscale/win"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// exitNodeRevertTimeout 是启用出口节点后检验对外连通性的窗口：
+// 窗口内控制器持续不可达则自动回退原设置。
+const exitNodeRevertTimeout = 30 * time.Second
+
+// isRemoteSession 报告当前会话是否为RDP远程桌面会话。
+func isRemoteSession() bool {
+	return win.GetSystemMetrics(win.SM_REMOTESESSION) != 0
+}
+
+// confirmExitNodeOnRDP 在RDP会话中启用出口节点前要求用户显式确认：
+// 远程桌面流量会改经出口节点转发，远程连接很可能因此中断。
+// 非RDP会话直接放行。
+func (m *MiraMenu) confirmExitNodeOnRDP() bool {
+	if !isRemoteSession() {
+		return true
+	}
+	return PopConfirmDlg("远程桌面警告",
+		"当前处于远程桌面(RDP)会话中。启用出口节点后，本机流量（包括远程桌面连接）将改经出口节点转发，远程连接可能会中断。\n\n若应用后与控制器失去连接，将在"+exitNodeRevertTimeout.String()+"内自动恢复原设置。\n\n是否继续？",
+		320, 200)
+}
+
+// watchExitNodeConnectivity 在RDP会话中应用出口节点后检查控制器是否
+// 仍可达，超时窗口内持续不可达则自动回退到之前的出口节点设置并通知。
+func (m *MiraMenu) watchExitNodeConnectivity(prev tailcfg.StableNodeID) {
+	m.watchPrefCommit("设置出口节点", &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			ExitNodeID: prev,
+		},
+		ExitNodeIDSet: true,
+	})
+}
@@ -0,0 +1,98 @@
+//go:build windows
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tailscale/walk"
+	"tailscale.com/ipn"
+	"tailscale.com/util/winutil"
+)
+
+// relayOverrideAllowed 控制器策略是否允许用户指定优先中继
+func relayOverrideAllowed() bool {
+	return winutil.GetPolicyString("ForbidRelayOverride", "") == ""
+}
+
+// rebuildRelayMenu 依据网络图中的司南区域重建中继菜单。
+// 需在UI线程调用
+func (m *MiraMenu) rebuildRelayMenu() {
+	nf := m.nodeField
+	actions := nf.relayMenu.Menu().Actions()
+	actions.Clear()
+	actions.Add(nf.relayStatusAction)
+	if m.data.NetMap == nil || m.data.NetMap.DERPMap == nil || !relayOverrideAllowed() {
+		return
+	}
+	actions.Add(walk.NewSeparatorAction())
+
+	autoAction := walk.NewAction()
+	autoAction.SetText("自动选择")
+	autoAction.SetCheckable(true)
+	autoAction.SetChecked(m.forcedRelayRegion == 0)
+	autoAction.Triggered().Attach(func() { m.setPreferredRelay(0, "自动选择") })
+	actions.Add(autoAction)
+
+	regionIDs := make([]int, 0, len(m.data.NetMap.DERPMap.Regions))
+	for rid := range m.data.NetMap.DERPMap.Regions {
+		regionIDs = append(regionIDs, rid)
+	}
+	sort.Ints(regionIDs)
+	for _, rid := range regionIDs {
+		region := m.data.NetMap.DERPMap.Regions[rid]
+		if region == nil {
+			continue
+		}
+		name := region.RegionName
+		if name == "" {
+			name = region.RegionCode
+		}
+		rid := rid
+		regionAction := walk.NewAction()
+		regionAction.SetText(name)
+		regionAction.SetCheckable(true)
+		regionAction.SetChecked(m.forcedRelayRegion == rid)
+		regionAction.Triggered().Attach(func() { m.setPreferredRelay(rid, name) })
+		actions.Add(regionAction)
+	}
+}
+
+// setPreferredRelay 指定优先中继区域（0恢复自动选择）
+func (m *MiraMenu) setPreferredRelay(regionID int, name string) {
+	if err := m.lc.DebugForcePreferredDERP(m.ctx, regionID); err != nil {
+		go m.SendNotify("指定优先中继", "设置优先中继失败："+humanizeErr(err), NL_Error)
+		return
+	}
+	m.forcedRelayRegion = regionID
+	m.rebuildRelayMenu()
+	if regionID == 0 {
+		go m.SendNotify("指定优先中继", "已恢复自动选择中继", NL_Info)
+	} else {
+		go m.SendNotify("指定优先中继", "已指定优先中继："+name, NL_Info)
+	}
+}
+
+// watchRelayStatus 周期刷新菜单中显示的当前使用司南中继
+func (m *MiraMenu) watchRelayStatus() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		ticker.Reset(powerAwareInterval(30 * time.Second))
+		if m.data.State != ipn.Running {
+			continue
+		}
+		st, err := m.lc.Status(m.ctx)
+		if err != nil || st.Self == nil {
+			continue
+		}
+		text := "当前中继：未知"
+		if st.Self.Relay != "" {
+			text = "当前中继：" + st.Self.Relay
+		}
+		m.mw.Synchronize(func() {
+			m.nodeField.relayStatusAction.SetText(text)
+		})
+	}
+}
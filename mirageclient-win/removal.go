@@ -0,0 +1,57 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+	"sync/atomic"
+
+	"tailscale.com/ipn"
+)
+
+// 控制器侧移除/过期处理：管理员在控制台删除设备或设备登记失效时，
+// 后端会从Running直接跌回NeedsLogin并持续推送登录URL，旧行为是
+// 反复拉起浏览器打开登录页。这里识别"非用户主动的掉登录"：暂停
+// 自动打开登录URL，只弹一次明确的对话框让用户决定是否重新登记。
+
+// suppressAutoLoginOpen 置位时通讯协程不自动打开收到的登录URL。
+// 用户主动点击"登录"或在移除对话框中确认重新登记时复位。
+var suppressAutoLoginOpen atomic.Bool
+
+// userInitiatedLogout 置位表示最近一次登出是用户主动操作（点击
+// 登出菜单），由 DoLogout 设置，状态跌回 NeedsLogin 时消费复位。
+var userInitiatedLogout atomic.Bool
+
+// noteStateForRemoval 由状态变化处理调用，检测 Running→NeedsLogin
+// 的非用户主动转换。prev 是本次变化前的状态。
+func (m *MiraMenu) noteStateForRemoval(prev, cur ipn.State) {
+	if cur != ipn.NeedsLogin {
+		return
+	}
+	if userInitiatedLogout.Swap(false) {
+		return // 用户自己点的登出
+	}
+	if prev != ipn.Running {
+		return // 正常的未登录启动等，不算移除
+	}
+	if policyAuthKey() != "" {
+		return // kiosk场景交给自动重新认证处理
+	}
+	log.Printf("[移除检测] 连接状态非用户主动跌回未登录，疑似设备被管理员移除或登记失效")
+	go m.relayToCompanions("设备掉出网络",
+		"该设备非用户主动地退回了未登录状态，可能已被管理员移除或登记失效", NL_Warn)
+	go m.handleControllerRemoval()
+}
+
+// handleControllerRemoval 提示设备已被移除并提供重新登记入口。
+func (m *MiraMenu) handleControllerRemoval() {
+	suppressAutoLoginOpen.Store(true)
+	confirm := PopConfirmDlg("设备已被移除",
+		"本设备已被管理员移除或登记已失效，已停止自动弹出登录页面。\n是否现在重新登记本设备？", 320, 160)
+	if confirm {
+		suppressAutoLoginOpen.Store(false)
+		m.lc.StartLoginInteractive(m.ctx)
+		return
+	}
+	go m.SendNotify("设备已被移除", "可稍后通过菜单中的\"登录\"重新登记本设备", NL_Warn)
+}
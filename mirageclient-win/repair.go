@@ -0,0 +1,99 @@
+//go:build windows
+
+package main
+
+import (
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+// RestartBackendService 重启后台服务（托盘"重启后台服务"菜单项）
+func (m *MiraMenu) RestartBackendService() {
+	if userModeActive() {
+		go m.SendNotify("重启后台服务", "用户模式下守护进程随本程序运行，无需重启服务", NL_Info)
+		return
+	}
+	m.tray.SetVisible(false)
+	confirm := PopConfirmDlg("重启后台服务", "将要重启后台服务，期间网络连接会短暂中断，是否继续？", 250, 120)
+	m.tray.SetVisible(true)
+	if !confirm {
+		return
+	}
+	go func() {
+		m.SendNotify("重启后台服务", "正在重启后台服务……", NL_Info)
+		if err := ElevateToRestartService(); err != nil {
+			m.SendNotify("重启后台服务", "重启后台服务失败："+humanizeErr(err), NL_Error)
+			return
+		}
+		if !waitServiceRunning(30 * time.Second) {
+			m.SendNotify("重启后台服务", "后台服务未能在预期时间内恢复运行", NL_Error)
+			return
+		}
+		m.SendNotify("重启后台服务", "后台服务已恢复运行", NL_Info)
+	}()
+}
+
+// RunRepairSequence 一键修复常见故障（托盘"修复…"菜单项）：
+// 修复服务登记路径、重启服务（重建虚拟网卡）、恢复默认配置，
+// 每一步都通过通知反馈进度
+func (m *MiraMenu) RunRepairSequence() {
+	if userModeActive() {
+		go m.SendNotify("修复", "用户模式下暂不支持修复流程", NL_Warn)
+		return
+	}
+	m.tray.SetVisible(false)
+	confirm := PopConfirmDlg("修复", "将要重启后台服务并恢复默认配置以修复常见故障，期间网络连接会短暂中断，是否继续？", 300, 150)
+	m.tray.SetVisible(true)
+	if !confirm {
+		return
+	}
+	go func() {
+		if serviceBinaryPathMismatch() {
+			m.SendNotify("修复", "正在修复服务登记路径……", NL_Info)
+			if err := ElevateToRepairService(); err != nil {
+				m.SendNotify("修复", "修复服务登记路径失败："+humanizeErr(err), NL_Error)
+				return
+			}
+		}
+		m.SendNotify("修复", "正在重启后台服务（将重建虚拟网卡）……", NL_Info)
+		if err := ElevateToRestartService(); err != nil {
+			m.SendNotify("修复", "重启后台服务失败："+humanizeErr(err), NL_Error)
+			return
+		}
+		if !waitServiceRunning(30 * time.Second) {
+			m.SendNotify("修复", "后台服务未能在预期时间内恢复运行", NL_Error)
+			return
+		}
+		m.SendNotify("修复", "正在恢复默认配置……", NL_Info)
+		maskedPrefs := &ipn.MaskedPrefs{
+			Prefs: ipn.Prefs{
+				ShieldsUp:   false,
+				CorpDNS:     true,
+				RouteAll:    true,
+				ForceDaemon: false,
+			},
+			ShieldsUpSet:   true,
+			CorpDNSSet:     true,
+			RouteAllSet:    true,
+			ForceDaemonSet: true,
+		}
+		m.updatePref("恢复默认配置", maskedPrefs)
+		m.SendNotify("修复", "修复完成，状态正在重新同步", NL_Info)
+	}()
+}
+
+// waitServiceRunning 等待后台服务进入运行态，超时返回false
+func waitServiceRunning(timeout time.Duration) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	after := time.After(timeout)
+	for !isServiceRunning() {
+		select {
+		case <-ticker.C:
+		case <-after:
+			return false
+		}
+	}
+	return true
+}
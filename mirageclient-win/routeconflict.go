@@ -0,0 +1,187 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/net/tsaddr"
+)
+
+// routeConflictMemory 是本地保存的子网路由冲突处理偏好：
+// 以路由前缀为键，记录用户选择优先本地网络还是远端子网路由。
+type routeConflictMemory struct {
+	ByRoute map[string]string `json:"byRoute"` // 路由前缀 -> "local"或"remote"
+}
+
+// routeConflictCheckInterval 是检测子网路由与本地网段冲突的间隔。
+const routeConflictCheckInterval = 30 * time.Second
+
+func routeConflictPath() string {
+	return filepath.Join(programPath, "routeconflict.json")
+}
+
+func loadRouteConflictMemory() *routeConflictMemory {
+	mem := &routeConflictMemory{ByRoute: map[string]string{}}
+	b, err := os.ReadFile(routeConflictPath())
+	if err == nil {
+		json.Unmarshal(b, mem)
+	}
+	if mem.ByRoute == nil {
+		mem.ByRoute = map[string]string{}
+	}
+	return mem
+}
+
+func (mem *routeConflictMemory) save() {
+	b, err := json.MarshalIndent(mem, "", "\t")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(routeConflictPath(), b, 0600); err != nil {
+		log.Printf("[路由冲突] 保存设置失败: %v", err)
+	}
+}
+
+// localLANPrefixes 返回本机各物理网卡（不含回环和蜃境虚拟网卡）的
+// IPv4 网段。
+func localLANPrefixes() []netip.Prefix {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var pfxs []netip.Prefix
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if iface.Name == serviceName { // 蜃境虚拟网卡自身的路由不算冲突
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip, ok := netip.AddrFromSlice(ipnet.IP)
+			if !ok {
+				continue
+			}
+			ip = ip.Unmap()
+			if !ip.Is4() {
+				continue
+			}
+			ones, _ := ipnet.Mask.Size()
+			pfxs = append(pfxs, netip.PrefixFrom(ip, ones).Masked())
+		}
+	}
+	return pfxs
+}
+
+// acceptedSubnetRoutes 返回网络映射中各节点通告的子网路由
+// （不含出口节点的默认路由和节点自身地址）。
+func (m *MiraMenu) acceptedSubnetRoutes() []netip.Prefix {
+	netmap := m.data.NetMap
+	if netmap == nil {
+		return nil
+	}
+	var routes []netip.Prefix
+	for _, p := range netmap.Peers {
+		for _, r := range p.PrimaryRoutes {
+			if r == tsaddr.AllIPv4() || r == tsaddr.AllIPv6() {
+				continue
+			}
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// watchRouteConflicts 周期性检测已接受的子网路由是否与本地网段重叠。
+// 首次发现冲突时提醒用户并询问优先本地还是远端，按路由记录选择；
+// 选择优先本地时持续从路由表中移除该条蜃境路由（引擎重配置会重新
+// 添加，因此需要反复清理），保障本地打印机、NAS等直连访问。
+func (m *MiraMenu) watchRouteConflicts() {
+	ticker := time.NewTicker(routeConflictCheckInterval)
+	defer ticker.Stop()
+	asked := map[string]bool{} // 本次会话已询问过的路由，避免反复弹窗
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			ticker.Reset(powerAwareInterval(routeConflictCheckInterval))
+		}
+		if m.data.State != ipn.Running {
+			continue
+		}
+		if m.data.Prefs == nil || !m.data.Prefs.RouteAll {
+			continue
+		}
+		locals := localLANPrefixes()
+		if len(locals) == 0 {
+			continue
+		}
+		mem := loadRouteConflictMemory()
+		for _, route := range m.acceptedSubnetRoutes() {
+			var conflict netip.Prefix
+			for _, local := range locals {
+				if route.Overlaps(local) {
+					conflict = local
+					break
+				}
+			}
+			if !conflict.IsValid() {
+				continue
+			}
+			key := route.String()
+			choice, known := mem.ByRoute[key]
+			if !known {
+				if asked[key] {
+					continue
+				}
+				asked[key] = true
+				go m.SendNotify("子网路由冲突", "子网路由 "+key+" 与本地网段 "+conflict.String()+" 重叠", NL_Warn)
+				if PopConfirmDlg("子网路由冲突",
+					fmt.Sprintf("接受的子网路由 %s 与本地网段 %s 重叠，可能影响本地打印机、NAS等设备访问。\n\n是否优先使用本地网络？（选否则优先远端子网路由）", key, conflict), 360, 180) {
+					choice = "local"
+				} else {
+					choice = "remote"
+				}
+				mem.ByRoute[key] = choice
+				mem.save()
+				log.Printf("[路由冲突] 路由 %s 与本地网段 %s 冲突，用户选择优先%s", key, conflict,
+					map[string]string{"local": "本地", "remote": "远端"}[choice])
+			}
+			if choice == "local" {
+				removeConflictingRoute(route)
+			}
+		}
+	}
+}
+
+// removeConflictingRoute 从系统路由表删除一条蜃境下发的IPv4子网路由。
+func removeConflictingRoute(route netip.Prefix) {
+	if !route.Addr().Is4() {
+		return
+	}
+	mask := net.CIDRMask(route.Bits(), 32)
+	out, err := exec.Command("route", "delete", route.Masked().Addr().String(),
+		"mask", net.IP(mask).String()).CombinedOutput()
+	if err != nil {
+		log.Printf("[路由冲突] 删除路由 %s 失败: %v (%s)", route, err, out)
+	}
+}
@@ -0,0 +1,90 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+// prefCommitTimeout 是高风险配置变更应用后检验控制器连通性的窗口，
+// 窗口内持续不可达则自动回滚该变更。
+const prefCommitTimeout = 30 * time.Second
+
+// isRiskyPrefChange 报告该配置变更是否可能切断本机对外连通性：
+// 出口节点、子网路由接收与DNS接管都属于此类。
+func isRiskyPrefChange(mp *ipn.MaskedPrefs) bool {
+	return mp.ExitNodeIDSet || mp.ExitNodeIPSet || mp.RouteAllSet || mp.CorpDNSSet
+}
+
+// rollbackMaskFor 根据将要应用的MaskedPrefs和应用前的配置，构造一个
+// 能恢复被改动的高风险字段的回滚MaskedPrefs。
+func rollbackMaskFor(mp *ipn.MaskedPrefs, prev *ipn.Prefs) *ipn.MaskedPrefs {
+	rb := &ipn.MaskedPrefs{}
+	if mp.ExitNodeIDSet {
+		rb.Prefs.ExitNodeID = prev.ExitNodeID
+		rb.ExitNodeIDSet = true
+	}
+	if mp.ExitNodeIPSet {
+		rb.Prefs.ExitNodeIP = prev.ExitNodeIP
+		rb.ExitNodeIPSet = true
+	}
+	if mp.RouteAllSet {
+		rb.Prefs.RouteAll = prev.RouteAll
+		rb.RouteAllSet = true
+	}
+	if mp.CorpDNSSet {
+		rb.Prefs.CorpDNS = prev.CorpDNS
+		rb.CorpDNSSet = true
+	}
+	return rb
+}
+
+// controllerURL 返回当前使用的控制器URL，未知时为空串。
+func (m *MiraMenu) controllerURL() string {
+	if m.control_url != "" {
+		return m.control_url
+	}
+	if prefs, err := m.lc.GetPrefs(m.ctx); err == nil {
+		return prefs.ControlURL
+	}
+	return ""
+}
+
+// waitControllerReachable 在timeout窗口内反复探测控制器是否可达，
+// 任意一次成功即返回true。
+func (m *MiraMenu) waitControllerReachable(url string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	client := &http.Client{Timeout: 5 * time.Second}
+	for time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+		resp, err := client.Head(url)
+		if err == nil {
+			resp.Body.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// watchPrefCommit 在高风险配置变更应用后检验控制器连通性，
+// 超时窗口内持续不可达则用rollback回滚并通知用户。
+func (m *MiraMenu) watchPrefCommit(desc string, rollback *ipn.MaskedPrefs) {
+	url := m.controllerURL()
+	if url == "" {
+		return
+	}
+	if m.waitControllerReachable(url, prefCommitTimeout) {
+		log.Printf("[配置守护] %s 应用后控制器仍可达，保留设置", desc)
+		return
+	}
+	log.Printf("[配置守护] %s 应用后控制器持续不可达，自动回滚", desc)
+	if _, err := m.lc.EditPrefs(m.ctx, rollback); err != nil {
+		go m.SendNotify(desc, "失去连接后自动回滚失败:"+err.Error(), NL_Error)
+		return
+	}
+	go m.SendNotify(desc, "应用后与控制器失去连接，已自动回滚该设置", NL_Warn)
+}
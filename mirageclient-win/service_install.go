@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"syscall"
 	"time"
 
@@ -72,9 +73,75 @@ func InstallSystemDaemonWindows() (err error) {
 		return fmt.Errorf("failed to set service recovery actions: %v", err)
 	}
 
+	// 放宽服务DACL允许交互用户启动服务，这样托盘日常只需校验/
+	// 拉起服务，不必每次都弹UAC提权（安装时已提权过一次）
+	if err := allowServiceStartByUsers(service); err != nil {
+		log.Printf("放开服务启动权限失败（托盘将退回提权方式启动）: %v", err)
+	}
+
 	return nil
 }
 
+// allowServiceStartByUsers 在服务DACL上追加一条ACE，授予交互用户
+// 查询与启动服务的权限（不含停止/配置等写权限）
+func allowServiceStartByUsers(service *mgr.Service) error {
+	sd, err := windows.GetSecurityInfo(windows.Handle(service.Handle), windows.SE_SERVICE, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	sid, err := windows.CreateWellKnownSid(windows.WinInteractiveSid)
+	if err != nil {
+		return err
+	}
+	newDACL, err := windows.ACLFromEntries([]windows.EXPLICIT_ACCESS{{
+		AccessPermissions: windows.SERVICE_QUERY_STATUS | windows.SERVICE_START,
+		AccessMode:        windows.GRANT_ACCESS,
+		Inheritance:       windows.NO_INHERITANCE,
+		Trustee: windows.TRUSTEE{
+			TrusteeForm:  windows.TRUSTEE_IS_SID,
+			TrusteeType:  windows.TRUSTEE_IS_GROUP,
+			TrusteeValue: windows.TrusteeValueFromSID(sid),
+		},
+	}}, dacl)
+	if err != nil {
+		return err
+	}
+	return windows.SetSecurityInfo(windows.Handle(service.Handle), windows.SE_SERVICE, windows.DACL_SECURITY_INFORMATION, nil, nil, newDACL, nil)
+}
+
+// startServiceLowPriv 以普通用户权限拉起服务。服务安装时DACL已
+// 授予交互用户启动权限，因此无需提权
+func startServiceLowPriv() error {
+	scm, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseServiceHandle(scm)
+
+	namePtr, err := syscall.UTF16PtrFromString(serviceName)
+	if err != nil {
+		return err
+	}
+	h, err := windows.OpenService(scm, namePtr, windows.SERVICE_QUERY_STATUS|windows.SERVICE_START)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseServiceHandle(h)
+
+	var status windows.SERVICE_STATUS
+	if err := windows.QueryServiceStatus(h, &status); err != nil {
+		return err
+	}
+	if status.CurrentState == windows.SERVICE_RUNNING || status.CurrentState == windows.SERVICE_START_PENDING {
+		return nil
+	}
+	return windows.StartService(h, 0, nil)
+}
+
 func UninstallSystemDaemonWindows() (ret error) {
 	// Remove file sharing from Windows shell (noop in non-windows)
 	osshare.SetFileSharingEnabled(false, logger.Discard)
@@ -179,6 +246,202 @@ func ElevateToUinstallService() error {
 	return nil
 }
 
+// 读取已安装服务登记的可执行文件路径（低权限）
+func serviceBinaryPath() (string, error) {
+	m, err := winutil.ConnectToLocalSCMForRead()
+	if err != nil {
+		return "", err
+	}
+	defer m.Disconnect()
+
+	s, err := winutil.OpenServiceForRead(m, serviceName)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+
+	cfg, err := s.Config()
+	if err != nil {
+		return "", err
+	}
+	return cfg.BinaryPathName, nil
+}
+
+// 检查服务登记的程序路径是否仍指向当前程序。程序升级或被移动后
+// 二者会不一致，导致服务无法启动。
+func serviceBinaryPathMismatch() bool {
+	cfgPath, err := serviceBinaryPath()
+	if err != nil {
+		return false
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	return !strings.EqualFold(strings.Trim(cfgPath, `"`), exe)
+}
+
+// RepairServiceBinaryPath 将服务登记的程序路径重写为当前程序路径
+// （需要管理员权限，由 -repair-service 分支调用）
+func RepairServiceBinaryPath() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Windows service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	service, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to open %q service: %v", serviceName, err)
+	}
+	defer service.Close()
+
+	cfg, err := service.Config()
+	if err != nil {
+		return fmt.Errorf("failed to query service config: %v", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cfg.BinaryPathName = exe
+	return service.UpdateConfig(cfg)
+}
+
+// RestartSystemDaemonWindows 停止并重新启动后台服务
+// （需要管理员权限，由 -restart-service 分支调用）
+func RestartSystemDaemonWindows() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Windows service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	service, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to open %q service: %v", serviceName, err)
+	}
+	defer service.Close()
+
+	st, err := service.Query()
+	if err != nil {
+		return fmt.Errorf("failed to query service state: %v", err)
+	}
+	if st.State != svc.Stopped {
+		if _, err := service.Control(svc.Stop); err != nil {
+			return fmt.Errorf("failed to stop service: %v", err)
+		}
+		end := time.Now().Add(15 * time.Second)
+		for st.State != svc.Stopped && time.Until(end) > 0 {
+			time.Sleep(time.Second)
+			st, err = service.Query()
+			if err != nil {
+				return fmt.Errorf("failed to query service state: %v", err)
+			}
+		}
+		if st.State != svc.Stopped {
+			return errors.New("service did not stop in time")
+		}
+	}
+	return service.Start()
+}
+
+// ElevateToRestartService 以管理员权限重新调起自身执行服务重启
+func ElevateToRestartService() error {
+	exePath, err := os.Executable()
+	if err != nil {
+		log.Printf("获取当前程序路径出错%s", err)
+		return err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Printf("获取当前程序工作目录出错%s", err)
+		return err
+	}
+
+	verb := "runas"
+	args := "-restart-service"
+
+	verbPtr, _ := syscall.UTF16PtrFromString(verb)
+	exePtr, _ := syscall.UTF16PtrFromString(exePath)
+	cwdPtr, _ := syscall.UTF16PtrFromString(cwd)
+	argPtr, _ := syscall.UTF16PtrFromString(args)
+
+	var showCmd int32 = 0 //1-SW_NORMAL 0-SW_HIDE
+
+	err = windows.ShellExecute(0, verbPtr, exePtr, argPtr, cwdPtr, showCmd)
+	if err != nil {
+		log.Printf("执行服务重启进程失败：%s", err)
+		return err
+	}
+	return nil
+}
+
+func isServiceRestarter() bool {
+	if !args.asServiceRestarter {
+		return false
+	}
+	if !isServiceInstalled() {
+		log.Fatalf("服务尚未安装")
+		return true
+	}
+	if err := RestartSystemDaemonWindows(); err != nil {
+		log.Fatalf("服务重启执行失败: %v", err)
+	}
+	return true
+}
+
+// ElevateToRepairService 以管理员权限重新调起自身执行服务修复
+func ElevateToRepairService() error {
+	exePath, err := os.Executable()
+	if err != nil {
+		log.Printf("获取当前程序路径出错%s", err)
+		return err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Printf("获取当前程序工作目录出错%s", err)
+		return err
+	}
+
+	verb := "runas"
+	args := "-repair-service"
+
+	verbPtr, _ := syscall.UTF16PtrFromString(verb)
+	exePtr, _ := syscall.UTF16PtrFromString(exePath)
+	cwdPtr, _ := syscall.UTF16PtrFromString(cwd)
+	argPtr, _ := syscall.UTF16PtrFromString(args)
+
+	var showCmd int32 = 0 //1-SW_NORMAL 0-SW_HIDE
+
+	err = windows.ShellExecute(0, verbPtr, exePtr, argPtr, cwdPtr, showCmd)
+	if err != nil {
+		log.Printf("执行服务修复进程失败：%s", err)
+		return err
+	}
+	return nil
+}
+
+func isServiceRepairer() bool {
+	if !args.asServiceRepairer {
+		return false
+	}
+	if !isServiceInstalled() {
+		log.Fatalf("服务尚未安装")
+		return true
+	}
+	if err := RepairServiceBinaryPath(); err != nil {
+		log.Fatalf("服务修复执行失败: %v", err)
+		return true
+	}
+	if !isServiceRunning() {
+		if err := startService(); err != nil {
+			log.Fatalf("服务启动执行失败")
+		}
+	}
+	return true
+}
+
 // 判断后台服务是否已安装（低权限）
 func isServiceInstalled() bool {
 	m, err := winutil.ConnectToLocalSCMForRead()
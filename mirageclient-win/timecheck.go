@@ -0,0 +1,51 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxClockSkew 是允许的本机与控制器时间偏差。超过后TLS证书校验
+// 可能失败，登录和DERP连接会悄无声息地断掉。
+const maxClockSkew = 5 * time.Minute
+
+// checkTimeSkew 向控制器发起一次 HEAD 请求，比较其 Date 响应头与
+// 本机时间，偏差过大时弹出"系统时间不正确"提醒。
+func (m *MiraMenu) checkTimeSkew() {
+	url := m.control_url
+	if url == "" {
+		if prefs, err := m.lc.GetPrefs(m.ctx); err == nil {
+			url = prefs.ControlURL
+		}
+	}
+	if url == "" {
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Head(url)
+	if err != nil {
+		log.Printf("[时间校验] 控制器不可达: %v", err)
+		return
+	}
+	resp.Body.Close()
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	skew := time.Since(serverTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxClockSkew {
+		log.Printf("[时间校验] 本机时间与控制器相差 %v", skew.Round(time.Second))
+		go m.SendNotify("系统时间不正确",
+			fmt.Sprintf("本机时间与控制器相差约 %v，可能导致登录和安全连接失败，请校准系统时间后重试", skew.Round(time.Second)),
+			NL_Warn)
+	}
+}
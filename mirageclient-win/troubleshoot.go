@@ -0,0 +1,254 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tailscale/walk"
+	"github.com/tailscale/win"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// 网络诊断向导：按"后台服务→虚拟网卡→登录状态→DNS→控制器→司南→
+// 设备互通"的顺序逐层检测，在第一个失败的环节停下并给出针对性的
+// 处置建议，免去用户在群里贴日志反复排查的过程。
+
+// diagStep 是向导中的一个检测环节。
+type diagStep struct {
+	name string
+	run  func() (ok bool, detail, advice string)
+}
+
+// troubleshootSteps 返回按依赖顺序排列的检测环节。
+func (m *MiraMenu) troubleshootSteps() []diagStep {
+	ctl := func() string {
+		url := m.control_url
+		if url == "" {
+			if prefs, err := m.lc.GetPrefs(m.ctx); err == nil {
+				url = prefs.ControlURL
+			}
+		}
+		return url
+	}
+	return []diagStep{
+		{"后台服务", func() (bool, string, string) {
+			if userModeActive() {
+				return true, "用户模式运行，守护进程随本程序启动", ""
+			}
+			if isServiceRunning() {
+				return true, "服务运行中", ""
+			}
+			return false, "后台服务未在运行",
+				"请使用托盘菜单“配置项→重启后台服务”，仍失败时尝试“配置项→修复…”"
+		}},
+		{"虚拟网卡", func() (bool, string, string) {
+			ifs, err := net.Interfaces()
+			if err == nil {
+				for _, ifc := range ifs {
+					if ifc.Name == serviceName {
+						return true, "网卡 " + serviceName + " 存在", ""
+					}
+				}
+			}
+			if userModeActive() {
+				return true, "用户模式下可能使用用户态网络，无独立网卡", ""
+			}
+			return false, "未找到 " + serviceName + " 虚拟网卡",
+				"请使用“配置项→重启后台服务”重建网卡；反复出现时重新安装本程序"
+		}},
+		{"登录状态", func() (bool, string, string) {
+			ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+			defer cancel()
+			st, err := m.lc.Status(ctx)
+			if err != nil {
+				return false, "无法与守护进程通讯：" + humanizeErr(err),
+					"请使用“配置项→重启后台服务”后重试"
+			}
+			switch st.BackendState {
+			case ipn.Running.String():
+				return true, "已登录并处于连接状态", ""
+			case ipn.NeedsLogin.String():
+				return false, "当前未登录", "请在托盘菜单点击“登录…”完成登录"
+			case ipn.Stopped.String():
+				return false, "已登录但未连接", "请在托盘菜单点击“连接”"
+			}
+			return false, "当前状态：" + st.BackendState, "请稍候重试，状态长期异常时重启后台服务"
+		}},
+		{"DNS解析", func() (bool, string, string) {
+			u, err := url.Parse(ctl())
+			if err != nil || u.Host == "" {
+				return false, "尚未设置控制器地址", "请先通过“登录…”设置服务器代码"
+			}
+			host := u.Hostname()
+			if _, err := net.LookupHost(host); err != nil {
+				return false, "无法解析控制器域名 " + host,
+					"请检查本机DNS设置，或换用 114.114.114.114 / 223.5.5.5 等公共DNS后重试"
+			}
+			return true, "控制器域名解析正常", ""
+		}},
+		{"控制器连通", func() (bool, string, string) {
+			base := ctl()
+			if base == "" {
+				return false, "尚未设置控制器地址", "请先通过“登录…”设置服务器代码"
+			}
+			client := &http.Client{Timeout: 10 * time.Second}
+			resp, err := client.Head(base)
+			if err != nil {
+				return false, "控制器无法访问：" + humanizeErr(err),
+					"请检查本机网络与防火墙，确认所在网络允许访问 " + base
+			}
+			resp.Body.Close()
+			return true, fmt.Sprintf("控制器可访问（HTTP %d）", resp.StatusCode), ""
+		}},
+		{"司南中继", func() (bool, string, string) {
+			nm := m.data.NetMap
+			if nm == nil || nm.DERPMap == nil || len(nm.DERPMap.Regions) == 0 {
+				return true, "尚未获取到中继列表，跳过", ""
+			}
+			var host string
+			for _, region := range nm.DERPMap.Regions {
+				if len(region.Nodes) > 0 {
+					host = region.Nodes[0].HostName
+					break
+				}
+			}
+			if host == "" {
+				return true, "中继列表为空，跳过", ""
+			}
+			client := &http.Client{Timeout: 10 * time.Second}
+			resp, err := client.Get("https://" + host + "/derp/probe")
+			if err != nil {
+				return false, "中继 " + host + " 无法访问：" + humanizeErr(err),
+					"请检查所在网络是否放行到中继服务器的HTTPS(443)访问"
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return false, fmt.Sprintf("中继 %s 返回异常状态 %d", host, resp.StatusCode),
+					"中继服务器可能维护中，请稍后重试或联系管理员"
+			}
+			return true, "中继 " + host + " 可访问", ""
+		}},
+		{"设备互通", func() (bool, string, string) {
+			ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+			defer cancel()
+			st, err := m.lc.Status(ctx)
+			if err != nil {
+				return false, "无法获取设备状态：" + humanizeErr(err), "请重启后台服务后重试"
+			}
+			for _, ps := range st.Peer {
+				if !ps.Online || len(ps.TailscaleIPs) == 0 {
+					continue
+				}
+				name := strings.SplitN(ps.DNSName, ".", 2)[0]
+				res, err := m.lc.Ping(ctx, ps.TailscaleIPs[0], tailcfg.PingDisco)
+				if err != nil || res.Err != "" {
+					detail := ""
+					if err != nil {
+						detail = humanizeErr(err)
+					} else {
+						detail = res.Err
+					}
+					return false, "无法连通在线设备 " + name + "：" + detail,
+						"本机出入流量可能被防火墙拦截，可尝试“配置项→修复…”或检查对端设备"
+				}
+				via := "中继"
+				if res.Endpoint != "" {
+					via = "直连"
+				}
+				return true, fmt.Sprintf("与设备 %s 连通正常（%s，%.0fms）", name, via, res.LatencySeconds*1000), ""
+			}
+			return true, "当前没有其他在线设备，跳过", ""
+		}},
+	}
+}
+
+// ShowTroubleshootWindow 弹出网络诊断向导（配置项菜单"网络诊断…"）。
+func (m *MiraMenu) ShowTroubleshootWindow() {
+	const w, h = 440, 400
+	dlg, err := walk.NewDialogWithFixedSize(nil)
+	if err != nil {
+		log.Printf("[诊断向导] 创建窗口出错: %v", err)
+		return
+	}
+	dlg.SetTitle("网络诊断向导")
+	dlg.SetIcon(Icons[Logo])
+	dlg.SetMinMaxSize(walk.Size{Width: w, Height: h}, walk.Size{Width: w, Height: h})
+	dlg.SetX(int(win.GetSystemMetrics(win.SM_CXSCREEN)/2 - w/2))
+	dlg.SetY(int(win.GetSystemMetrics(win.SM_CYSCREEN)/2 - h/2))
+	dlg.SetLayout(walk.NewVBoxLayout())
+
+	text, err := walk.NewTextEdit(dlg)
+	if err != nil {
+		log.Printf("[诊断向导] 创建文本区出错: %v", err)
+		return
+	}
+	text.SetReadOnly(true)
+	text.SetText("点击“开始诊断”逐层检测网络各环节。\r\n")
+
+	row, err := walk.NewComposite(dlg)
+	if err != nil {
+		log.Printf("[诊断向导] 创建按钮区出错: %v", err)
+		return
+	}
+	row.SetLayout(walk.NewHBoxLayout())
+
+	appendLine := func(line string) {
+		m.mw.Synchronize(func() {
+			text.AppendText(line + "\r\n")
+		})
+	}
+
+	var startBtn *walk.PushButton
+	startBtn, err = walk.NewPushButton(row)
+	if err == nil {
+		startBtn.SetText("开始诊断")
+		startBtn.Clicked().Attach(func() {
+			startBtn.SetEnabled(false)
+			text.SetText("")
+			go func() {
+				defer m.mw.Synchronize(func() { startBtn.SetEnabled(true) })
+				steps := m.troubleshootSteps()
+				for i, step := range steps {
+					appendLine(fmt.Sprintf("[%d/%d] 正在检测：%s……", i+1, len(steps), step.name))
+					ok, detail, advice := step.run()
+					if ok {
+						appendLine("  ✓ " + detail)
+						continue
+					}
+					appendLine("  ✗ " + detail)
+					appendLine("")
+					appendLine("建议处置：" + advice)
+					log.Printf("[诊断向导] %s 检测失败: %s", step.name, detail)
+					return
+				}
+				appendLine("")
+				appendLine("各环节检测均通过。若仍有问题，可通过调试菜单导出自诊断报告提交。")
+			}()
+		})
+	}
+	copyBtn, err := walk.NewPushButton(row)
+	if err == nil {
+		copyBtn.SetText("复制结果")
+		copyBtn.Clicked().Attach(func() {
+			walk.Clipboard().SetText(text.Text())
+		})
+	}
+	closeBtn, err := walk.NewPushButton(row)
+	if err == nil {
+		closeBtn.SetText("关闭")
+		closeBtn.Clicked().Attach(func() {
+			dlg.Accept()
+		})
+	}
+
+	dlg.Run()
+}
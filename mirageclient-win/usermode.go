@@ -0,0 +1,47 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tailscale.com/envknob"
+	"tailscale.com/logpolicy"
+	"tailscale.com/logtail"
+)
+
+// userModeActive reports whether the client was started with
+// -user-mode, running the daemon in-process as the current user
+// instead of relying on the system service.
+func userModeActive() bool {
+	return args.asUserMode
+}
+
+// startUserMode redirects state and socket paths to per-user locations
+// that don't require elevation, then starts the daemon in-process. The
+// wintun adapter usually can't be created without admin rights, so the
+// engine falls back to userspace networking; subnet routing and exit
+// node use are unavailable in that mode.
+func startUserMode() {
+	programPath = filepath.Join(os.Getenv("LocalAppData"), serviceName)
+	socketPath = `\\.\pipe\Mirage-` + strings.ReplaceAll(os.Getenv("USERNAME"), `\`, "-")
+	tunNames = serviceName + ",userspace-networking"
+	if err := os.MkdirAll(programPath, 0700); err != nil {
+		log.Printf("用户模式状态目录创建失败: %v", err)
+	}
+
+	envknob.SetNoLogsNoSupport()
+	pol := logpolicy.New(logtail.CollectionNode)
+	pol.SetVerbosityLevel(0)
+	logPol = pol
+
+	go func() {
+		if err := StartDaemon(context.Background(), log.Printf, pol.PublicID.String()); err != nil {
+			log.Printf("用户模式守护进程退出: %v", err)
+		}
+	}()
+}
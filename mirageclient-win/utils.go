@@ -16,7 +16,11 @@ import (
 
 const serviceName = "Mirage"
 const defaultServerCode = "ipv4.uk"
-const socketPath = `\\.\pipe\ProtectedPrefix\Administrators\Mirage\miraged`
+
+// socketPath is the IPN socket; user mode replaces it with a per-user
+// pipe the daemon can create without elevation.
+var socketPath = `\\.\pipe\ProtectedPrefix\Administrators\Mirage\miraged`
+
 const enginePort = 0    //0 -动态端口机制
 const debugPort = 54321 // 调试信息页面端口
 
@@ -25,6 +29,12 @@ var programPath string = filepath.Join(os.Getenv("ProgramData"), serviceName)
 type BackendVersion string
 type WatcherUpEvent struct{}
 
+// DaemonUnresponsiveEvent 表示心跳探测认定守护进程失联：IPN总线
+// 长时间静默且轻量状态调用连续失败。Silent 为总线已静默的时长。
+type DaemonUnresponsiveEvent struct {
+	Silent time.Duration
+}
+
 // 根据运行状态设置图标
 func (m *MiraMenu) ChangeIconDueRunState() {
 	switch ipn.State(m.data.State) {
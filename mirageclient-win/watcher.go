@@ -5,11 +5,13 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -25,6 +27,86 @@ type MiraWatcher struct { // 通讯协程实体
 	isRunning bool               // 通信协程运行状态
 	Rx        chan interface{}   // 通信携程接收管道
 	Tx        chan interface{}   // 通信协程发送管道
+
+	subs []*watcherSub // 除主Tx管道外的独立订阅者
+
+	starts atomic.Int64 // Start被调用的次数（含通讯中断后的重启）
+
+	lastBusEvent atomic.Int64 // 最近一次从IPN总线收到事件的时间（UnixNano）
+}
+
+// 心跳探活参数：总线静默本身可能是正常的（网络长期无变化），
+// 因此静默超过 heartbeatBusSilence 后才开始用轻量状态调用探活，
+// 连续 heartbeatFailLimit 次失败才认定守护进程失联。
+const (
+	heartbeatInterval   = 30 * time.Second
+	heartbeatBusSilence = time.Minute
+	heartbeatFailLimit  = 3
+)
+
+// watcherSub 是一个独立的事件订阅者，带自己的缓冲管道和慢消费计数。
+type watcherSub struct {
+	name    string
+	ch      chan interface{}
+	dropped atomic.Int64
+}
+
+// Subscribe 注册一个独立的事件订阅者（如各菜单区或状态窗口），
+// 返回其专属的接收管道。缓冲满时事件会被丢弃而不是阻塞通讯协程。
+func (w *MiraWatcher) Subscribe(name string, buffer int) <-chan interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sub := &watcherSub{name: name, ch: make(chan interface{}, buffer)}
+	w.subs = append(w.subs, sub)
+	return sub.ch
+}
+
+// Unsubscribe 注销Subscribe返回的订阅管道并关闭它。
+func (w *MiraWatcher) Unsubscribe(ch <-chan interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, sub := range w.subs {
+		if sub.ch == ch {
+			w.subs = append(w.subs[:i], w.subs[i+1:]...)
+			close(sub.ch)
+			return
+		}
+	}
+}
+
+// publish 将事件发给主Tx管道和所有订阅者。主管道保持原有的阻塞语义；
+// 订阅者缓冲满时丢弃该事件并记录慢消费者。
+func (w *MiraWatcher) publish(msg interface{}) {
+	w.Tx <- msg
+	w.mu.Lock()
+	subs := append([]*watcherSub(nil), w.subs...)
+	w.mu.Unlock()
+	for _, sub := range subs {
+		select {
+		case sub.ch <- msg:
+		default:
+			n := sub.dropped.Add(1)
+			if n == 1 || n%100 == 0 {
+				log.Printf("[通讯兵] 订阅者 %s 消费过慢，已累计丢弃 %d 条事件", sub.name, n)
+			}
+		}
+	}
+}
+
+// DiagSnapshot 返回通讯协程的管道积压与订阅者状态，供自诊断窗口显示。
+func (w *MiraWatcher) DiagSnapshot() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	lines := []string{
+		fmt.Sprintf("启动次数: %d (含中断重启)", w.starts.Load()),
+		fmt.Sprintf("Rx管道积压: %d/%d", len(w.Rx), cap(w.Rx)),
+		fmt.Sprintf("Tx管道积压: %d/%d", len(w.Tx), cap(w.Tx)),
+	}
+	for _, sub := range w.subs {
+		lines = append(lines, fmt.Sprintf("订阅者 %s: 积压 %d/%d, 已丢弃 %d",
+			sub.name, len(sub.ch), cap(sub.ch), sub.dropped.Load()))
+	}
+	return lines
 }
 
 // 创建通讯协程函数
@@ -40,26 +122,56 @@ func NewWatcher() *MiraWatcher {
 }
 
 func (w *MiraWatcher) Start(ctx context.Context, LC tailscale.LocalClient) error {
+	w.starts.Add(1)
 
-	// 检查服务是否在正常运行
-	if !isServiceRunning() { // 未在正常运行以管理员权限调用尝试使其正常运行
-		err := ElevateToInstallService()
-		if err != nil {
-			w.Tx <- err
+	// 模拟模式：不连接后台服务，按脚本灌入事件
+	if args.demoScript != "" {
+		if err := w.runMockFeed(args.demoScript); err != nil {
+			w.publish(err)
 			return err
 		}
+		return nil
 	}
-	// 之后试探状态
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-	after := time.After(time.Second * 30)
-	for !isServiceRunning() {
-		select {
-		case <-ticker.C:
-		case <-after:
-			err := errors.New("后台服务未正常运行")
-			w.Tx <- err
-			return err
+
+	// 用户模式下守护进程在本进程内运行，无需检查系统服务
+	if !userModeActive() {
+		// 程序升级或被移动后服务登记的路径会失效，先自动修复
+		if isServiceInstalled() && serviceBinaryPathMismatch() {
+			log.Printf("后台服务登记路径与当前程序不一致，尝试自动修复")
+			if err := ElevateToRepairService(); err != nil {
+				w.publish(err)
+				return err
+			}
+		}
+		// 检查服务是否在正常运行。服务应已由安装包装好并放开了
+		// 启动权限，日常只需直接拉起；仅在服务缺失或直接拉起失败
+		// 时才退回提权方式，避免频繁弹UAC
+		if !isServiceRunning() {
+			if !isServiceInstalled() {
+				if err := ElevateToInstallService(); err != nil {
+					w.publish(err)
+					return err
+				}
+			} else if err := startServiceLowPriv(); err != nil {
+				log.Printf("直接启动服务失败，改用提权方式: %v", err)
+				if err := ElevateToInstallService(); err != nil {
+					w.publish(err)
+					return err
+				}
+			}
+		}
+		// 之后试探状态
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		after := time.After(time.Second * 30)
+		for !isServiceRunning() {
+			select {
+			case <-ticker.C:
+			case <-after:
+				err := errors.New("后台服务未正常运行")
+				w.publish(err)
+				return err
+			}
 		}
 	}
 
@@ -75,11 +187,11 @@ func (w *MiraWatcher) WatchDaemon(ctx context.Context, LC tailscale.LocalClient)
 	for {
 		if err.Error() == "Watcher is OK" {
 			log.Printf("守护进程监听管道建立完成")
-			w.Tx <- new(WatcherUpEvent)
+			w.publish(new(WatcherUpEvent))
 			break
 		} else if retryCounter == 0 {
 			err = errors.New("无法建立守护进程监听管道:" + err.Error())
-			w.Tx <- err
+			w.publish(err)
 			return
 		}
 		log.Printf("守护进程监听管道建立失败,等待1秒重试:" + err.Error())
@@ -90,6 +202,9 @@ func (w *MiraWatcher) WatchDaemon(ctx context.Context, LC tailscale.LocalClient)
 	}
 	defer watcher.Close()
 
+	w.lastBusEvent.Store(time.Now().UnixNano())
+	go w.heartbeat(watchCtx, LC)
+
 	go func() {
 		interrupt := make(chan os.Signal, 1)
 		signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
@@ -104,48 +219,98 @@ func (w *MiraWatcher) WatchDaemon(ctx context.Context, LC tailscale.LocalClient)
 		n, err := watcher.Next()
 		if err != nil {
 			log.Printf("[通讯兵] 收到错误消息: %s", err)
-			w.Tx <- err
+			w.publish(err)
 			if strings.Contains(err.Error(), "EOF") { // 通讯协程退出
 				return
 			}
 			continue
 		}
+		w.lastBusEvent.Store(time.Now().UnixNano())
 		if v := n.Version; v != "" {
 			log.Printf("[通讯兵] 收到版本号: %s", v)
-			w.Tx <- BackendVersion(v)
+			w.publish(BackendVersion(v))
 		}
 		if cliV := n.ClientVersion; cliV != nil {
 			log.Printf("[通讯兵] 收到客户端版本号: %v", cliV)
-			w.Tx <- cliV
+			w.publish(cliV)
 		}
 
 		if nm := n.NetMap; nm != nil {
 			log.Printf("[通讯兵] 收到网络图: %s", nm)
-			w.Tx <- nm
+			w.publish(nm)
 		}
 
 		if pref := n.Prefs; pref != nil {
 			log.Printf("[通讯兵] 收到首选项: %s", pref.Pretty())
-			w.Tx <- pref.AsStruct().Clone()
+			w.publish(pref.AsStruct().Clone())
 		}
 		if st := n.State; st != nil {
 			log.Printf("[通讯兵] 收到状态变化: %s", *st)
-			w.Tx <- *st
+			w.publish(*st)
 		}
 		if url := n.BrowseToURL; url != nil {
 			log.Printf("[通讯兵] 收到登录URL: %s", *url)
+			if policyAuthKey() != "" {
+				// 策略预配了认证密钥，由自动登录接管，不打开浏览器
+				log.Printf("[通讯兵] 存在策略认证密钥，不打开登录URL")
+				continue
+			}
+			if suppressAutoLoginOpen.Load() {
+				// 设备疑似被管理员移除，避免反复拉起浏览器
+				log.Printf("[通讯兵] 自动打开登录URL已暂停，忽略本条")
+				continue
+			}
 			prefs, err := LC.GetPrefs(ctx)
 			if err != nil {
 				break
 			}
 			if prefs.WantRunning {
-				open.Run(*url)
-				log.Printf("I opened this url: %s", *url)
+				if noteLoginURL(*url) {
+					open.Run(*url)
+					log.Printf("I opened this url: %s", *url)
+				} else {
+					log.Printf("[通讯兵] 登录URL与已打开过的相同，不再重复打开")
+				}
 			}
 		}
 	}
 }
 
+// heartbeat 定期检查守护进程是否仍可响应。总线静默超过
+// heartbeatBusSilence 后改用轻量的无节点状态调用探活，连续
+// heartbeatFailLimit 次失败即发布 DaemonUnresponsiveEvent，让界面
+// 提示用户而不是继续显示陈旧的连接状态（如命名管道卡死的场景）。
+func (w *MiraWatcher) heartbeat(ctx context.Context, LC tailscale.LocalClient) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		silent := time.Since(time.Unix(0, w.lastBusEvent.Load()))
+		if silent < heartbeatBusSilence {
+			failures = 0
+			continue
+		}
+		stCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		_, err := LC.StatusWithoutPeers(stCtx)
+		cancel()
+		if err == nil {
+			failures = 0
+			continue
+		}
+		failures++
+		log.Printf("[通讯兵] 心跳探测失败(%d/%d)，总线已静默 %v: %s",
+			failures, heartbeatFailLimit, silent.Round(time.Second), err)
+		if failures == heartbeatFailLimit {
+			w.publish(&DaemonUnresponsiveEvent{Silent: silent.Round(time.Second)})
+		}
+	}
+}
+
 func (w *MiraWatcher) GetWatcherWithTimeout(ctx context.Context, LC tailscale.LocalClient, timeout time.Duration) (watcher *tailscale.IPNBusWatcher, watchCtx context.Context, cancelWatch context.CancelFunc, err error) {
 	watchCtx, cancelWatch = context.WithCancel(ctx)
 	eg, egctx := errgroup.WithContext(watchCtx)
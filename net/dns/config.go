@@ -41,6 +41,10 @@ type Config struct {
 	// it to resolve, you also need to add appropriate routes to
 	// Routes.
 	Hosts map[dnsname.FQDN][]netip.Addr
+	// ReverseHosts maps additional IPs to names for reverse (PTR)
+	// lookups only, covering addresses that have no forward record in
+	// Hosts.
+	ReverseHosts map[netip.Addr]dnsname.FQDN
 	// OnlyIPv6, if true, uses the IPv6 service IP (for MagicDNS)
 	// instead of the IPv4 version (100.100.100.100).
 	OnlyIPv6 bool
@@ -0,0 +1,237 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"sort"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/dnsname"
+)
+
+// EDNS client subnet (RFC 7871) handling for forwarded queries.
+
+// ednsOptionECS is the EDNS0 option code for the client subnet option.
+const ednsOptionECS = 8
+
+// ECSPolicy says what to do with the EDNS client subnet option on
+// queries forwarded to a route's upstream resolvers.
+type ECSPolicy struct {
+	// Strip removes any client-supplied subnet option, so upstreams
+	// don't learn the querying client's network.
+	Strip bool
+
+	// Override, if valid, replaces the client subnet option with the
+	// given prefix (any client-supplied option is removed first). This
+	// lets queries appear to originate from a chosen network, e.g. for
+	// geo-targeted answers from a shared exit.
+	Override netip.Prefix
+}
+
+// active reports whether the policy requires rewriting queries at all.
+func (p ECSPolicy) active() bool {
+	return p.Strip || p.Override.IsValid()
+}
+
+// ecsRoute pairs a split-DNS suffix with its ECS policy.
+type ecsRoute struct {
+	Suffix dnsname.FQDN
+	Policy ECSPolicy
+}
+
+// setECSPolicies sets the per-suffix ECS policies for forwarded
+// queries. It's called by Resolver.SetConfig on reconfig.
+func (f *forwarder) setECSPolicies(policiesBySuffix map[dnsname.FQDN]ECSPolicy) {
+	routes := make([]ecsRoute, 0, len(policiesBySuffix))
+	for suffix, pol := range policiesBySuffix {
+		routes = append(routes, ecsRoute{Suffix: suffix, Policy: pol})
+	}
+	// Sort from longest prefix to shortest, like forwarding routes:
+	// queries match the most specific suffix only.
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Suffix.NumLabels() > routes[j].Suffix.NumLabels()
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ecsRoutes = routes
+}
+
+// ecsPolicyFor returns the ECS policy to apply to a query for domain,
+// if any.
+func (f *forwarder) ecsPolicyFor(domain dnsname.FQDN) (_ ECSPolicy, ok bool) {
+	f.mu.Lock()
+	routes := f.ecsRoutes
+	f.mu.Unlock()
+	for _, r := range routes {
+		if r.Suffix == "." || r.Suffix.Contains(domain) {
+			return r.Policy, r.Policy.active()
+		}
+	}
+	return ECSPolicy{}, false
+}
+
+// applyECSPolicy returns pkt with the policy applied: any client subnet
+// option stripped and/or replaced per pol. The second return value
+// reports whether the packet was modified; if false (including for
+// packets that couldn't be parsed, which are left for the upstream to
+// reject), pkt is returned unchanged.
+func applyECSPolicy(pkt []byte, pol ECSPolicy) ([]byte, bool) {
+	if !pol.active() || len(pkt) < headerBytes {
+		return pkt, false
+	}
+	qdCount := binary.BigEndian.Uint16(pkt[4:6])
+	anCount := binary.BigEndian.Uint16(pkt[6:8])
+	nsCount := binary.BigEndian.Uint16(pkt[8:10])
+	arCount := binary.BigEndian.Uint16(pkt[10:12])
+
+	off := headerBytes
+	for i := 0; i < int(qdCount); i++ {
+		off = skipDNSName(pkt, off)
+		if off < 0 || off+4 > len(pkt) {
+			return pkt, false
+		}
+		off += 4 // type, class
+	}
+	for i := 0; i < int(anCount)+int(nsCount); i++ {
+		off = skipDNSRecord(pkt, off)
+		if off < 0 {
+			return pkt, false
+		}
+	}
+
+	// Find the OPT pseudo-record in the additional section.
+	for i := 0; i < int(arCount); i++ {
+		nameEnd := skipDNSName(pkt, off)
+		if nameEnd < 0 || nameEnd+10 > len(pkt) {
+			return pkt, false
+		}
+		typ := dns.Type(binary.BigEndian.Uint16(pkt[nameEnd : nameEnd+2]))
+		rdLen := int(binary.BigEndian.Uint16(pkt[nameEnd+8 : nameEnd+10]))
+		rdStart := nameEnd + 10
+		if rdStart+rdLen > len(pkt) {
+			return pkt, false
+		}
+		if typ != dns.TypeOPT {
+			off = rdStart + rdLen
+			continue
+		}
+		newRData, changed := rewriteECSOptions(pkt[rdStart:rdStart+rdLen], pol)
+		if !changed {
+			return pkt, false
+		}
+		out := make([]byte, 0, len(pkt)-rdLen+len(newRData))
+		out = append(out, pkt[:nameEnd+8]...)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(newRData)))
+		out = append(out, newRData...)
+		out = append(out, pkt[rdStart+rdLen:]...)
+		return out, true
+	}
+
+	// No OPT record. Nothing to strip; add one if we're overriding.
+	if !pol.Override.IsValid() {
+		return pkt, false
+	}
+	out := make([]byte, len(pkt), len(pkt)+11+ecsOptionLen(pol.Override))
+	copy(out, pkt)
+	binary.BigEndian.PutUint16(out[10:12], arCount+1)
+	ecs := ecsOptionData(pol.Override)
+	out = append(out, 0) // root name
+	out = binary.BigEndian.AppendUint16(out, uint16(dns.TypeOPT))
+	out = binary.BigEndian.AppendUint16(out, maxResponseBytes) // UDP payload size
+	out = append(out, 0, 0, 0, 0)                              // extended RCODE, version, flags
+	out = binary.BigEndian.AppendUint16(out, uint16(len(ecs)))
+	out = append(out, ecs...)
+	return out, true
+}
+
+// rewriteECSOptions returns the OPT record's RDATA with any client
+// subnet option removed and, if pol.Override is valid, a fresh one
+// appended. It reports whether the result differs from rdata.
+func rewriteECSOptions(rdata []byte, pol ECSPolicy) (_ []byte, changed bool) {
+	out := make([]byte, 0, len(rdata))
+	for off := 0; off < len(rdata); {
+		if off+4 > len(rdata) {
+			return nil, false // malformed
+		}
+		code := binary.BigEndian.Uint16(rdata[off : off+2])
+		optLen := int(binary.BigEndian.Uint16(rdata[off+2 : off+4]))
+		if off+4+optLen > len(rdata) {
+			return nil, false // malformed
+		}
+		if code == ednsOptionECS {
+			changed = true // dropped
+		} else {
+			out = append(out, rdata[off:off+4+optLen]...)
+		}
+		off += 4 + optLen
+	}
+	if pol.Override.IsValid() {
+		out = append(out, ecsOptionData(pol.Override)...)
+		changed = true
+	}
+	return out, changed
+}
+
+// ecsOptionData returns a wire-format ECS option (code, length and
+// payload) for the given prefix, with scope prefix length zero.
+func ecsOptionData(p netip.Prefix) []byte {
+	addr := p.Masked().Addr()
+	family := uint16(1)
+	if addr.Is6() {
+		family = 2
+	}
+	addrBytes := addr.AsSlice()[:(p.Bits()+7)/8]
+	out := make([]byte, 0, 8+len(addrBytes))
+	out = binary.BigEndian.AppendUint16(out, ednsOptionECS)
+	out = binary.BigEndian.AppendUint16(out, uint16(4+len(addrBytes)))
+	out = binary.BigEndian.AppendUint16(out, family)
+	out = append(out, byte(p.Bits()), 0)
+	return append(out, addrBytes...)
+}
+
+func ecsOptionLen(p netip.Prefix) int {
+	return 8 + (p.Bits()+7)/8
+}
+
+// skipDNSName returns the offset just past the (possibly compressed)
+// name starting at off, or -1 if the packet is malformed.
+func skipDNSName(pkt []byte, off int) int {
+	for {
+		if off >= len(pkt) {
+			return -1
+		}
+		b := pkt[off]
+		switch {
+		case b == 0:
+			return off + 1
+		case b&0xC0 == 0xC0: // compression pointer ends the name
+			if off+2 > len(pkt) {
+				return -1
+			}
+			return off + 2
+		case b&0xC0 != 0:
+			return -1 // reserved label type
+		default:
+			off += 1 + int(b)
+		}
+	}
+}
+
+// skipDNSRecord returns the offset just past the resource record
+// starting at off, or -1 if the packet is malformed.
+func skipDNSRecord(pkt []byte, off int) int {
+	off = skipDNSName(pkt, off)
+	if off < 0 || off+10 > len(pkt) {
+		return -1
+	}
+	rdLen := int(binary.BigEndian.Uint16(pkt[off+8 : off+10]))
+	off += 10 + rdLen
+	if off > len(pkt) {
+		return -1
+	}
+	return off
+}
@@ -0,0 +1,213 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/dnsname"
+)
+
+// ecsQuery builds a query for name with an OPT record carrying the
+// given EDNS options (nil means no OPT record at all).
+func ecsQuery(t *testing.T, name string, options []dns.Option) []byte {
+	t.Helper()
+	b := dns.NewBuilder(nil, dns.Header{ID: 1234, RecursionDesired: true})
+	b.StartQuestions()
+	if err := b.Question(dns.Question{
+		Name:  dns.MustNewName(name),
+		Type:  dns.TypeA,
+		Class: dns.ClassINET,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if options != nil {
+		b.StartAdditionals()
+		if err := b.OPTResource(dns.ResourceHeader{
+			Name:  dns.MustNewName("."),
+			Type:  dns.TypeOPT,
+			Class: dns.Class(maxResponseBytes),
+		}, dns.OPTResource{Options: options}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkt, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+// ecsOptions parses pkt and returns the data of each ECS option found,
+// plus the data of any other options.
+func ecsOptions(t *testing.T, pkt []byte) (ecs [][]byte, other [][]byte) {
+	t.Helper()
+	var p dns.Parser
+	if _, err := p.Start(pkt); err != nil {
+		t.Fatalf("parsing rewritten packet: %v", err)
+	}
+	p.SkipAllQuestions()
+	p.SkipAllAnswers()
+	p.SkipAllAuthorities()
+	for {
+		h, err := p.AdditionalHeader()
+		if err == dns.ErrSectionDone {
+			return
+		}
+		if err != nil {
+			t.Fatalf("additional header: %v", err)
+		}
+		if h.Type != dns.TypeOPT {
+			p.SkipAdditional()
+			continue
+		}
+		r, err := p.OPTResource()
+		if err != nil {
+			t.Fatalf("OPT resource: %v", err)
+		}
+		for _, o := range r.Options {
+			if o.Code == ednsOptionECS {
+				ecs = append(ecs, o.Data)
+			} else {
+				other = append(other, o.Data)
+			}
+		}
+	}
+}
+
+func TestApplyECSPolicy(t *testing.T) {
+	clientECS := dns.Option{Code: ednsOptionECS, Data: []byte{0, 1, 24, 0, 192, 0, 2}}
+	cookie := dns.Option{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+
+	tests := []struct {
+		name        string
+		pkt         []byte
+		pol         ECSPolicy
+		wantChanged bool
+		wantECS     int // number of ECS options after rewriting
+		wantOther   int // number of non-ECS options preserved
+	}{
+		{
+			name:        "strip-removes-client-ecs",
+			pkt:         ecsQuery(t, "example.com.", []dns.Option{clientECS}),
+			pol:         ECSPolicy{Strip: true},
+			wantChanged: true,
+			wantECS:     0,
+		},
+		{
+			name:        "strip-preserves-other-options",
+			pkt:         ecsQuery(t, "example.com.", []dns.Option{clientECS, cookie}),
+			pol:         ECSPolicy{Strip: true},
+			wantChanged: true,
+			wantECS:     0,
+			wantOther:   1,
+		},
+		{
+			name:        "strip-no-ecs-no-change",
+			pkt:         ecsQuery(t, "example.com.", []dns.Option{cookie}),
+			pol:         ECSPolicy{Strip: true},
+			wantChanged: false,
+			wantECS:     0,
+			wantOther:   1,
+		},
+		{
+			name:        "override-replaces-client-ecs",
+			pkt:         ecsQuery(t, "example.com.", []dns.Option{clientECS}),
+			pol:         ECSPolicy{Override: netip.MustParsePrefix("198.51.100.0/24")},
+			wantChanged: true,
+			wantECS:     1,
+		},
+		{
+			name:        "override-adds-opt-when-absent",
+			pkt:         ecsQuery(t, "example.com.", nil),
+			pol:         ECSPolicy{Override: netip.MustParsePrefix("2001:db8::/48")},
+			wantChanged: true,
+			wantECS:     1,
+		},
+		{
+			name:        "inactive-policy-no-change",
+			pkt:         ecsQuery(t, "example.com.", []dns.Option{clientECS}),
+			pol:         ECSPolicy{},
+			wantChanged: false,
+			wantECS:     1,
+		},
+		{
+			name:        "truncated-packet-left-alone",
+			pkt:         []byte{0, 1, 0, 0},
+			pol:         ECSPolicy{Strip: true},
+			wantChanged: false,
+			wantECS:     -1, // unparseable; skip option check
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := applyECSPolicy(tt.pkt, tt.pol)
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v; want %v", changed, tt.wantChanged)
+			}
+			if !changed && !bytes.Equal(got, tt.pkt) {
+				t.Errorf("packet modified despite changed=false")
+			}
+			if tt.wantECS < 0 {
+				return
+			}
+			ecs, other := ecsOptions(t, got)
+			if len(ecs) != tt.wantECS {
+				t.Errorf("got %d ECS options; want %d", len(ecs), tt.wantECS)
+			}
+			if len(other) != tt.wantOther {
+				t.Errorf("got %d non-ECS options; want %d", len(other), tt.wantOther)
+			}
+		})
+	}
+}
+
+func TestApplyECSPolicyOverrideData(t *testing.T) {
+	pkt := ecsQuery(t, "example.com.", []dns.Option{
+		{Code: ednsOptionECS, Data: []byte{0, 1, 32, 0, 192, 0, 2, 55}},
+	})
+	got, changed := applyECSPolicy(pkt, ECSPolicy{Override: netip.MustParsePrefix("198.51.100.0/24")})
+	if !changed {
+		t.Fatal("packet not changed")
+	}
+	ecs, _ := ecsOptions(t, got)
+	if len(ecs) != 1 {
+		t.Fatalf("got %d ECS options; want 1", len(ecs))
+	}
+	want := []byte{0, 1, 24, 0, 198, 51, 100} // IPv4, /24, scope 0, truncated address
+	if !bytes.Equal(ecs[0], want) {
+		t.Errorf("ECS option data = %v; want %v", ecs[0], want)
+	}
+}
+
+func TestECSPolicyFor(t *testing.T) {
+	f := &forwarder{}
+	f.setECSPolicies(map[dnsname.FQDN]ECSPolicy{
+		".":            {Strip: true},
+		"corp.example": {Override: netip.MustParsePrefix("203.0.113.0/24")},
+	})
+
+	tests := []struct {
+		domain       dnsname.FQDN
+		wantOK       bool
+		wantOverride bool
+	}{
+		{"www.corp.example", true, true},
+		{"other.example.com.", true, false},
+	}
+	for _, tt := range tests {
+		pol, ok := f.ecsPolicyFor(tt.domain)
+		if ok != tt.wantOK {
+			t.Errorf("ecsPolicyFor(%q) ok = %v; want %v", tt.domain, ok, tt.wantOK)
+			continue
+		}
+		if got := pol.Override.IsValid(); got != tt.wantOverride {
+			t.Errorf("ecsPolicyFor(%q) override = %v; want %v", tt.domain, got, tt.wantOverride)
+		}
+	}
+}
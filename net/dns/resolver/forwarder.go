@@ -190,6 +190,15 @@ type forwarder struct {
 	// routes are per-suffix resolvers to use, with
 	// the most specific routes first.
 	routes []route
+	// ecsRoutes are per-suffix EDNS client subnet policies, with the
+	// most specific suffixes first.
+	ecsRoutes []ecsRoute
+	// caseRandomization is whether to randomize QNAME letter case on
+	// plaintext UDP forwards (DNS 0x20) and validate it in responses.
+	caseRandomization bool
+	// minimizeAnswers is whether to strip authority and non-OPT
+	// additional records from forwarded responses.
+	minimizeAnswers bool
 	// cloudHostFallback are last resort resolvers to use if no per-suffix
 	// resolver matches. These are only populated on cloud hosts where the
 	// platform provides a well-known recursive resolver.
@@ -510,7 +519,15 @@ func (f *forwarder) sendUDP(ctx context.Context, fq *forwardQuery, rr resolverAn
 	fq.closeOnCtxDone.Add(conn)
 	defer fq.closeOnCtxDone.Remove(conn)
 
-	if _, err := conn.WriteToUDPAddrPort(fq.packet, ipp); err != nil {
+	pkt := fq.packet
+	var origName, randName []byte
+	if f.useCaseRandomization() {
+		if rp, orig, rname, ok := randomizeQNAMECase(pkt); ok {
+			pkt, origName, randName = rp, orig, rname
+		}
+	}
+
+	if _, err := conn.WriteToUDPAddrPort(pkt, ipp); err != nil {
 		metricDNSFwdUDPErrorWrite.Add(1)
 		if err := ctx.Err(); err != nil {
 			return nil, err
@@ -546,6 +563,10 @@ func (f *forwarder) sendUDP(ctx context.Context, fq *forwardQuery, rr resolverAn
 		metricDNSFwdUDPErrorTxID.Add(1)
 		return nil, errors.New("txid doesn't match")
 	}
+	if origName != nil && !checkAndRestoreQNAMECase(out, origName, randName) {
+		metricDNSFwdUDPErrorTxID.Add(1)
+		return nil, errors.New("response QNAME case doesn't match")
+	}
 	rcode := getRCode(out)
 	// don't forward transient errors back to the client when the server fails
 	if rcode == dns.RCodeServerFailure {
@@ -661,6 +682,12 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 
 	clampEDNSSize(query.bs, maxResponseBytes)
 
+	if pol, ok := f.ecsPolicyFor(domain); ok {
+		if bs, changed := applyECSPolicy(query.bs, pol); changed {
+			query.bs = bs
+		}
+	}
+
 	if len(resolvers) == 0 {
 		resolvers = f.resolvers(domain)
 		if len(resolvers) == 0 {
@@ -722,6 +749,9 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	for {
 		select {
 		case v := <-resc:
+			if f.useMinimizeAnswers() {
+				v = minimizeAnswers(v)
+			}
 			select {
 			case <-ctx.Done():
 				metricDNSFwdErrorContext.Add(1)
@@ -0,0 +1,172 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"encoding/binary"
+	"math/rand"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+)
+
+// Hardening options for forwarded queries, aimed at the plaintext UDP
+// upstream path where off-path attackers can try to spoof responses.
+
+// setHardening sets the forwarder's hardening options. It's called by
+// Resolver.SetConfig on reconfig.
+func (f *forwarder) setHardening(caseRandomization, minimizeAnswers bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.caseRandomization = caseRandomization
+	f.minimizeAnswers = minimizeAnswers
+}
+
+func (f *forwarder) useCaseRandomization() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.caseRandomization
+}
+
+func (f *forwarder) useMinimizeAnswers() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.minimizeAnswers
+}
+
+// randomizeQNAMECase returns a copy of the query pkt with the letter
+// case of its first question name randomized ("DNS 0x20",
+// draft-vixie-dnsext-dns0x20), plus the original and randomized name
+// bytes for later response validation. ok is false if pkt doesn't look
+// like a query we can safely rewrite.
+func randomizeQNAMECase(pkt []byte) (out, origName, randName []byte, ok bool) {
+	if len(pkt) < headerBytes || binary.BigEndian.Uint16(pkt[4:6]) == 0 {
+		return nil, nil, nil, false
+	}
+	nameEnd := skipDNSName(pkt, headerBytes)
+	if nameEnd < 0 {
+		return nil, nil, nil, false
+	}
+	out = make([]byte, len(pkt))
+	copy(out, pkt)
+	name := out[headerBytes:nameEnd]
+	randomized := false
+	for i, b := range name {
+		if 'a' <= b|0x20 && b|0x20 <= 'z' && rand.Intn(2) == 1 {
+			name[i] = b ^ 0x20
+			randomized = true
+		}
+	}
+	if !randomized {
+		// Nothing to validate against (e.g. all-digit names); skip.
+		return nil, nil, nil, false
+	}
+	origName = append([]byte(nil), pkt[headerBytes:nameEnd]...)
+	randName = append([]byte(nil), name...)
+	return out, origName, randName, true
+}
+
+// checkAndRestoreQNAMECase verifies that the response resp echoes the
+// randomized question name exactly and, if so, rewrites it back to the
+// client's original case in place. A case mismatch indicates a spoofed
+// or corrupted response and reports false.
+func checkAndRestoreQNAMECase(resp, origName, randName []byte) bool {
+	if len(resp) < headerBytes {
+		return false
+	}
+	if binary.BigEndian.Uint16(resp[4:6]) == 0 {
+		// No question echoed (some servers do this for errors); there
+		// is nothing to validate or restore.
+		return true
+	}
+	nameEnd := skipDNSName(resp, headerBytes)
+	if nameEnd < 0 || nameEnd-headerBytes != len(randName) {
+		return false
+	}
+	got := resp[headerBytes:nameEnd]
+	for i := range got {
+		if got[i] != randName[i] {
+			return false
+		}
+	}
+	// Compressed names elsewhere in the response point back at these
+	// bytes, so rewriting them in place fixes the whole message.
+	copy(got, origName)
+	return true
+}
+
+// minimizeAnswers returns resp with its authority section dropped and
+// its additional section reduced to just the OPT pseudo-record. Clients
+// behind the forwarder have no use for delegation data, and unsolicited
+// records are a classic cache-poisoning vector. Unparseable responses
+// are returned unchanged.
+func minimizeAnswers(resp []byte) []byte {
+	if len(resp) < headerBytes {
+		return resp
+	}
+	qdCount := binary.BigEndian.Uint16(resp[4:6])
+	anCount := binary.BigEndian.Uint16(resp[6:8])
+	nsCount := binary.BigEndian.Uint16(resp[8:10])
+	arCount := binary.BigEndian.Uint16(resp[10:12])
+	if nsCount == 0 && arCount == 0 {
+		return resp
+	}
+
+	off := headerBytes
+	for i := 0; i < int(qdCount); i++ {
+		off = skipDNSName(resp, off)
+		if off < 0 || off+4 > len(resp) {
+			return resp
+		}
+		off += 4
+	}
+	for i := 0; i < int(anCount); i++ {
+		off = skipDNSRecord(resp, off)
+		if off < 0 {
+			return resp
+		}
+	}
+	answersEnd := off
+
+	// Skip authorities, then collect any OPT record from the
+	// additional section. OPT has a root name and name-free RDATA, so
+	// it can't contain compression pointers into the dropped records
+	// and is safe to relocate.
+	for i := 0; i < int(nsCount); i++ {
+		off = skipDNSRecord(resp, off)
+		if off < 0 {
+			return resp
+		}
+	}
+	var opt []byte
+	var kept uint16
+	for i := 0; i < int(arCount); i++ {
+		recStart := off
+		nameEnd := skipDNSName(resp, off)
+		if nameEnd < 0 || nameEnd+10 > len(resp) {
+			return resp
+		}
+		rdLen := int(binary.BigEndian.Uint16(resp[nameEnd+8 : nameEnd+10]))
+		off = nameEnd + 10 + rdLen
+		if off > len(resp) {
+			return resp
+		}
+		if dns.Type(binary.BigEndian.Uint16(resp[nameEnd:nameEnd+2])) == dns.TypeOPT {
+			if opt != nil {
+				// More than one OPT is malformed (RFC 6891); leave
+				// such a response for the client to judge rather
+				// than emit a miscounted rewrite.
+				return resp
+			}
+			opt = resp[recStart:off]
+			kept++
+		}
+	}
+
+	out := make([]byte, 0, answersEnd+len(opt))
+	out = append(out, resp[:answersEnd]...)
+	out = append(out, opt...)
+	binary.BigEndian.PutUint16(out[8:10], 0)
+	binary.BigEndian.PutUint16(out[10:12], kept)
+	return out
+}
@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+)
+
+func TestRandomizeQNAMECase(t *testing.T) {
+	pkt := ecsQuery(t, "some-long-hostname.example.com.", nil)
+	out, origName, randName, ok := randomizeQNAMECase(pkt)
+	if !ok {
+		t.Fatal("randomizeQNAMECase failed")
+	}
+	if bytes.Equal(pkt, out) {
+		t.Error("packet unchanged")
+	}
+	if !bytes.Equal(origName, pkt[headerBytes:headerBytes+len(origName)]) {
+		t.Error("origName doesn't match input packet")
+	}
+	if !bytes.EqualFold(origName, randName) {
+		t.Error("randomized name differs beyond case")
+	}
+	// Everything outside the question name must be untouched.
+	if !bytes.Equal(pkt[:headerBytes], out[:headerBytes]) ||
+		!bytes.Equal(pkt[headerBytes+len(origName):], out[headerBytes+len(origName):]) {
+		t.Error("bytes outside the question name modified")
+	}
+}
+
+func TestCheckAndRestoreQNAMECase(t *testing.T) {
+	pkt := ecsQuery(t, "some-long-hostname.example.com.", nil)
+	_, origName, randName, ok := randomizeQNAMECase(pkt)
+	if !ok {
+		t.Fatal("randomizeQNAMECase failed")
+	}
+
+	// A response echoing the randomized case validates and gets the
+	// original case restored.
+	resp := make([]byte, len(pkt))
+	copy(resp, pkt)
+	copy(resp[headerBytes:], randName)
+	if !checkAndRestoreQNAMECase(resp, origName, randName) {
+		t.Fatal("valid response rejected")
+	}
+	if !bytes.Equal(resp[headerBytes:headerBytes+len(origName)], origName) {
+		t.Error("original case not restored")
+	}
+
+	// A response with the original (non-randomized) case is a spoof
+	// indicator and must be rejected.
+	copy(resp[headerBytes:], origName)
+	if checkAndRestoreQNAMECase(resp, origName, randName) {
+		t.Error("response without randomized case accepted")
+	}
+
+	// A response with no question section is accepted as-is.
+	empty := make([]byte, headerBytes)
+	if !checkAndRestoreQNAMECase(empty, origName, randName) {
+		t.Error("question-less response rejected")
+	}
+}
+
+func TestMinimizeAnswers(t *testing.T) {
+	b := dns.NewBuilder(nil, dns.Header{ID: 1, Response: true})
+	b.StartQuestions()
+	if err := b.Question(dns.Question{
+		Name:  dns.MustNewName("example.com."),
+		Type:  dns.TypeA,
+		Class: dns.ClassINET,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b.StartAnswers()
+	if err := b.AResource(dns.ResourceHeader{
+		Name:  dns.MustNewName("example.com."),
+		Type:  dns.TypeA,
+		Class: dns.ClassINET,
+		TTL:   60,
+	}, dns.AResource{A: [4]byte{192, 0, 2, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	b.StartAuthorities()
+	if err := b.NSResource(dns.ResourceHeader{
+		Name:  dns.MustNewName("example.com."),
+		Type:  dns.TypeNS,
+		Class: dns.ClassINET,
+		TTL:   60,
+	}, dns.NSResource{NS: dns.MustNewName("ns.example.com.")}); err != nil {
+		t.Fatal(err)
+	}
+	b.StartAdditionals()
+	if err := b.AResource(dns.ResourceHeader{
+		Name:  dns.MustNewName("ns.example.com."),
+		Type:  dns.TypeA,
+		Class: dns.ClassINET,
+		TTL:   60,
+	}, dns.AResource{A: [4]byte{192, 0, 2, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.OPTResource(dns.ResourceHeader{
+		Name:  dns.MustNewName("."),
+		Type:  dns.TypeOPT,
+		Class: dns.Class(maxResponseBytes),
+	}, dns.OPTResource{}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := minimizeAnswers(resp)
+
+	var p dns.Parser
+	if _, err := p.Start(got); err != nil {
+		t.Fatalf("parsing minimized response: %v", err)
+	}
+	p.SkipAllQuestions()
+	answers, err := p.AllAnswers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) != 1 {
+		t.Errorf("got %d answers; want 1", len(answers))
+	}
+	authorities, err := p.AllAuthorities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authorities) != 0 {
+		t.Errorf("got %d authorities; want 0", len(authorities))
+	}
+	additionals, err := p.AllAdditionals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(additionals) != 1 || additionals[0].Header.Type != dns.TypeOPT {
+		t.Errorf("additionals = %v; want only the OPT record", additionals)
+	}
+}
+
+func TestMinimizeAnswersUnparseable(t *testing.T) {
+	junk := []byte(strings.Repeat("x", 20))
+	if got := minimizeAnswers(junk); !bytes.Equal(got, junk) {
+		t.Error("unparseable response modified")
+	}
+}
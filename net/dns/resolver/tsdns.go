@@ -74,6 +74,24 @@ type Config struct {
 	// LocalDomains is a list of DNS name suffixes that should not be
 	// routed to upstream resolvers.
 	LocalDomains []dnsname.FQDN
+	// ECSPolicies is an optional map of DNS name suffix to the EDNS
+	// client subnet policy to apply to queries forwarded within that
+	// suffix. Queries only match the most specific suffix, like Routes.
+	ECSPolicies map[dnsname.FQDN]ECSPolicy
+	// ReverseHosts maps additional IPs to FQDNs for reverse (PTR)
+	// lookups only. It covers addresses without a forward record in
+	// Hosts, such as peer IPv6 addresses that aren't exposed as
+	// MagicDNS A/AAAA records.
+	ReverseHosts map[netip.Addr]dnsname.FQDN
+	// CaseRandomization is whether to randomize the letter case of
+	// query names forwarded over plaintext UDP (DNS 0x20) and reject
+	// responses that don't echo the randomized case, making off-path
+	// spoofing harder.
+	CaseRandomization bool
+	// MinimizeAnswers is whether to strip authority and non-OPT
+	// additional records from forwarded responses before returning
+	// them to clients, reducing the cache-poisoning surface.
+	MinimizeAnswers bool
 }
 
 // WriteToBufioWriter write a debug version of c for logs to w, omitting
@@ -195,6 +213,7 @@ type Resolver struct {
 	localDomains []dnsname.FQDN
 	hostToIP     map[dnsname.FQDN][]netip.Addr
 	ipToHost     map[netip.Addr]dnsname.FQDN
+	reverseHosts map[netip.Addr]dnsname.FQDN // PTR-only entries, consulted after ipToHost
 }
 
 type ForwardLinkSelector interface {
@@ -238,12 +257,15 @@ func (r *Resolver) SetConfig(cfg Config) error {
 	}
 
 	r.forwarder.setRoutes(cfg.Routes)
+	r.forwarder.setECSPolicies(cfg.ECSPolicies)
+	r.forwarder.setHardening(cfg.CaseRandomization, cfg.MinimizeAnswers)
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.localDomains = cfg.LocalDomains
 	r.hostToIP = cfg.Hosts
 	r.ipToHost = reverse
+	r.reverseHosts = cfg.ReverseHosts
 	return nil
 }
 
@@ -771,6 +793,9 @@ func (r *Resolver) fqdnForIPLocked(ip netip.Addr, name dnsname.FQDN) (dnsname.FQ
 	}
 
 	ret, ok := r.ipToHost[ip]
+	if !ok {
+		ret, ok = r.reverseHosts[ip]
+	}
 	if !ok {
 		for _, suffix := range r.localDomains {
 			if suffix.Contains(name) {
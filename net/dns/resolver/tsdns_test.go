@@ -378,6 +378,35 @@ func TestResolveLocal(t *testing.T) {
 	}
 }
 
+func TestResolveLocalReverseFromNetmap(t *testing.T) {
+	r := newResolver(t)
+	defer r.Close()
+
+	cfg := dnsCfg
+	cfg.ReverseHosts = map[netip.Addr]dnsname.FQDN{
+		netip.MustParseAddr("100.101.102.104"): "reverseonly.ipn.dev.",
+	}
+	r.SetConfig(cfg)
+
+	// An address with only a synthesized reverse entry (no forward
+	// record in Hosts) still gets a PTR answer.
+	name, code := r.resolveLocalReverse(dnsname.FQDN("104.102.101.100.in-addr.arpa."))
+	if code != dns.RCodeSuccess {
+		t.Errorf("code = %v; want success", code)
+	}
+	if want := dnsname.FQDN("reverseonly.ipn.dev."); name != want {
+		t.Errorf("name = %v; want %v", name, want)
+	}
+	// Forward records still take precedence and are unaffected.
+	name, code = r.resolveLocalReverse(testipv4Arpa)
+	if code != dns.RCodeSuccess {
+		t.Errorf("code = %v; want success", code)
+	}
+	if want := dnsname.FQDN("test1.ipn.dev."); name != want {
+		t.Errorf("name = %v; want %v", name, want)
+	}
+}
+
 func TestResolveLocalReverse(t *testing.T) {
 	r := newResolver(t)
 	defer r.Close()
@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sockstats
+
+import (
+	"sync"
+)
+
+// deltaMu guards deltaBaselines.
+var deltaMu sync.Mutex
+
+// deltaBaselines holds, per consumer name, the stats at that consumer's
+// last SnapshotAndReset call.
+var deltaBaselines = map[string]map[Label]SockStat{}
+
+// SnapshotAndReset returns the socket statistics accumulated since the
+// previous SnapshotAndReset call with the same consumer name, and makes
+// the current totals the new baseline for that consumer. The first call
+// for a given consumer returns the totals since process start.
+//
+// It lets periodic uploaders (telemetry, diagnostics bundles) report
+// interval usage without each keeping its own previous-value
+// bookkeeping. Consumers are independent: resetting one consumer's
+// baseline doesn't affect another's.
+func SnapshotAndReset(consumer string) *SockStats {
+	deltaMu.Lock()
+	defer deltaMu.Unlock()
+	cur := get()
+	if cur == nil {
+		return nil
+	}
+	delta := deltaLocked(consumer, cur)
+	base := make(map[Label]SockStat, len(cur.Stats))
+	for label, stat := range cur.Stats {
+		base[label] = stat
+	}
+	deltaBaselines[consumer] = base
+	return delta
+}
+
+// DeltaSince is like SnapshotAndReset but doesn't move the consumer's
+// baseline, so repeated calls keep reporting usage since the same point.
+func DeltaSince(consumer string) *SockStats {
+	deltaMu.Lock()
+	defer deltaMu.Unlock()
+	cur := get()
+	if cur == nil {
+		return nil
+	}
+	return deltaLocked(consumer, cur)
+}
+
+func deltaLocked(consumer string, cur *SockStats) *SockStats {
+	base := deltaBaselines[consumer]
+	delta := &SockStats{
+		Stats:                    make(map[Label]SockStat, len(cur.Stats)),
+		CurrentInterfaceCellular: cur.CurrentInterfaceCellular,
+	}
+	for label, stat := range cur.Stats {
+		b := base[label]
+		// Counters are monotonic, but be defensive about platform
+		// counter resets rather than reporting huge wrapped values.
+		if stat.TxBytes < b.TxBytes || stat.RxBytes < b.RxBytes {
+			b = SockStat{}
+		}
+		delta.Stats[label] = SockStat{
+			TxBytes: stat.TxBytes - b.TxBytes,
+			RxBytes: stat.RxBytes - b.RxBytes,
+		}
+	}
+	return delta
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sockstats
+
+import (
+	"sort"
+	"sync"
+)
+
+// LabelInfo describes a Label for display purposes, so UIs can render
+// human-readable names instead of raw label constants.
+type LabelInfo struct {
+	Label       Label
+	Name        string // short display name, e.g. "控制连接"
+	Description string // longer explanation of what the socket carries
+	Category    string // grouping key, e.g. "control", "data", "logging"
+}
+
+// labelMu guards labelRegistry.
+var labelMu sync.Mutex
+
+// labelRegistry maps labels to their registered display metadata.
+var labelRegistry = map[Label]LabelInfo{}
+
+// RegisterLabel records display metadata for a label, overwriting any
+// previous registration. It's intended to be called from init functions
+// of the components that own the label. An empty Name falls back to the
+// label's generated String() form.
+func RegisterLabel(info LabelInfo) {
+	labelMu.Lock()
+	defer labelMu.Unlock()
+	labelRegistry[info.Label] = info
+}
+
+// LookupLabel returns the registered metadata for a label. Labels
+// without an explicit registration get a LabelInfo with just the
+// generated name filled in.
+func LookupLabel(label Label) LabelInfo {
+	labelMu.Lock()
+	defer labelMu.Unlock()
+	if info, ok := labelRegistry[label]; ok {
+		if info.Name == "" {
+			info.Name = label.String()
+		}
+		return info
+	}
+	return LabelInfo{Label: label, Name: label.String()}
+}
+
+// Labels enumerates the registered labels in Label order.
+func Labels() []LabelInfo {
+	labelMu.Lock()
+	defer labelMu.Unlock()
+	infos := make([]LabelInfo, 0, len(labelRegistry))
+	for _, info := range labelRegistry {
+		if info.Name == "" {
+			info.Name = info.Label.String()
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Label < infos[j].Label })
+	return infos
+}
+
+func init() {
+	// Seed the registry with the labels owned by this package, so
+	// enumeration is useful even before any component registers richer
+	// metadata.
+	for _, info := range []LabelInfo{
+		{Label: LabelControlClientAuto, Name: "控制连接", Description: "与控制器的长连接（netmap同步）", Category: "control"},
+		{Label: LabelControlClientDialer, Name: "控制拨号", Description: "建立控制连接时的拨号流量", Category: "control"},
+		{Label: LabelDERPHTTPClient, Name: "中继流量", Description: "经DERP中继收发的流量", Category: "data"},
+		{Label: LabelLogtailLogger, Name: "日志上传", Description: "logtail日志上报", Category: "logging"},
+		{Label: LabelDNSForwarderDoH, Name: "DNS转发(DoH)", Description: "DNS over HTTPS上游查询", Category: "dns"},
+		{Label: LabelDNSForwarderUDP, Name: "DNS转发(UDP)", Description: "UDP上游DNS查询", Category: "dns"},
+		{Label: LabelNetcheckClient, Name: "网络探测", Description: "netcheck延迟与连通性探测", Category: "control"},
+		{Label: LabelPortmapperClient, Name: "端口映射", Description: "UPnP/NAT-PMP/PCP端口映射协商", Category: "control"},
+		{Label: LabelMagicsockConnUDP4, Name: "点对点流量(IPv4)", Description: "magicsock IPv4直连流量", Category: "data"},
+		{Label: LabelMagicsockConnUDP6, Name: "点对点流量(IPv6)", Description: "magicsock IPv6直连流量", Category: "data"},
+		{Label: LabelNetlogLogger, Name: "流量统计上报", Description: "网络流量日志上报", Category: "logging"},
+		{Label: LabelSockstatlogLogger, Name: "套接字统计日志", Description: "sockstats本地统计日志", Category: "logging"},
+	} {
+		labelRegistry[info.Label] = info
+	}
+}
@@ -14,8 +14,6 @@ import (
 	"tailscale.com/util/must"
 )
 
-// TODO(bradfitz): fuzz this.
-
 func ExampleRequest() {
 	txID := stun.NewTxID()
 	req := stun.Request(txID)
@@ -283,3 +281,30 @@ func TestResponse(t *testing.T) {
 		}
 	}
 }
+
+// FuzzStunParse checks that the parsers never panic on arbitrary input
+// and that whatever they accept round-trips through the encoders.
+func FuzzStunParse(f *testing.F) {
+	for _, tt := range responseTests {
+		f.Add(tt.data)
+	}
+	f.Add(stun.Request(stun.NewTxID()))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if tx, addr, err := stun.ParseResponse(data); err == nil {
+			res := stun.Response(tx, addr)
+			tx2, addr2, err := stun.ParseResponse(res)
+			if err != nil {
+				t.Fatalf("accepted response %x did not round-trip: %v", data, err)
+			}
+			if tx2 != tx || addr2 != addr {
+				t.Fatalf("round-trip of %x: got (%v, %v), want (%v, %v)", data, tx2, addr2, tx, addr)
+			}
+		}
+		if tx, err := stun.ParseBindingRequest(data); err == nil {
+			tx2, err := stun.ParseBindingRequest(stun.Request(tx))
+			if err != nil || tx2 != tx {
+				t.Fatalf("accepted binding request %x did not round-trip: tx=%v tx2=%v err=%v", data, tx, tx2, err)
+			}
+		}
+	})
+}
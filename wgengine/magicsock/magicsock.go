@@ -457,6 +457,7 @@ type Conn struct {
 	privateKey  key.NodePrivate    // WireGuard private key for this node
 	everHadKey  bool               // whether we ever had a non-zero private key
 	myDerp      int                // nearest DERP region ID; 0 means none/unknown
+	forcedDerp  int                // if non-zero, debug override for the preferred DERP region
 	derpStarted chan struct{}      // closed on first connection to DERP; for tests & cleaner Close
 	activeDerp  map[int]activeDerp // DERP regionID -> connection to a node in that region
 	prevDerp    map[int]*syncs.WaitGroupChan
@@ -872,6 +873,12 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 	ni.WorkingUDP.Set(report.UDP)
 	ni.WorkingICMPv4.Set(report.ICMPv4)
 	ni.PreferredDERP = report.PreferredDERP
+	c.mu.Lock()
+	forcedDerp := c.forcedDerp
+	c.mu.Unlock()
+	if forcedDerp != 0 {
+		ni.PreferredDERP = forcedDerp
+	}
 
 	if ni.PreferredDERP == 0 {
 		// Perhaps UDP is blocked. Pick a deterministic but arbitrary
@@ -1067,6 +1074,21 @@ func (c *Conn) DiscoPublicKey() key.DiscoPublic {
 	return c.discoPublic
 }
 
+// SetForcedPreferredDERP sets regionID as the preferred DERP region,
+// overriding the netcheck-measured nearest region, until it's called
+// again with 0 to restore automatic selection.
+//
+// c.mu must NOT be held.
+func (c *Conn) SetForcedPreferredDERP(regionID int) {
+	c.mu.Lock()
+	c.forcedDerp = regionID
+	c.mu.Unlock()
+	if regionID != 0 {
+		c.setNearestDERP(regionID)
+	}
+	c.ReSTUN("forced-preferred-derp")
+}
+
 // c.mu must NOT be held.
 func (c *Conn) setNearestDERP(derpNum int) (wantDERP bool) {
 	c.mu.Lock()